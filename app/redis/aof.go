@@ -0,0 +1,612 @@
+package redis
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type AppendFsync string
+
+const (
+	AppendFsyncAlways   AppendFsync = "always"
+	AppendFsyncEverySec AppendFsync = "everysec"
+	AppendFsyncNo       AppendFsync = "no"
+)
+
+func ParseAppendFsync(input string) (AppendFsync, error) {
+	switch fsync := AppendFsync(strings.ToLower(input)); fsync {
+	case AppendFsyncAlways, AppendFsyncEverySec, AppendFsyncNo:
+		return fsync, nil
+	}
+
+	return "", fmt.Errorf("invalid appendfsync policy %q", input)
+}
+
+// AOFConfig configures the multi-part append only file, laid out like Redis 7 does:
+// a base file, incremental files and a manifest listing them, all in DirName.
+type AOFConfig struct {
+	Enabled  bool
+	DirName  string
+	FileName string
+	Fsync    AppendFsync
+	// LoadTruncated allows starting from an AOF whose last command was only partially written.
+	LoadTruncated bool
+}
+
+func DefaultAOFConfig() AOFConfig {
+	return AOFConfig{
+		Enabled:       false,
+		DirName:       "appendonlydir",
+		FileName:      "appendonly.aof",
+		Fsync:         AppendFsyncEverySec,
+		LoadTruncated: true,
+	}
+}
+
+func WithAOF(config AOFConfig) func(*Server) {
+	return func(s *Server) {
+		s.aof.AOFConfig = config
+	}
+}
+
+var ErrRewriteInProgress = errors.New("background append only file rewriting already in progress")
+
+const (
+	aofTypeBase    = "b"
+	aofTypeIncr    = "i"
+	aofTypeHistory = "h"
+)
+
+type aofFile struct {
+	name string
+	seq  int
+	kind string
+}
+
+type aofManifest struct {
+	base  *aofFile
+	incrs []aofFile
+}
+
+func parseAOFManifest(r io.Reader) (aofManifest, error) {
+	manifest := aofManifest{}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields)%2 != 0 {
+			return aofManifest{}, fmt.Errorf("invalid AOF manifest line: %q", line)
+		}
+
+		file := aofFile{}
+		for i := 0; i < len(fields); i += 2 {
+			switch fields[i] {
+			case "file":
+				file.name = fields[i+1]
+			case "seq":
+				seq, err := strconv.Atoi(fields[i+1])
+				if err != nil {
+					return aofManifest{}, fmt.Errorf("invalid AOF manifest seq: %q", line)
+				}
+				file.seq = seq
+			case "type":
+				file.kind = fields[i+1]
+			}
+		}
+
+		switch file.kind {
+		case aofTypeBase:
+			manifest.base = &file
+		case aofTypeIncr:
+			manifest.incrs = append(manifest.incrs, file)
+		case aofTypeHistory:
+		default:
+			return aofManifest{}, fmt.Errorf("invalid AOF manifest file type: %q", line)
+		}
+	}
+
+	return manifest, scanner.Err()
+}
+
+func (m aofManifest) encode() []byte {
+	var buf bytes.Buffer
+	if m.base != nil {
+		fmt.Fprintf(&buf, "file %s seq %d type %s\n", m.base.name, m.base.seq, aofTypeBase)
+	}
+	for _, incr := range m.incrs {
+		fmt.Fprintf(&buf, "file %s seq %d type %s\n", incr.name, incr.seq, aofTypeIncr)
+	}
+
+	return buf.Bytes()
+}
+
+func (m aofManifest) files() []aofFile {
+	files := []aofFile{}
+	if m.base != nil {
+		files = append(files, *m.base)
+	}
+
+	return append(files, m.incrs...)
+}
+
+type aofState struct {
+	AOFConfig
+
+	mu       sync.Mutex
+	manifest aofManifest
+	// file is the incremental file commands are appended to.
+	file *os.File
+	// size is the length of file up to the last command written in full.
+	size int64
+	// buf holds the commands not written to file yet, because writing failed.
+	buf []byte
+	// writeErr is why the last write failed, nil if it succeeded.
+	writeErr error
+	// db is the database last selected in the commands appended, -1 if none was.
+	db        int
+	unsynced  bool
+	rewriting bool
+}
+
+func (s *Server) aofDir() string {
+	return filepath.Join(s.dir, s.aof.DirName)
+}
+
+func (s *Server) aofManifestPath() string {
+	return filepath.Join(s.aofDir(), s.aof.FileName+".manifest")
+}
+
+// loadAOF replays the AOF described by the manifest. Without a manifest, the
+// dataset comes from the RDB file and a fresh AOF is written from it.
+func (s *Server) loadAOF() error {
+	err := os.MkdirAll(s.aofDir(), 0o755)
+	if err != nil {
+		return fmt.Errorf("failed to create AOF directory: %w", err)
+	}
+
+	file, err := os.Open(s.aofManifestPath())
+	if errors.Is(err, os.ErrNotExist) {
+		err = s.loadRDB()
+		if err != nil {
+			return err
+		}
+
+		s.logger.Println("Creating the AOF from the current dataset")
+		return s.rewriteAOF(false)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open AOF manifest: %w", err)
+	}
+
+	manifest, err := parseAOFManifest(file)
+	file.Close()
+	if err != nil {
+		return err
+	}
+
+	if manifest.base != nil {
+		err = s.loadAOFFile(*manifest.base, false)
+		if err != nil {
+			return err
+		}
+	}
+	for i, incr := range manifest.incrs {
+		err = s.loadAOFFile(incr, i == len(manifest.incrs)-1)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.aof.mu.Lock()
+	defer s.aof.mu.Unlock()
+
+	s.aof.manifest = manifest
+	if len(manifest.incrs) == 0 {
+		return s.openAOFIncr()
+	}
+
+	last := manifest.incrs[len(manifest.incrs)-1]
+	s.aof.file, s.aof.size, err = openAOFFile(filepath.Join(s.aofDir(), last.name), 0)
+	if err != nil {
+		return err
+	}
+	s.aof.db = -1
+
+	s.logger.Printf("Loaded the dataset from %s\n", s.aofManifestPath())
+	return nil
+}
+
+func (s *Server) loadAOFFile(file aofFile, last bool) error {
+	path := filepath.Join(s.aofDir(), file.name)
+	if strings.HasSuffix(file.name, ".rdb") {
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to open AOF base file: %w", err)
+		}
+		defer f.Close()
+
+		snapshot, err := ReadRDB(bufio.NewReader(f))
+		if err != nil {
+			return fmt.Errorf("failed to read AOF base file %s: %w", path, err)
+		}
+
+		return s.client.Load(snapshot)
+	}
+
+	return s.replayAOF(path, last)
+}
+
+// replayAOF applies every command of the file. A command cut short at the end
+// of the last file is what a crash in the middle of a write leaves behind;
+// when allowed, the file is truncated to the last complete command.
+func (s *Server) replayAOF(path string, last bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open AOF file: %w", err)
+	}
+	defer file.Close()
+
+	resp := NewResp(file)
+	session := NewSession()
+	session.replaying = true
+	valid := 0
+	for {
+		value, raw, err := resp.ReadRaw()
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			if !last || !s.aof.LoadTruncated {
+				return fmt.Errorf("AOF file %s is truncated at offset %d", path, valid)
+			}
+
+			s.logger.Printf("AOF file %s is truncated, dropping the incomplete command at offset %d\n", path, valid)
+			return os.Truncate(path, int64(valid))
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read AOF file %s at offset %d: %w", path, valid, err)
+		}
+
+		if value.Type != Array && value.Type != Bulk && value.Type != SimpleString {
+			return fmt.Errorf("%w: expected a command in AOF file %s at offset %d, got %s", ErrInvalidResp, path, valid, value.Type)
+		}
+
+		cmd := NewCommand(value)
+		_, err = s.client.Handle(session, cmd)
+		if err != nil {
+			return fmt.Errorf("failed to replay AOF command %q: %w", value.Format(), err)
+		}
+		// The replayed commands are already in the AOF.
+		session.takePropagated()
+
+		valid += len(raw)
+	}
+}
+
+// openAOFIncr starts a new incremental file and records it in the manifest.
+// It must be called with aof.mu held.
+func (s *Server) openAOFIncr() error {
+	seq := 1
+	if len(s.aof.manifest.incrs) > 0 {
+		seq = s.aof.manifest.incrs[len(s.aof.manifest.incrs)-1].seq + 1
+	}
+
+	// The commands still waiting to be written belong to the current file.
+	if s.aof.file != nil {
+		err := s.flushAOF()
+		if err != nil {
+			return err
+		}
+	}
+
+	incr := aofFile{name: fmt.Sprintf("%s.%d.incr.aof", s.aof.FileName, seq), seq: seq, kind: aofTypeIncr}
+	// A file left over with the same name, by an AOF since turned off, is not part of this one.
+	file, size, err := openAOFFile(filepath.Join(s.aofDir(), incr.name), os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+
+	manifest := s.aof.manifest
+	manifest.incrs = append(append([]aofFile{}, manifest.incrs...), incr)
+	err = s.writeAOFManifest(manifest)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	if s.aof.file != nil {
+		s.aof.file.Sync()
+		s.aof.file.Close()
+	}
+	s.aof.file = file
+	s.aof.size = size
+	s.aof.manifest = manifest
+	s.aof.db = -1
+
+	return nil
+}
+
+// openAOFFile opens an incremental file for appending, with the extra flags, and returns its size.
+func openAOFFile(path string, flag int) (*os.File, int64, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE|flag, 0o644)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open AOF file: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, fmt.Errorf("failed to open AOF file: %w", err)
+	}
+
+	return file, info.Size(), nil
+}
+
+func (s *Server) writeAOFManifest(manifest aofManifest) error {
+	tempPath := s.aofManifestPath() + ".tmp"
+	err := os.WriteFile(tempPath, manifest.encode(), 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to write AOF manifest: %w", err)
+	}
+
+	err = os.Rename(tempPath, s.aofManifestPath())
+	if err != nil {
+		return fmt.Errorf("failed to move AOF manifest in place: %w", err)
+	}
+
+	return nil
+}
+
+// feedAOF appends the command to the current incremental file. When the write
+// fails, the command is kept, along with the ones that follow, until a write succeeds.
+func (s *Server) feedAOF(db int, cmd Command) error {
+	s.aof.mu.Lock()
+	defer s.aof.mu.Unlock()
+
+	if !s.aof.Enabled || s.aof.file == nil {
+		return nil
+	}
+
+	if db >= 0 && db != s.aof.db {
+		s.aof.buf = append(s.aof.buf, NewCommandFromArgs(string(Select), strconv.Itoa(db)).value.Format()...)
+		s.aof.db = db
+	}
+	s.aof.buf = append(s.aof.buf, cmd.value.Format()...)
+
+	return s.flushAOF()
+}
+
+// flushAOF writes the pending commands to the current incremental file.
+// It must be called with aof.mu held.
+func (s *Server) flushAOF() error {
+	if len(s.aof.buf) == 0 {
+		return nil
+	}
+
+	n, err := s.aof.file.Write(s.aof.buf)
+	if err != nil {
+		// A command written in part would corrupt the file once the rest follows it,
+		// so the file is cut back to its last complete command. If that fails too,
+		// the part written is dropped from the buffer, for the next write to complete it.
+		if n > 0 {
+			truncateErr := s.aof.file.Truncate(s.aof.size)
+			if truncateErr != nil {
+				s.aof.size += int64(n)
+				s.aof.buf = s.aof.buf[n:]
+			}
+		}
+		if s.aof.writeErr == nil {
+			s.logger.Println("Failed to append to the AOF, refusing writes until it succeeds:", err)
+		}
+		s.aof.writeErr = err
+		return fmt.Errorf("failed to append to AOF: %w", err)
+	}
+
+	s.aof.size += int64(n)
+	s.aof.buf = s.aof.buf[:0]
+	if s.aof.writeErr != nil {
+		s.logger.Println("Appending to the AOF works again, accepting writes")
+		s.aof.writeErr = nil
+	}
+
+	if s.aof.Fsync == AppendFsyncAlways {
+		return s.aof.file.Sync()
+	}
+
+	s.aof.unsynced = true
+	return nil
+}
+
+// aofEnabled reports whether commands are appended to the AOF.
+func (s *Server) aofEnabled() bool {
+	s.aof.mu.Lock()
+	defer s.aof.mu.Unlock()
+
+	return s.aof.Enabled
+}
+
+// setAOFEnabled turns appending to the AOF on or off, as CONFIG SET appendonly does.
+// Turning it on writes a new AOF from the dataset in the background, which the
+// commands from then on are appended after.
+func (s *Server) setAOFEnabled(enabled bool) error {
+	s.aof.mu.Lock()
+	if s.aof.Enabled == enabled {
+		s.aof.mu.Unlock()
+		return nil
+	}
+
+	s.aof.Enabled = enabled
+	if !enabled {
+		if s.aof.file != nil {
+			s.aof.file.Sync()
+			s.aof.file.Close()
+		}
+		s.aof.file = nil
+		s.aof.buf = nil
+		s.aof.writeErr = nil
+		s.aof.unsynced = false
+		s.aof.mu.Unlock()
+		return nil
+	}
+	s.aof.mu.Unlock()
+
+	err := os.MkdirAll(s.aofDir(), 0o755)
+	if err != nil {
+		err = fmt.Errorf("failed to create AOF directory: %w", err)
+	} else {
+		err = s.rewriteAOF(true)
+	}
+	if err != nil {
+		s.aof.mu.Lock()
+		s.aof.Enabled = false
+		s.aof.mu.Unlock()
+		return err
+	}
+
+	return nil
+}
+
+// aofWriteError returns why appending to the AOF last failed, nil if it works.
+func (s *Server) aofWriteError() error {
+	s.aof.mu.Lock()
+	defer s.aof.mu.Unlock()
+
+	return s.aof.writeErr
+}
+
+// aofFsyncLoop flushes the AOF to disk every second with the everysec policy,
+// and retries writing the commands a failed write left pending. It runs even
+// while the AOF is off, as CONFIG SET can turn it on.
+func (s *Server) aofFsyncLoop(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.aof.mu.Lock()
+			if s.aof.writeErr != nil && s.aof.file != nil {
+				// The error was logged when writing first failed.
+				s.flushAOF()
+			}
+			if s.aof.Fsync == AppendFsyncEverySec && s.aof.unsynced && s.aof.file != nil {
+				err := s.aof.file.Sync()
+				if err != nil {
+					s.logger.Println("Failed to fsync the AOF:", err)
+				}
+				s.aof.unsynced = false
+			}
+			s.aof.mu.Unlock()
+		}
+	}
+}
+
+// rewriteAOF compacts the AOF into a new base file holding the current dataset.
+// Writes keep going to a new incremental file opened at the same moment the snapshot is taken.
+func (s *Server) rewriteAOF(background bool) error {
+	s.aof.mu.Lock()
+	if s.aof.rewriting {
+		s.aof.mu.Unlock()
+		return ErrRewriteInProgress
+	}
+	s.aof.rewriting = true
+	s.aof.mu.Unlock()
+
+	s.execMu.Lock()
+	s.aof.mu.Lock()
+	err := s.openAOFIncr()
+	if err != nil {
+		s.aof.rewriting = false
+		s.aof.mu.Unlock()
+		s.execMu.Unlock()
+		return err
+	}
+	incr := s.aof.manifest.incrs[len(s.aof.manifest.incrs)-1]
+	s.aof.mu.Unlock()
+	takeSnapshot := s.client.Snapshot()
+	s.execMu.Unlock()
+
+	if !background {
+		return s.finishAOFRewrite(takeSnapshot(), incr)
+	}
+
+	s.goTracked(func() {
+		err := s.finishAOFRewrite(takeSnapshot(), incr)
+		if err != nil {
+			s.logger.Println("Background AOF rewrite failed:", err)
+			return
+		}
+
+		s.logger.Println("Background AOF rewrite finished")
+	})
+
+	return nil
+}
+
+func (s *Server) finishAOFRewrite(snapshot Snapshot, incr aofFile) error {
+	defer func() {
+		s.aof.mu.Lock()
+		s.aof.rewriting = false
+		s.aof.mu.Unlock()
+	}()
+
+	s.aof.mu.Lock()
+	seq := 1
+	if s.aof.manifest.base != nil {
+		seq = s.aof.manifest.base.seq + 1
+	}
+	s.aof.mu.Unlock()
+	base := aofFile{name: fmt.Sprintf("%s.%d.base.rdb", s.aof.FileName, seq), seq: seq, kind: aofTypeBase}
+
+	err := writeRDBFile(filepath.Join(s.aofDir(), base.name), snapshot)
+	if err != nil {
+		return err
+	}
+
+	s.aof.mu.Lock()
+	defer s.aof.mu.Unlock()
+
+	previous := s.aof.manifest
+	manifest := aofManifest{base: &base}
+	for _, file := range previous.incrs {
+		if file.seq >= incr.seq {
+			manifest.incrs = append(manifest.incrs, file)
+		}
+	}
+
+	err = s.writeAOFManifest(manifest)
+	if err != nil {
+		return err
+	}
+	s.aof.manifest = manifest
+
+	for _, file := range previous.files() {
+		if file.kind == aofTypeBase && file.name == base.name || file.kind == aofTypeIncr && file.seq >= incr.seq {
+			continue
+		}
+
+		err := os.Remove(filepath.Join(s.aofDir(), file.name))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			s.logger.Printf("Failed to remove old AOF file %s: %v\n", file.name, err)
+		}
+	}
+
+	return nil
+}
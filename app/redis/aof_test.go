@@ -0,0 +1,71 @@
+package redis_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTruncatedAOF(t *testing.T) {
+	dir := t.TempDir()
+	aofDir := filepath.Join(dir, "appendonlydir")
+	assert.NoError(t, os.MkdirAll(aofDir, 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(aofDir, "appendonly.aof.manifest"),
+		[]byte("file appendonly.aof.1.incr.aof seq 1 type i\n"), 0o644))
+
+	// The lengths are not written the way Format would write them.
+	complete := "*03\r\n$+3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n"
+	path := filepath.Join(aofDir, "appendonly.aof.1.incr.aof")
+	assert.NoError(t, os.WriteFile(path, []byte(complete+"*3\r\n$3\r\nSET\r\n$5\r\nother"), 0o644))
+
+	config := redis.DefaultAOFConfig()
+	config.Enabled = true
+	server := startServer(t, redis.WithRDB(dir, "dump.rdb"), redis.WithAOF(config))
+	assert.Equal(t, redis.Value{Type: redis.Bulk, Bulk: "value"}, server.do("GET", "key"))
+	assert.Equal(t, redis.Value{Type: redis.NullBulk}, server.do("GET", "other"))
+
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, complete, string(content))
+}
+
+func TestAOFWriteError(t *testing.T) {
+	var server *redis.Server
+	config := redis.DefaultAOFConfig()
+	config.Enabled = true
+	client := startServer(t, redis.WithAOF(config), func(s *redis.Server) { server = s })
+
+	writable := server.SwapAOFFile(nil)
+	readOnly, err := os.Open(writable.Name())
+	assert.NoError(t, err)
+	defer readOnly.Close()
+	server.SwapAOFFile(readOnly)
+
+	// The command failing to reach the AOF was applied, only the next ones are refused.
+	assert.Equal(t, okReply, client.do("SET", "first", "1"))
+	reply := client.do("SET", "second", "2")
+	assert.Equal(t, redis.Error, reply.Type)
+	assert.Contains(t, reply.Error, "MISCONF Errors writing to the AOF file:")
+	assert.Equal(t, redis.Value{Type: redis.Bulk, Bulk: "1"}, client.do("GET", "first"))
+	assert.Contains(t, client.do("INFO", "persistence").Bulk, "aof_last_write_status:err")
+
+	// The pending command is written once the file can be written again.
+	server.SwapAOFFile(writable)
+	assert.Eventually(t, func() bool {
+		return client.do("SET", "second", "2").SimpleString == "OK"
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Contains(t, client.do("INFO", "persistence").Bulk, "aof_last_write_status:ok")
+
+	var want bytes.Buffer
+	for _, args := range [][]string{{"select", "0"}, {"SET", "first", "1"}, {"SET", "second", "2"}} {
+		assert.NoError(t, redis.NewCommandFromArgs(args...).Write(&want))
+	}
+	content, err := os.ReadFile(writable.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, want.String(), string(content))
+}
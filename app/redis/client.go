@@ -3,7 +3,12 @@ package redis
 import (
 	"errors"
 	"fmt"
+	"math"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var (
@@ -11,46 +16,668 @@ var (
 )
 
 type Client struct {
-	store Store
+	databases []Store
+	mu        sync.RWMutex
+	// frozen is held exclusively while a snapshot is copied, so it sees no half-applied command.
+	frozen sync.RWMutex
+	// dirty counts the changes made to the dataset.
+	dirty atomic.Int64
+
+	idgen *IDGenerator
+	nower Nower
+	// master is set while the client owns its dataset: expired keys are deleted as they
+	// are accessed, and keys are evicted. Replicas leave both to their master, whose DELs they apply.
+	master atomic.Bool
+
+	eviction evictionConfig
+	// evictedKeys counts the keys evicted to stay under maxmemory.
+	evictedKeys atomic.Int64
+	// expiredKeys counts the keys deleted because they expired.
+	expiredKeys atomic.Int64
+	// keyspaceHits and keyspaceMisses count the lookups of keys read by commands.
+	keyspaceHits   atomic.Int64
+	keyspaceMisses atomic.Int64
+}
+
+// ClientStats are the dataset statistics INFO reports.
+type ClientStats struct {
+	ExpiredKeys    int64
+	EvictedKeys    int64
+	KeyspaceHits   int64
+	KeyspaceMisses int64
+}
+
+// NewClient serves one logical database per store, indexed from 0.
+func NewClient(databases []Store, opts ...func(*Client)) *Client {
+	client := &Client{
+		databases: databases,
+		idgen:     NewIDGenerator(0),
+		nower:     time.Now,
+		eviction:  evictionConfig{policy: NoEviction, samples: DefaultMaxMemorySamples},
+	}
+	client.master.Store(true)
+
+	for _, opt := range opts {
+		opt(client)
+	}
+
+	return client
+}
+
+func WithIDGenerator(idgen *IDGenerator) func(*Client) {
+	return func(c *Client) {
+		c.idgen = idgen
+	}
+}
+
+// WithClientNower sets the clock relative expiry times are computed from.
+func WithClientNower(nower Nower) func(*Client) {
+	return func(c *Client) {
+		c.nower = nower
+	}
+}
+
+func (c *Client) Stats() ClientStats {
+	return ClientStats{
+		ExpiredKeys:    c.expiredKeys.Load(),
+		EvictedKeys:    c.evictedKeys.Load(),
+		KeyspaceHits:   c.keyspaceHits.Load(),
+		KeyspaceMisses: c.keyspaceMisses.Load(),
+	}
+}
+
+// ResetStats resets the statistics reported by INFO, as CONFIG RESETSTAT does.
+func (c *Client) ResetStats() {
+	c.expiredKeys.Store(0)
+	c.evictedKeys.Store(0)
+	c.keyspaceHits.Store(0)
+	c.keyspaceMisses.Store(0)
+}
+
+func (c *Client) SetMaster(master bool) {
+	c.master.Store(master)
+}
+
+func (c *Client) db(index int) Store {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.databases[index]
+}
+
+func (c *Client) Databases() int {
+	return len(c.databases)
 }
 
-func NewClient(store Store) *Client {
-	return &Client{store: store}
+// Dirty returns the number of changes made to the dataset since the client was created.
+func (c *Client) Dirty() int64 {
+	return c.dirty.Load()
 }
 
-func (c *Client) Handle(cmd Command) (Value, error) {
+// Snapshot freezes every database as it is now and returns the function copying them,
+// which must be called exactly once. Commands are held back only while the databases
+// are frozen, not while they are copied.
+func (c *Client) Snapshot() func() Snapshot {
+	c.frozen.Lock()
+	defer c.frozen.Unlock()
+
+	snapshot := Snapshot{
+		Databases: make([]map[string]Entry, len(c.databases)),
+		CreatedAt: time.Now(),
+	}
+	copies := make([]func() map[string]Entry, len(c.databases))
+	for i := range c.databases {
+		copies[i] = c.db(i).Freeze()
+	}
+	snapshot.IDGenNext, snapshot.IDGenSeq = c.idgen.State()
+
+	return func() Snapshot {
+		for i, copyEntries := range copies {
+			snapshot.Databases[i] = copyEntries()
+		}
+		return snapshot
+	}
+}
+
+// Load replaces the contents of every database with the snapshot.
+func (c *Client) Load(snapshot Snapshot) error {
+	if len(snapshot.Databases) > len(c.databases) {
+		return fmt.Errorf("snapshot has %d databases, only %d are configured", len(snapshot.Databases), len(c.databases))
+	}
+
+	c.frozen.Lock()
+	defer c.frozen.Unlock()
+
+	for i := range c.databases {
+		store := c.db(i)
+		store.Flush(false)
+		if i >= len(snapshot.Databases) {
+			continue
+		}
+
+		for key, entry := range snapshot.Databases[i] {
+			store.AddEntry(key, entry)
+		}
+	}
+
+	for name, last := range snapshot.IDGenNext {
+		c.idgen.AdvanceNext(name, last)
+	}
+	for name, last := range snapshot.IDGenSeq {
+		c.idgen.AdvanceSeq(name, last)
+	}
+
+	return nil
+}
+
+// ActiveExpire deletes the expired keys among count sampled keys of the database and returns their names.
+func (c *Client) ActiveExpire(index int, count int) []string {
+	c.frozen.RLock()
+	defer c.frozen.RUnlock()
+
+	deleted := c.db(index).DeleteExpiredSample(count)
+	c.dirty.Add(int64(len(deleted)))
+	c.expiredKeys.Add(int64(len(deleted)))
+
+	return deleted
+}
+
+// Handle executes the command. The commands replicating its effects are
+// collected in the session: write commands that changed the dataset are
+// propagated as they were sent, unless they recorded a rewritten form.
+func (c *Client) Handle(session *Session, cmd Command) (Value, error) {
+	c.frozen.RLock()
+	defer c.frozen.RUnlock()
+
+	store := c.db(session.db)
+	if c.master.Load() {
+		for _, key := range cmd.Keys() {
+			if store.DeleteExpired(key) {
+				c.dirty.Add(1)
+				c.expiredKeys.Add(1)
+				session.alsoPropagate(session.db, NewCommandFromArgs(string(Del), key))
+			}
+		}
+	}
+
+	dirty := c.dirty.Load()
+	propagated := len(session.propagated)
+	value, err := c.execute(session, store, cmd)
+	if err == nil && cmd.IsWrite() && c.dirty.Load() != dirty && len(session.propagated) == propagated {
+		db := session.db
+		if cmd.spec().flags&cmdNoDB != 0 {
+			db = -1
+		}
+		session.alsoPropagate(db, cmd)
+	}
+
+	return value, err
+}
+
+func (c *Client) execute(session *Session, store Store, cmd Command) (Value, error) {
 	switch cmd.Type {
 	case Ping:
 		return Value{Type: SimpleString, SimpleString: "PONG"}, nil
 	case Echo:
 		return Value{Type: Bulk, Bulk: cmd.Args[0]}, nil
 	case Get:
-		key := cmd.Args[0]
-		value, found := c.store.Get(key)
+		if len(cmd.Args) != 1 {
+			return wrongArgumentsError(cmd), nil
+		}
+
+		entry, found := c.lookupRead(store, cmd.Args[0])
 		if !found {
 			return Value{Type: NullBulk}, nil
 		}
-		return Value{Type: Bulk, Bulk: value}, nil
+		return Value{Type: Bulk, Bulk: entry.Value}, nil
 
 	case Set:
+		return c.set(session, store, cmd), nil
+
+	case Dump:
+		if len(cmd.Args) != 1 {
+			return wrongArgumentsError(cmd), nil
+		}
+
+		entry, found := c.lookupRead(store, cmd.Args[0])
+		if !found {
+			return Value{Type: NullBulk}, nil
+		}
+		return Value{Type: Bulk, Bulk: string(EncodeDump(entry.Value))}, nil
+
+	case Restore:
+		return c.restore(session, store, cmd), nil
+
+	case Object:
+		return c.object(store, cmd), nil
+
+	case Del, Exists:
+		if len(cmd.Args) == 0 {
+			return wrongArgumentsError(cmd), nil
+		}
+
+		count := 0
+		for _, key := range cmd.Args {
+			found := false
+			if cmd.Type == Exists {
+				_, found = c.lookupRead(store, key)
+			} else {
+				_, found = store.GetEntry(key)
+			}
+			if !found {
+				// On a replica, the master deletes keys that expired but were left in place.
+				if cmd.Type == Del {
+					store.DeleteExpired(key)
+				}
+				continue
+			}
+
+			count++
+			if cmd.Type == Del {
+				store.Delete(key)
+			}
+		}
+		if cmd.Type == Del {
+			c.dirty.Add(int64(count))
+		}
+
+		return Value{Type: Number, Number: count}, nil
+
+	case Expire, PExpire, ExpireAt, PExpireAt:
+		return c.expire(session, store, cmd), nil
+
+	case Persist:
+		if len(cmd.Args) != 1 {
+			return wrongArgumentsError(cmd), nil
+		}
+
+		entry, found := store.GetEntry(cmd.Args[0])
+		if !found || entry.ExpiresAt == nil {
+			return Value{Type: Number, Number: 0}, nil
+		}
+
+		entry.ExpiresAt = nil
+		store.SetEntry(cmd.Args[0], entry)
+		c.dirty.Add(1)
+		return Value{Type: Number, Number: 1}, nil
+
+	case TTL, PTTL:
+		if len(cmd.Args) != 1 {
+			return wrongArgumentsError(cmd), nil
+		}
+
+		entry, found := c.lookupRead(store, cmd.Args[0])
+		if !found {
+			return Value{Type: Number, Number: -2}, nil
+		}
+		if entry.ExpiresAt == nil {
+			return Value{Type: Number, Number: -1}, nil
+		}
+
+		remaining := max(entry.ExpiresAt.Sub(c.nower()).Milliseconds(), 0)
+		if cmd.Type == TTL {
+			remaining = (remaining + 500) / 1000
+		}
+		return Value{Type: Number, Number: int(remaining)}, nil
+
+	case Select:
+		if len(cmd.Args) != 1 {
+			return wrongArgumentsError(cmd), nil
+		}
+
+		index, err := strconv.Atoi(cmd.Args[0])
+		if err != nil {
+			return errorValue("ERR value is not an integer or out of range"), nil
+		}
+		if index < 0 || index >= len(c.databases) {
+			return errorValue("ERR DB index is out of range"), nil
+		}
+
+		session.db = index
+		return Value{Type: SimpleString, SimpleString: "OK"}, nil
+
+	case SwapDB:
+		if len(cmd.Args) != 2 {
+			return wrongArgumentsError(cmd), nil
+		}
+
+		first, err := strconv.Atoi(cmd.Args[0])
+		if err != nil {
+			return errorValue("ERR invalid first DB index"), nil
+		}
+		second, err := strconv.Atoi(cmd.Args[1])
+		if err != nil {
+			return errorValue("ERR invalid second DB index"), nil
+		}
+		if first < 0 || first >= len(c.databases) || second < 0 || second >= len(c.databases) {
+			return errorValue("ERR DB index is out of range"), nil
+		}
+
+		c.mu.Lock()
+		c.databases[first], c.databases[second] = c.databases[second], c.databases[first]
+		c.mu.Unlock()
+		c.dirty.Add(1)
+
+		return Value{Type: SimpleString, SimpleString: "OK"}, nil
+
+	case Move:
+		if len(cmd.Args) != 2 {
+			return wrongArgumentsError(cmd), nil
+		}
+
+		index, err := strconv.Atoi(cmd.Args[1])
+		if err != nil {
+			return errorValue("ERR value is not an integer or out of range"), nil
+		}
+		if index < 0 || index >= len(c.databases) {
+			return errorValue("ERR DB index is out of range"), nil
+		}
+		if index == session.db {
+			return errorValue("ERR source and destination objects are the same"), nil
+		}
+
 		key := cmd.Args[0]
-		value := cmd.Args[1]
+		entry, found := store.GetEntry(key)
+		if !found || !c.db(index).AddEntry(key, entry) {
+			return Value{Type: Number, Number: 0}, nil
+		}
 
-		var expiry *int
-		if len(cmd.Args) > 3 {
-			rawExpiryMs := cmd.Args[3]
-			expiryMs, err := strconv.Atoi(rawExpiryMs)
-			if err != nil {
-				return Value{}, fmt.Errorf("failed to convert expiry time to milliseconds: %w", err)
+		store.Delete(key)
+		c.dirty.Add(1)
+		return Value{Type: Number, Number: 1}, nil
+
+	case FlushDB, FlushAll:
+		if len(cmd.Args) > 1 {
+			return wrongArgumentsError(cmd), nil
+		}
+
+		async := false
+		if len(cmd.Args) == 1 {
+			switch strings.ToLower(cmd.Args[0]) {
+			case "async":
+				async = true
+			case "sync":
+			default:
+				return errorValue("ERR syntax error"), nil
 			}
+		}
+
+		if cmd.Type == FlushDB {
+			store.Flush(async)
+		} else {
+			for i := range c.databases {
+				c.db(i).Flush(async)
+			}
+		}
+		c.dirty.Add(1)
+
+		return Value{Type: SimpleString, SimpleString: "OK"}, nil
+
+	case DBSize:
+		if len(cmd.Args) != 0 {
+			return wrongArgumentsError(cmd), nil
+		}
+
+		return Value{Type: Number, Number: store.Len()}, nil
+
+	case RandomKey:
+		if len(cmd.Args) != 0 {
+			return wrongArgumentsError(cmd), nil
+		}
+
+		key, found := store.RandomKey()
+		if !found {
+			return Value{Type: NullBulk}, nil
+		}
+		return Value{Type: Bulk, Bulk: key}, nil
+
+	case IDGenNext:
+		if len(cmd.Args) != 1 && len(cmd.Args) != 3 {
+			return wrongArgumentsError(cmd), nil
+		}
 
-			expiry = &expiryMs
+		name := cmd.Args[0]
+		if len(cmd.Args) == 1 {
+			id := c.idgen.Next(name, 1)[0]
+			c.advanced(session, name, idgenSnowflake, id)
+			return Value{Type: Number, Number: int(id)}, nil
 		}
 
-		c.store.Set(key, value, expiry)
+		if !strings.EqualFold(cmd.Args[1], "count") {
+			return errorValue("ERR syntax error"), nil
+		}
+
+		count, err := strconv.Atoi(cmd.Args[2])
+		if err != nil || count < 1 || count > IDGenMaxCount {
+			return errorValue("ERR COUNT must be between 1 and %d", IDGenMaxCount), nil
+		}
+
+		ids := c.idgen.Next(name, count)
+		c.advanced(session, name, idgenSnowflake, ids[len(ids)-1])
+		values := make([]Value, len(ids))
+		for i, id := range ids {
+			values[i] = Value{Type: Number, Number: int(id)}
+		}
+		return Value{Type: Array, Array: values}, nil
+
+	case IDGenSeq:
+		if len(cmd.Args) != 1 {
+			return wrongArgumentsError(cmd), nil
+		}
+
+		seq := c.idgen.Seq(cmd.Args[0])
+		c.advanced(session, cmd.Args[0], idgenSequence, seq)
+		return Value{Type: Number, Number: int(seq)}, nil
+
+	case IDGenAdvance:
+		// The state only moves forward with what the master issued, clients could make it issue IDs twice.
+		if !session.master && !session.replaying {
+			return errorValue("ERR IDGEN.ADVANCE is only accepted from the master or the AOF"), nil
+		}
+		if len(cmd.Args) != 3 {
+			return wrongArgumentsError(cmd), nil
+		}
+
+		value, err := strconv.ParseInt(cmd.Args[2], 10, 64)
+		if err != nil {
+			return errorValue("ERR value is not an integer or out of range"), nil
+		}
+
+		switch idgenKind(strings.ToLower(cmd.Args[1])) {
+		case idgenSnowflake:
+			c.idgen.AdvanceNext(cmd.Args[0], value)
+		case idgenSequence:
+			c.idgen.AdvanceSeq(cmd.Args[0], value)
+		default:
+			return errorValue("ERR unknown IDGEN kind '%s'", cmd.Args[1]), nil
+		}
+		c.dirty.Add(1)
 
 		return Value{Type: SimpleString, SimpleString: "OK"}, nil
 	}
 
 	return Value{}, fmt.Errorf("%w: %v", ErrUnknownCommand, cmd)
 }
+
+// advanced records an IDGEN state change. Replicas receive the resulting
+// state rather than the command itself, so that they never issue the same
+// ID again once promoted.
+func (c *Client) advanced(session *Session, name string, kind idgenKind, last int64) {
+	c.dirty.Add(1)
+	session.alsoPropagate(-1, NewCommandFromArgs(string(IDGenAdvance), name, string(kind), strconv.FormatInt(last, 10)))
+}
+
+// set implements SET key value [NX|XX] [GET] [EX seconds|PX milliseconds|EXAT timestamp|PXAT timestamp|KEEPTTL].
+// Relative expiries are propagated as an absolute PXAT, so that replicas do not extend them.
+func (c *Client) set(session *Session, store Store, cmd Command) Value {
+	if len(cmd.Args) < 2 {
+		return wrongArgumentsError(cmd)
+	}
+
+	key, value := cmd.Args[0], cmd.Args[1]
+	var nx, xx, get, keepTTL bool
+	var expiresAt *time.Time
+	for i := 2; i < len(cmd.Args); i++ {
+		switch option := strings.ToUpper(cmd.Args[i]); option {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "GET":
+			get = true
+		case "KEEPTTL":
+			keepTTL = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if expiresAt != nil || i+1 == len(cmd.Args) {
+				return errorValue("ERR syntax error")
+			}
+
+			i++
+			amount, err := strconv.ParseInt(cmd.Args[i], 10, 64)
+			if err != nil {
+				return errorValue("ERR value is not an integer or out of range")
+			}
+			if amount <= 0 {
+				return errorValue("ERR invalid expire time in 'set' command")
+			}
+
+			at, ok := c.expiryTime(option, amount)
+			if !ok {
+				return errorValue("ERR invalid expire time in 'set' command")
+			}
+			expiresAt = &at
+		default:
+			return errorValue("ERR syntax error")
+		}
+	}
+	if nx && xx || keepTTL && expiresAt != nil {
+		return errorValue("ERR syntax error")
+	}
+
+	old, exists := store.GetEntry(key)
+	reply := Value{Type: SimpleString, SimpleString: "OK"}
+	if get {
+		reply = Value{Type: NullBulk}
+		if exists {
+			reply = Value{Type: Bulk, Bulk: old.Value}
+		}
+	}
+	if nx && exists || xx && !exists {
+		if get {
+			return reply
+		}
+		return Value{Type: NullBulk}
+	}
+
+	if keepTTL && exists {
+		expiresAt = old.ExpiresAt
+	}
+
+	store.SetEntry(key, Entry{Value: value, ExpiresAt: expiresAt})
+	c.dirty.Add(1)
+	if expiresAt != nil && !keepTTL {
+		session.alsoPropagate(session.db, NewCommandFromArgs(string(Set), key, value, "PXAT", strconv.FormatInt(expiresAt.UnixMilli(), 10)))
+	}
+
+	return reply
+}
+
+// expire implements EXPIRE, PEXPIRE, EXPIREAT and PEXPIREAT, with the NX, XX, GT and LT conditions.
+// The expiry is propagated as an absolute PEXPIREAT, or as a DEL if it is already in the past.
+func (c *Client) expire(session *Session, store Store, cmd Command) Value {
+	if len(cmd.Args) != 2 && len(cmd.Args) != 3 {
+		return wrongArgumentsError(cmd)
+	}
+
+	key := cmd.Args[0]
+	amount, err := strconv.ParseInt(cmd.Args[1], 10, 64)
+	if err != nil {
+		return errorValue("ERR value is not an integer or out of range")
+	}
+
+	units := map[CommandType]string{Expire: "EX", PExpire: "PX", ExpireAt: "EXAT", PExpireAt: "PXAT"}
+	at, ok := c.expiryTime(units[cmd.Type], amount)
+	if !ok {
+		return errorValue("ERR invalid expire time in '%s' command", cmd.Type)
+	}
+
+	entry, found := store.GetEntry(key)
+	if !found {
+		return Value{Type: Number, Number: 0}
+	}
+
+	if len(cmd.Args) == 3 {
+		var allowed bool
+		switch strings.ToUpper(cmd.Args[2]) {
+		case "NX":
+			allowed = entry.ExpiresAt == nil
+		case "XX":
+			allowed = entry.ExpiresAt != nil
+		case "GT":
+			// A key without an expiry never expires, so no expiry is greater.
+			allowed = entry.ExpiresAt != nil && at.After(*entry.ExpiresAt)
+		case "LT":
+			allowed = entry.ExpiresAt == nil || at.Before(*entry.ExpiresAt)
+		default:
+			return errorValue("ERR Unsupported option %s", cmd.Args[2])
+		}
+
+		if !allowed {
+			return Value{Type: Number, Number: 0}
+		}
+	}
+
+	c.dirty.Add(1)
+	if !at.After(c.nower()) {
+		store.Delete(key)
+		session.alsoPropagate(session.db, NewCommandFromArgs(string(Del), key))
+		return Value{Type: Number, Number: 1}
+	}
+
+	entry.ExpiresAt = &at
+	store.SetEntry(key, entry)
+	session.alsoPropagate(session.db, NewCommandFromArgs(string(PExpireAt), key, strconv.FormatInt(at.UnixMilli(), 10)))
+	return Value{Type: Number, Number: 1}
+}
+
+// expiryTime converts an amount in the unit of the EX, PX, EXAT or PXAT option to an absolute time.
+// It reports false if the time overflows the milliseconds since the epoch Redis keeps expiries in.
+func (c *Client) expiryTime(unit string, amount int64) (time.Time, bool) {
+	ms := amount
+	if unit == "EX" || unit == "EXAT" {
+		if amount > math.MaxInt64/1000 || amount < math.MinInt64/1000 {
+			return time.Time{}, false
+		}
+		ms = amount * 1000
+	}
+
+	if unit == "EX" || unit == "PX" {
+		now := c.nower().UnixMilli()
+		if ms > 0 && now > math.MaxInt64-ms || ms < 0 && now < math.MinInt64-ms {
+			return time.Time{}, false
+		}
+		ms += now
+	}
+
+	return time.UnixMilli(ms), true
+}
+
+// lookupRead looks up a key read by a command, counting the keyspace hits and misses.
+func (c *Client) lookupRead(store Store, key string) (Entry, bool) {
+	entry, found := store.GetEntry(key)
+	if found {
+		c.keyspaceHits.Add(1)
+	} else {
+		c.keyspaceMisses.Add(1)
+	}
+
+	return entry, found
+}
+
+func errorValue(format string, args ...any) Value {
+	return Value{Type: Error, Error: fmt.Sprintf(format, args...)}
+}
+
+func wrongArgumentsError(cmd Command) Value {
+	return errorValue("ERR wrong number of arguments for '%s' command", cmd.Type)
+}
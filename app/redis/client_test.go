@@ -0,0 +1,319 @@
+package redis_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestClient returns a client with one database and a session on it. Their
+// clock stands still until the returned time is moved.
+func newTestClient(opts ...func(*redis.Client)) (*redis.Client, *redis.Session, *time.Time) {
+	now := time.Date(2025, time.March, 1, 0, 0, 0, 0, time.UTC)
+	nower := func() time.Time { return now }
+	client := redis.NewClient(
+		[]redis.Store{redis.NewInMemoryStore(redis.WithNower(nower))},
+		append([]func(*redis.Client){redis.WithClientNower(nower)}, opts...)...,
+	)
+
+	return client, redis.NewSession(), &now
+}
+
+func TestClientExpiry(t *testing.T) {
+	type step struct {
+		args    []string
+		advance time.Duration
+		want    redis.Value
+	}
+
+	ok := redis.Value{Type: redis.SimpleString, SimpleString: "OK"}
+	null := redis.Value{Type: redis.NullBulk}
+	bulk := func(s string) redis.Value { return redis.Value{Type: redis.Bulk, Bulk: s} }
+	number := func(n int) redis.Value { return redis.Value{Type: redis.Number, Number: n} }
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "set with a relative expiry",
+			steps: []step{
+				{args: []string{"SET", "key", "value", "EX", "10"}, want: ok},
+				{args: []string{"TTL", "key"}, want: number(10)},
+				{args: []string{"GET", "key"}, advance: 11 * time.Second, want: null},
+				{args: []string{"TTL", "key"}, want: number(-2)},
+			},
+		},
+		{
+			name: "set conditions",
+			steps: []step{
+				{args: []string{"SET", "key", "1", "XX"}, want: null},
+				{args: []string{"SET", "key", "1", "NX"}, want: ok},
+				{args: []string{"SET", "key", "2", "NX"}, want: null},
+				{args: []string{"SET", "key", "3", "XX", "GET"}, want: bulk("1")},
+				{args: []string{"SET", "key", "4", "NX", "XX"}, want: redis.Value{Type: redis.Error, Error: "ERR syntax error"}},
+			},
+		},
+		{
+			name: "keep the expiry on overwrite",
+			steps: []step{
+				{args: []string{"SET", "key", "1", "PX", "5000"}, want: ok},
+				{args: []string{"SET", "key", "2", "KEEPTTL"}, want: ok},
+				{args: []string{"PTTL", "key"}, want: number(5000)},
+				{args: []string{"SET", "key", "3"}, want: ok},
+				{args: []string{"PTTL", "key"}, want: number(-1)},
+			},
+		},
+		{
+			name: "expire conditions",
+			steps: []step{
+				{args: []string{"SET", "key", "1"}, want: ok},
+				{args: []string{"EXPIRE", "key", "100", "XX"}, want: number(0)},
+				{args: []string{"EXPIRE", "key", "100", "GT"}, want: number(0)},
+				{args: []string{"EXPIRE", "key", "100", "NX"}, want: number(1)},
+				{args: []string{"EXPIRE", "key", "200", "LT"}, want: number(0)},
+				{args: []string{"EXPIRE", "key", "50", "LT"}, want: number(1)},
+				{args: []string{"PERSIST", "key"}, want: number(1)},
+				{args: []string{"TTL", "key"}, want: number(-1)},
+			},
+		},
+		{
+			name: "expire in the past deletes the key",
+			steps: []step{
+				{args: []string{"SET", "key", "1"}, want: ok},
+				{args: []string{"PEXPIREAT", "key", "1000"}, want: number(1)},
+				{args: []string{"EXISTS", "key"}, want: number(0)},
+			},
+		},
+		{
+			name: "delete counts existing keys",
+			steps: []step{
+				{args: []string{"SET", "a", "1"}, want: ok},
+				{args: []string{"SET", "b", "2", "PX", "10"}, want: ok},
+				{args: []string{"EXISTS", "a", "b", "c", "a"}, want: number(3)},
+				{args: []string{"DEL", "a", "b", "c"}, advance: time.Second, want: number(1)},
+			},
+		},
+		{
+			name: "expire times that overflow",
+			steps: []step{
+				{args: []string{"SET", "key", "1", "EX", "9300000000000000"}, want: redis.Value{Type: redis.Error, Error: "ERR invalid expire time in 'set' command"}},
+				{args: []string{"SET", "key", "1", "PX", "9223372036854775807"}, want: redis.Value{Type: redis.Error, Error: "ERR invalid expire time in 'set' command"}},
+				{args: []string{"SET", "key", "1"}, want: ok},
+				{args: []string{"EXPIRE", "key", "9300000000000000"}, want: redis.Value{Type: redis.Error, Error: "ERR invalid expire time in 'expire' command"}},
+				{args: []string{"TTL", "key"}, want: number(-1)},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, session, now := newTestClient()
+
+			for _, step := range tt.steps {
+				*now = now.Add(step.advance)
+
+				got, err := client.Handle(session, redis.NewCommandFromArgs(step.args...))
+				assert.NoError(t, err)
+				assert.Equal(t, step.want, got, step.args)
+			}
+		})
+	}
+}
+
+func TestClientDirty(t *testing.T) {
+	client := redis.NewClient([]redis.Store{redis.NewInMemoryStore()})
+	session := redis.NewSession()
+
+	for _, args := range [][]string{{"SET", "a", "1"}, {"SET", "b", "1"}, {"DEL", "a", "b", "c"}, {"DEL", "c"}} {
+		_, err := client.Handle(session, redis.NewCommandFromArgs(args...))
+		assert.NoError(t, err)
+	}
+
+	assert.Equal(t, int64(4), client.Dirty())
+}
+
+func TestClientEviction(t *testing.T) {
+	// Every key below takes 66 bytes, so 200 bytes fit three of them.
+	tests := []struct {
+		name    string
+		policy  redis.EvictionPolicy
+		steps   [][]string
+		fits    bool
+		evicted []string
+	}{
+		{
+			name:   "noeviction keeps every key",
+			policy: redis.NoEviction,
+			steps:  [][]string{{"SET", "a", "1"}, {"SET", "b", "2"}, {"SET", "c", "3"}, {"SET", "d", "4"}},
+			fits:   false,
+		},
+		{
+			name:    "allkeys-lru evicts the least recently accessed key",
+			policy:  redis.AllKeysLRU,
+			steps:   [][]string{{"SET", "a", "1"}, {"SET", "b", "2"}, {"SET", "c", "3"}, {"GET", "a"}, {"SET", "d", "4"}},
+			fits:    true,
+			evicted: []string{"b"},
+		},
+		{
+			name:    "volatile-ttl evicts the key closest to expiring",
+			policy:  redis.VolatileTTL,
+			steps:   [][]string{{"SET", "a", "1", "EX", "300"}, {"SET", "b", "2"}, {"SET", "c", "3", "EX", "100"}, {"SET", "d", "4", "EX", "200"}},
+			fits:    true,
+			evicted: []string{"c"},
+		},
+		{
+			name:   "volatile policies only evict keys with an expiry",
+			policy: redis.VolatileLRU,
+			steps:  [][]string{{"SET", "a", "1"}, {"SET", "b", "2"}, {"SET", "c", "3"}, {"SET", "d", "4"}},
+			fits:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, session, now := newTestClient(redis.WithMaxMemory(200, tt.policy, redis.DefaultMaxMemorySamples))
+
+			for _, args := range tt.steps {
+				*now = now.Add(time.Minute)
+				_, err := client.Handle(session, redis.NewCommandFromArgs(args...))
+				assert.NoError(t, err)
+			}
+
+			assert.Equal(t, tt.fits, client.Evict(session))
+			assert.Equal(t, int64(len(tt.evicted)), client.EvictedKeys())
+			for _, key := range tt.evicted {
+				got, err := client.Handle(session, redis.NewCommandFromArgs("EXISTS", key))
+				assert.NoError(t, err)
+				assert.Equal(t, redis.Value{Type: redis.Number, Number: 0}, got, key)
+			}
+		})
+	}
+}
+
+func TestClientObject(t *testing.T) {
+	client, session, now := newTestClient()
+
+	bulk := func(s string) redis.Value { return redis.Value{Type: redis.Bulk, Bulk: s} }
+	number := func(n int) redis.Value { return redis.Value{Type: redis.Number, Number: n} }
+	payload := string(redis.EncodeDump("restored"))
+
+	steps := []struct {
+		args    []string
+		advance time.Duration
+		want    redis.Value
+	}{
+		{args: []string{"SET", "int", "-1234"}, want: redis.Value{Type: redis.SimpleString, SimpleString: "OK"}},
+		{args: []string{"SET", "short", "hello"}, want: redis.Value{Type: redis.SimpleString, SimpleString: "OK"}},
+		{args: []string{"SET", "long", "this value is longer than forty four bytes, so it is raw"}, want: redis.Value{Type: redis.SimpleString, SimpleString: "OK"}},
+		{args: []string{"OBJECT", "ENCODING", "int"}, want: bulk("int")},
+		{args: []string{"OBJECT", "ENCODING", "short"}, want: bulk("embstr")},
+		{args: []string{"OBJECT", "ENCODING", "long"}, want: bulk("raw")},
+		{args: []string{"OBJECT", "ENCODING", "missing"}, want: redis.Value{Type: redis.NullBulk}},
+		{args: []string{"OBJECT", "FREQ", "int"}, want: redis.Value{Type: redis.Error, Error: "ERR An LFU maxmemory policy is not selected, access frequency not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust."}},
+		{args: []string{"OBJECT", "IDLETIME", "short"}, advance: 10 * time.Second, want: number(10)},
+		{args: []string{"OBJECT", "IDLETIME", "short"}, advance: 5 * time.Second, want: number(15)},
+		{args: []string{"GET", "short"}, want: bulk("hello")},
+		{args: []string{"OBJECT", "IDLETIME", "short"}, advance: 2 * time.Second, want: number(2)},
+		{args: []string{"OBJECT", "REFCOUNT", "short"}, want: number(1)},
+		{args: []string{"RESTORE", "restored", "0", payload, "IDLETIME", "100"}, want: redis.Value{Type: redis.SimpleString, SimpleString: "OK"}},
+		{args: []string{"OBJECT", "IDLETIME", "restored"}, want: number(100)},
+		{args: []string{"OBJECT", "LENGTH", "short"}, want: redis.Value{Type: redis.Error, Error: "ERR unknown subcommand 'LENGTH'. Try OBJECT HELP."}},
+	}
+
+	for _, step := range steps {
+		*now = now.Add(step.advance)
+
+		got, err := client.Handle(session, redis.NewCommandFromArgs(step.args...))
+		assert.NoError(t, err)
+		assert.Equal(t, step.want, got, step.args)
+	}
+}
+
+func TestClientObjectLFU(t *testing.T) {
+	client, session, _ := newTestClient(redis.WithMaxMemory(0, redis.AllKeysLFU, redis.DefaultMaxMemorySamples))
+
+	number := func(n int) redis.Value { return redis.Value{Type: redis.Number, Number: n} }
+	payload := string(redis.EncodeDump("restored"))
+
+	steps := []struct {
+		args []string
+		want redis.Value
+	}{
+		{args: []string{"SET", "key", "value"}, want: redis.Value{Type: redis.SimpleString, SimpleString: "OK"}},
+		{args: []string{"OBJECT", "FREQ", "key"}, want: number(5)},
+		{args: []string{"OBJECT", "FREQ", "missing"}, want: redis.Value{Type: redis.NullBulk}},
+		{args: []string{"OBJECT", "IDLETIME", "key"}, want: redis.Value{Type: redis.Error, Error: "ERR An LFU maxmemory policy is selected, idle time not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust."}},
+		{args: []string{"RESTORE", "restored", "0", payload, "FREQ", "42"}, want: redis.Value{Type: redis.SimpleString, SimpleString: "OK"}},
+		{args: []string{"OBJECT", "FREQ", "restored"}, want: number(42)},
+	}
+
+	for _, step := range steps {
+		got, err := client.Handle(session, redis.NewCommandFromArgs(step.args...))
+		assert.NoError(t, err)
+		assert.Equal(t, step.want, got, step.args)
+	}
+}
+
+func TestClientStats(t *testing.T) {
+	client, session, now := newTestClient()
+
+	for _, args := range [][]string{
+		{"SET", "key", "value"},
+		{"SET", "volatile", "value", "EX", "10"},
+		{"GET", "key"},
+		{"GET", "missing"},
+		{"EXISTS", "key", "missing"},
+		{"TTL", "volatile"},
+		{"DEL", "missing"},
+	} {
+		_, err := client.Handle(session, redis.NewCommandFromArgs(args...))
+		assert.NoError(t, err)
+	}
+
+	*now = now.Add(11 * time.Second)
+	_, err := client.Handle(session, redis.NewCommandFromArgs("GET", "volatile"))
+	assert.NoError(t, err)
+
+	assert.Equal(t, redis.ClientStats{ExpiredKeys: 1, KeyspaceHits: 3, KeyspaceMisses: 3}, client.Stats())
+
+	client.ResetStats()
+	assert.Equal(t, redis.ClientStats{}, client.Stats())
+}
+
+func TestClientIDGen(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want redis.Value
+	}{
+		{
+			name: "count within the limit",
+			args: []string{"IDGEN.NEXT", "orders", "COUNT", "2"},
+			want: redis.Value{Type: redis.Array},
+		},
+		{
+			name: "count above the limit",
+			args: []string{"IDGEN.NEXT", "orders", "COUNT", "2000000000"},
+			want: redis.Value{Type: redis.Error, Error: "ERR COUNT must be between 1 and 10000"},
+		},
+		{
+			name: "advance from a client",
+			args: []string{"IDGEN.ADVANCE", "orders", "next", "1"},
+			want: redis.Value{Type: redis.Error, Error: "ERR IDGEN.ADVANCE is only accepted from the master or the AOF"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := redis.NewClient([]redis.Store{redis.NewInMemoryStore()})
+
+			got, err := client.Handle(redis.NewSession(), redis.NewCommandFromArgs(tt.args...))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want.Type, got.Type)
+			assert.Equal(t, tt.want.Error, got.Error)
+		})
+	}
+}
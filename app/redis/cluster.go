@@ -0,0 +1,639 @@
+package redis
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ClusterSlots is the number of hash slots the keyspace is sharded in.
+	ClusterSlots = 16384
+	// ClusterBusPortOffset is added to the client port for the cluster bus port, unless it is configured.
+	ClusterBusPortOffset = 10000
+
+	// clusterNodeTimeout is how long a node may not answer before it is flagged as possibly failing.
+	clusterNodeTimeout = 15 * time.Second
+)
+
+// ClusterConfig configures cluster mode, where the keyspace is sharded by hash
+// slot across nodes that talk to each other over the cluster bus.
+type ClusterConfig struct {
+	Enabled bool
+	// Port is the cluster bus port, 0 for the client port plus ClusterBusPortOffset.
+	Port int
+	// RequireFullCoverage stops serving keys while some slots are not assigned.
+	RequireFullCoverage bool
+}
+
+func DefaultClusterConfig() ClusterConfig {
+	return ClusterConfig{
+		Enabled:             false,
+		RequireFullCoverage: true,
+	}
+}
+
+func WithCluster(config ClusterConfig) func(*Server) {
+	return func(s *Server) {
+		s.cluster.ClusterConfig = config
+	}
+}
+
+// KeySlot returns the hash slot of the key. When the key contains a non-empty
+// {hashtag}, only the hashtag is hashed, so that related keys share a slot.
+func KeySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+
+	return int(crc16(key) % ClusterSlots)
+}
+
+// crc16 is the CRC16-CCITT (XMODEM) checksum Redis Cluster hashes keys with.
+func crc16(data string) uint16 {
+	var crc uint16
+	for i := 0; i < len(data); i++ {
+		crc ^= uint16(data[i]) << 8
+		for range 8 {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+
+	return crc
+}
+
+type clusterState struct {
+	ClusterConfig
+
+	mu           sync.Mutex
+	myself       *clusterNode
+	nodes        map[string]*clusterNode
+	currentEpoch int64
+	// slots holds the owner of each slot, nil while it is not assigned.
+	slots [ClusterSlots]*clusterNode
+	// migrating and importing hold the slots being moved out to, or in from, another node.
+	migrating map[int]*clusterNode
+	importing map[int]*clusterNode
+}
+
+type clusterNode struct {
+	id      string
+	host    string
+	port    int
+	busPort int
+
+	configEpoch int64
+	// handshake is set on nodes met by address, until they answer with their ID.
+	handshake bool
+	createdAt time.Time
+	// pingSent is when the ping waiting for an answer was sent, zero if none is.
+	pingSent     time.Time
+	pongReceived time.Time
+	connected    bool
+}
+
+func (n *clusterNode) address() string {
+	return net.JoinHostPort(n.host, strconv.Itoa(n.port))
+}
+
+func (n *clusterNode) busAddress() string {
+	return net.JoinHostPort(n.host, strconv.Itoa(n.busPort))
+}
+
+// failing reports whether the node did not answer a ping for too long.
+func (n *clusterNode) failing() bool {
+	return !n.pingSent.IsZero() && time.Since(n.pingSent) > clusterNodeTimeout
+}
+
+// init creates this node, listening for clients on port.
+func (c *clusterState) init(port int) {
+	busPort := c.Port
+	if busPort == 0 {
+		busPort = port + ClusterBusPortOffset
+	}
+
+	c.myself = &clusterNode{id: randomHex(40), port: port, busPort: busPort, createdAt: time.Now(), connected: true}
+	c.nodes = map[string]*clusterNode{c.myself.id: c.myself}
+	c.migrating = map[int]*clusterNode{}
+	c.importing = map[int]*clusterNode{}
+}
+
+// covered reports whether every slot is assigned. It must be called with mu held.
+func (c *clusterState) covered() bool {
+	for _, owner := range c.slots {
+		if owner == nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// slotRanges returns the slots owned by the node as [start, end] ranges. It must be called with mu held.
+func (c *clusterState) slotRanges(node *clusterNode) [][2]int {
+	ranges := [][2]int{}
+	for slot := 0; slot < ClusterSlots; slot++ {
+		if c.slots[slot] != node {
+			continue
+		}
+
+		if len(ranges) > 0 && ranges[len(ranges)-1][1] == slot-1 {
+			ranges[len(ranges)-1][1] = slot
+		} else {
+			ranges = append(ranges, [2]int{slot, slot})
+		}
+	}
+
+	return ranges
+}
+
+// sortedNodes returns the known nodes ordered by ID. It must be called with mu held.
+func (c *clusterState) sortedNodes() []*clusterNode {
+	nodes := make([]*clusterNode, 0, len(c.nodes))
+	for _, node := range c.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].id < nodes[j].id })
+
+	return nodes
+}
+
+// routeCluster checks that the keys of the command are served by this node.
+// It returns the error to reply with instead of executing the command, if any.
+func (s *Server) routeCluster(session *Session, cmd Command) (Value, bool) {
+	asking := session.asking
+	session.asking = false
+
+	switch cmd.Type {
+	case Select:
+		if len(cmd.Args) == 1 && cmd.Args[0] != "0" {
+			return errorValue("ERR SELECT is not allowed in cluster mode"), true
+		}
+	case Move, SwapDB:
+		return errorValue("ERR %s is not allowed in cluster mode", strings.ToUpper(string(cmd.Type))), true
+	}
+
+	keys := cmd.Keys()
+	if len(keys) == 0 {
+		return Value{}, false
+	}
+
+	slot := KeySlot(keys[0])
+	for _, key := range keys[1:] {
+		if KeySlot(key) != slot {
+			return errorValue("CROSSSLOT Keys in request don't hash to the same slot"), true
+		}
+	}
+
+	c := &s.cluster
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.RequireFullCoverage && !c.covered() {
+		return errorValue("CLUSTERDOWN The cluster is down"), true
+	}
+
+	owner := c.slots[slot]
+	if owner == nil {
+		return errorValue("CLUSTERDOWN Hash slot not served"), true
+	}
+
+	if owner == c.myself {
+		target, migrating := c.migrating[slot]
+		if !migrating {
+			return Value{}, false
+		}
+
+		// Keys still here are served here, the others may already have been moved.
+		store := s.client.db(0)
+		missing := 0
+		for _, key := range keys {
+			if _, found := store.GetEntry(key); !found {
+				missing++
+			}
+		}
+		switch {
+		case missing == 0:
+			return Value{}, false
+		case missing == len(keys):
+			return errorValue("ASK %d %s", slot, target.address()), true
+		default:
+			return errorValue("TRYAGAIN Multiple keys request during rehashing of slot"), true
+		}
+	}
+
+	if _, importing := c.importing[slot]; importing && asking {
+		return Value{}, false
+	}
+
+	return errorValue("MOVED %d %s", slot, owner.address()), true
+}
+
+// clusterCommand implements the CLUSTER subcommands.
+func (s *Server) clusterCommand(cmd Command) Value {
+	if !s.cluster.Enabled {
+		return errorValue("ERR This instance has cluster support disabled")
+	}
+	if len(cmd.Args) == 0 {
+		return wrongArgumentsError(cmd)
+	}
+
+	c := &s.cluster
+	subcommand := strings.ToLower(cmd.Args[0])
+	args := cmd.Args[1:]
+
+	switch subcommand {
+	case "myid":
+		return Value{Type: Bulk, Bulk: c.myself.id}
+
+	case "info":
+		return Value{Type: Bulk, Bulk: s.clusterInfo()}
+
+	case "nodes":
+		return Value{Type: Bulk, Bulk: s.clusterNodes()}
+
+	case "slots":
+		return s.clusterSlots()
+
+	case "shards":
+		return s.clusterShards()
+
+	case "keyslot":
+		if len(args) != 1 {
+			return errorValue("ERR wrong number of arguments for 'cluster|keyslot' command")
+		}
+		return Value{Type: Number, Number: KeySlot(args[0])}
+
+	case "countkeysinslot":
+		if len(args) != 1 {
+			return errorValue("ERR wrong number of arguments for 'cluster|countkeysinslot' command")
+		}
+		slot, err := strconv.Atoi(args[0])
+		if err != nil || slot < 0 || slot >= ClusterSlots {
+			return errorValue("ERR Invalid slot")
+		}
+		return Value{Type: Number, Number: len(s.keysInSlot(slot, -1))}
+
+	case "getkeysinslot":
+		if len(args) != 2 {
+			return errorValue("ERR wrong number of arguments for 'cluster|getkeysinslot' command")
+		}
+		slot, slotErr := strconv.Atoi(args[0])
+		count, countErr := strconv.Atoi(args[1])
+		if slotErr != nil || countErr != nil || slot < 0 || slot >= ClusterSlots || count < 0 {
+			return errorValue("ERR Invalid slot or number of keys")
+		}
+		return bulkFields(s.keysInSlot(slot, count)...)
+
+	case "addslots":
+		if len(args) == 0 {
+			return errorValue("ERR wrong number of arguments for 'cluster|addslots' command")
+		}
+		return s.addSlots(args)
+
+	case "setslot":
+		return s.setSlot(args)
+
+	case "meet":
+		if len(args) != 2 && len(args) != 3 {
+			return errorValue("ERR wrong number of arguments for 'cluster|meet' command")
+		}
+		port, err := strconv.Atoi(args[1])
+		busPort := port + ClusterBusPortOffset
+		if len(args) == 3 && err == nil {
+			busPort, err = strconv.Atoi(args[2])
+		}
+		if err != nil || net.ParseIP(args[0]) == nil || port <= 0 || port > 65535 || busPort <= 0 || busPort > 65535 {
+			return errorValue("ERR Invalid node address specified: %s:%s", args[0], args[1])
+		}
+
+		c.meet(args[0], port, busPort)
+		return Value{Type: SimpleString, SimpleString: "OK"}
+	}
+
+	return errorValue("ERR unknown subcommand '%s'. Try CLUSTER HELP.", cmd.Args[0])
+}
+
+// keysInSlot returns up to count keys of the slot, or all of them if count is negative.
+func (s *Server) keysInSlot(slot int, count int) []string {
+	keys := []string{}
+	for key := range s.client.db(0).Entries() {
+		if KeySlot(key) == slot {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	if count >= 0 && len(keys) > count {
+		keys = keys[:count]
+	}
+
+	return keys
+}
+
+func (s *Server) addSlots(args []string) Value {
+	c := &s.cluster
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	slots := make([]int, 0, len(args))
+	seen := map[int]bool{}
+	for _, arg := range args {
+		slot, err := strconv.Atoi(arg)
+		if err != nil || slot < 0 || slot >= ClusterSlots {
+			return errorValue("ERR Invalid or out of range slot")
+		}
+		if seen[slot] {
+			return errorValue("ERR Slot %d specified multiple times", slot)
+		}
+		if c.slots[slot] != nil {
+			return errorValue("ERR Slot %d is already busy", slot)
+		}
+
+		seen[slot] = true
+		slots = append(slots, slot)
+	}
+
+	for _, slot := range slots {
+		c.slots[slot] = c.myself
+		delete(c.importing, slot)
+	}
+
+	return Value{Type: SimpleString, SimpleString: "OK"}
+}
+
+// setSlot implements CLUSTER SETSLOT <slot> MIGRATING|IMPORTING|NODE <node-id> and CLUSTER SETSLOT <slot> STABLE,
+// the steps of moving a slot between nodes.
+func (s *Server) setSlot(args []string) Value {
+	if len(args) < 2 {
+		return errorValue("ERR wrong number of arguments for 'cluster|setslot' command")
+	}
+
+	slot, err := strconv.Atoi(args[0])
+	if err != nil || slot < 0 || slot >= ClusterSlots {
+		return errorValue("ERR Invalid or out of range slot")
+	}
+
+	c := &s.cluster
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	action := strings.ToLower(args[1])
+	if action == "stable" {
+		if len(args) != 2 {
+			return errorValue("ERR syntax error")
+		}
+		delete(c.migrating, slot)
+		delete(c.importing, slot)
+		return Value{Type: SimpleString, SimpleString: "OK"}
+	}
+
+	if len(args) != 3 {
+		return errorValue("ERR syntax error")
+	}
+	node, found := c.nodes[args[2]]
+	if !found || node.handshake {
+		return errorValue("ERR I don't know about node %s", args[2])
+	}
+
+	switch action {
+	case "migrating":
+		if c.slots[slot] != c.myself {
+			return errorValue("ERR I'm not the owner of hash slot %d", slot)
+		}
+		if node == c.myself {
+			return errorValue("ERR I can't migrate a slot to myself")
+		}
+		c.migrating[slot] = node
+
+	case "importing":
+		if c.slots[slot] == c.myself {
+			return errorValue("ERR I'm already the owner of hash slot %d", slot)
+		}
+		if node == c.myself {
+			return errorValue("ERR I can't import a slot from myself")
+		}
+		c.importing[slot] = node
+
+	case "node":
+		if c.slots[slot] == c.myself && node != c.myself && len(s.keysInSlot(slot, 1)) > 0 {
+			return errorValue("ERR Can't assign hashslot %d to a different node while I still hold keys for this hash slot.", slot)
+		}
+
+		delete(c.migrating, slot)
+		if _, importing := c.importing[slot]; importing && node == c.myself {
+			// The import is complete: a new epoch makes this ownership win over the former owner's.
+			delete(c.importing, slot)
+			c.bumpEpoch()
+		}
+		c.slots[slot] = node
+
+	default:
+		return errorValue("ERR Invalid CLUSTER SETSLOT action or number of arguments. Try CLUSTER HELP")
+	}
+
+	return Value{Type: SimpleString, SimpleString: "OK"}
+}
+
+// bumpEpoch gives this node a config epoch greater than any other. It must be called with mu held.
+func (c *clusterState) bumpEpoch() {
+	c.currentEpoch++
+	c.myself.configEpoch = c.currentEpoch
+}
+
+func (s *Server) clusterInfo() string {
+	c := &s.cluster
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	assigned := 0
+	sizes := map[*clusterNode]bool{}
+	for _, owner := range c.slots {
+		if owner != nil {
+			assigned++
+			sizes[owner] = true
+		}
+	}
+
+	state := "ok"
+	if c.RequireFullCoverage && assigned != ClusterSlots {
+		state = "fail"
+	}
+
+	lines := []string{
+		"cluster_state:" + state,
+		fmt.Sprintf("cluster_slots_assigned:%d", assigned),
+		fmt.Sprintf("cluster_slots_ok:%d", assigned),
+		"cluster_slots_pfail:0",
+		"cluster_slots_fail:0",
+		fmt.Sprintf("cluster_known_nodes:%d", len(c.nodes)),
+		fmt.Sprintf("cluster_size:%d", len(sizes)),
+		fmt.Sprintf("cluster_current_epoch:%d", c.currentEpoch),
+		fmt.Sprintf("cluster_my_epoch:%d", c.myself.configEpoch),
+	}
+
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
+
+// clusterNodes formats the nodes the way CLUSTER NODES and nodes.conf do, one per line.
+func (s *Server) clusterNodes() string {
+	c := &s.cluster
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var builder strings.Builder
+	for _, node := range c.sortedNodes() {
+		flags := "master"
+		switch {
+		case node == c.myself:
+			flags = "myself,master"
+		case node.handshake:
+			flags = "handshake"
+		case node.failing():
+			flags = "master,fail?"
+		}
+
+		linkState := "disconnected"
+		if node == c.myself || node.connected {
+			linkState = "connected"
+		}
+
+		fields := []string{
+			node.id,
+			fmt.Sprintf("%s@%d", node.address(), node.busPort),
+			flags,
+			"-",
+			strconv.FormatInt(unixMilli(node.pingSent), 10),
+			strconv.FormatInt(unixMilli(node.pongReceived), 10),
+			strconv.FormatInt(node.configEpoch, 10),
+			linkState,
+		}
+		for _, r := range c.slotRanges(node) {
+			if r[0] == r[1] {
+				fields = append(fields, strconv.Itoa(r[0]))
+			} else {
+				fields = append(fields, fmt.Sprintf("%d-%d", r[0], r[1]))
+			}
+		}
+		if node == c.myself {
+			for _, slot := range sortedSlots(c.migrating) {
+				fields = append(fields, fmt.Sprintf("[%d->-%s]", slot, c.migrating[slot].id))
+			}
+			for _, slot := range sortedSlots(c.importing) {
+				fields = append(fields, fmt.Sprintf("[%d-<-%s]", slot, c.importing[slot].id))
+			}
+		}
+
+		builder.WriteString(strings.Join(fields, " "))
+		builder.WriteString("\n")
+	}
+
+	return builder.String()
+}
+
+func (s *Server) clusterSlots() Value {
+	c := &s.cluster
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	type slotRange struct {
+		start, end int
+		node       *clusterNode
+	}
+	ranges := []slotRange{}
+	for _, node := range c.nodes {
+		for _, r := range c.slotRanges(node) {
+			ranges = append(ranges, slotRange{start: r[0], end: r[1], node: node})
+		}
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].start < ranges[j].start })
+
+	values := make([]Value, 0, len(ranges))
+	for _, r := range ranges {
+		values = append(values, Value{Type: Array, Array: []Value{
+			{Type: Number, Number: r.start},
+			{Type: Number, Number: r.end},
+			{Type: Array, Array: []Value{
+				{Type: Bulk, Bulk: r.node.host},
+				{Type: Number, Number: r.node.port},
+				{Type: Bulk, Bulk: r.node.id},
+			}},
+		}})
+	}
+
+	return Value{Type: Array, Array: values}
+}
+
+func (s *Server) clusterShards() Value {
+	_, offset := s.replicationInfo()
+
+	c := &s.cluster
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	values := []Value{}
+	for _, node := range c.sortedNodes() {
+		if node.handshake {
+			continue
+		}
+
+		slots := []Value{}
+		for _, r := range c.slotRanges(node) {
+			slots = append(slots, Value{Type: Number, Number: r[0]}, Value{Type: Number, Number: r[1]})
+		}
+
+		nodeOffset := 0
+		health := "online"
+		if node == c.myself {
+			nodeOffset = offset
+		} else if !node.connected {
+			health = "failed"
+		}
+
+		values = append(values, Value{Type: Array, Array: []Value{
+			{Type: Bulk, Bulk: "slots"},
+			{Type: Array, Array: slots},
+			{Type: Bulk, Bulk: "nodes"},
+			{Type: Array, Array: []Value{{Type: Array, Array: []Value{
+				{Type: Bulk, Bulk: "id"}, {Type: Bulk, Bulk: node.id},
+				{Type: Bulk, Bulk: "port"}, {Type: Number, Number: node.port},
+				{Type: Bulk, Bulk: "ip"}, {Type: Bulk, Bulk: node.host},
+				{Type: Bulk, Bulk: "endpoint"}, {Type: Bulk, Bulk: node.host},
+				{Type: Bulk, Bulk: "role"}, {Type: Bulk, Bulk: "master"},
+				{Type: Bulk, Bulk: "replication-offset"}, {Type: Number, Number: nodeOffset},
+				{Type: Bulk, Bulk: "health"}, {Type: Bulk, Bulk: health},
+			}}}},
+		}})
+	}
+
+	return Value{Type: Array, Array: values}
+}
+
+func sortedSlots(slots map[int]*clusterNode) []int {
+	sorted := make([]int, 0, len(slots))
+	for slot := range slots {
+		sorted = append(sorted, slot)
+	}
+	sort.Ints(sorted)
+
+	return sorted
+}
+
+// unixMilli returns the time in Unix milliseconds, 0 for the zero time.
+func unixMilli(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return t.UnixMilli()
+}
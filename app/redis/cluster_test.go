@@ -0,0 +1,33 @@
+package redis_test
+
+import (
+	"testing"
+
+	"github.com/codecrafters-io/redis-starter-go/app/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeySlot(t *testing.T) {
+	tests := []struct {
+		key  string
+		want int
+	}{
+		{key: "", want: 0},
+		{key: "123456789", want: 0x31c3 % redis.ClusterSlots},
+		{key: "foo", want: 12182},
+		{key: "bar", want: 5061},
+		{key: "somekey", want: 11058},
+		{key: "{foo}.bar", want: 12182},
+		{key: "baz{foo}", want: 12182},
+		{key: "{foo}{bar}", want: 12182},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			assert.Equal(t, tt.want, redis.KeySlot(tt.key))
+		})
+	}
+
+	assert.Equal(t, redis.KeySlot("{user1000}.following"), redis.KeySlot("{user1000}.followers"))
+	assert.NotEqual(t, redis.KeySlot("{}foo"), redis.KeySlot("{}bar"), "an empty hashtag hashes the whole key")
+}
@@ -0,0 +1,344 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	clusterPingPeriod   = time.Second
+	clusterQueryTimeout = time.Second
+)
+
+// Nodes gossip over the cluster bus with RESP arrays of the form
+//
+//	<type> <id> <host> <port> <bus port> <config epoch> <current epoch> <slots> [<node>...]
+//
+// where type is meet, ping or pong, slots are comma separated ranges ("-" for
+// none) and every node is another node known to the sender, as <id>,<host>,<port>,<bus port>.
+// Every meet or ping is answered with a pong.
+const (
+	clusterMeet = "meet"
+	clusterPing = "ping"
+	clusterPong = "pong"
+)
+
+type clusterMessage struct {
+	kind         string
+	sender       clusterNode
+	currentEpoch int64
+	slots        []int
+	gossip       []clusterNode
+}
+
+// message returns the message of the given type describing this node. It must be called with mu held.
+func (c *clusterState) message(kind string) []string {
+	slots := []string{}
+	for _, r := range c.slotRanges(c.myself) {
+		slots = append(slots, fmt.Sprintf("%d-%d", r[0], r[1]))
+	}
+	if len(slots) == 0 {
+		slots = append(slots, "-")
+	}
+
+	args := []string{
+		kind,
+		c.myself.id,
+		c.myself.host,
+		strconv.Itoa(c.myself.port),
+		strconv.Itoa(c.myself.busPort),
+		strconv.FormatInt(c.myself.configEpoch, 10),
+		strconv.FormatInt(c.currentEpoch, 10),
+		strings.Join(slots, ","),
+	}
+	for _, node := range c.nodes {
+		if node == c.myself || node.handshake || node.host == "" {
+			continue
+		}
+		args = append(args, fmt.Sprintf("%s,%s,%d,%d", node.id, node.host, node.port, node.busPort))
+	}
+
+	return args
+}
+
+func parseClusterMessage(value Value) (clusterMessage, error) {
+	if value.Type != Array || len(value.Array) < 8 {
+		return clusterMessage{}, fmt.Errorf("%w: malformed cluster bus message", ErrInvalidResp)
+	}
+	args := make([]string, len(value.Array))
+	for i, element := range value.Array {
+		args[i] = element.Bulk
+	}
+
+	msg := clusterMessage{kind: args[0]}
+	if msg.kind != clusterMeet && msg.kind != clusterPing && msg.kind != clusterPong {
+		return clusterMessage{}, fmt.Errorf("unknown cluster bus message %q", msg.kind)
+	}
+
+	var err error
+	msg.sender, err = parseClusterNode(args[1:5])
+	if err != nil {
+		return clusterMessage{}, err
+	}
+	msg.sender.configEpoch, err = strconv.ParseInt(args[5], 10, 64)
+	if err != nil {
+		return clusterMessage{}, fmt.Errorf("invalid config epoch %q", args[5])
+	}
+	msg.currentEpoch, err = strconv.ParseInt(args[6], 10, 64)
+	if err != nil {
+		return clusterMessage{}, fmt.Errorf("invalid current epoch %q", args[6])
+	}
+
+	if args[7] != "-" {
+		for _, r := range strings.Split(args[7], ",") {
+			first, last, _ := strings.Cut(r, "-")
+			start, startErr := strconv.Atoi(first)
+			end, endErr := strconv.Atoi(last)
+			if startErr != nil || endErr != nil || start < 0 || end >= ClusterSlots || start > end {
+				return clusterMessage{}, fmt.Errorf("invalid slot range %q", r)
+			}
+			for slot := start; slot <= end; slot++ {
+				msg.slots = append(msg.slots, slot)
+			}
+		}
+	}
+
+	for _, entry := range args[8:] {
+		node, err := parseClusterNode(strings.Split(entry, ","))
+		if err != nil {
+			return clusterMessage{}, err
+		}
+		msg.gossip = append(msg.gossip, node)
+	}
+
+	return msg, nil
+}
+
+// parseClusterNode parses the id, host, port and bus port of a node.
+func parseClusterNode(fields []string) (clusterNode, error) {
+	if len(fields) != 4 {
+		return clusterNode{}, fmt.Errorf("invalid node %q", strings.Join(fields, ","))
+	}
+
+	port, portErr := strconv.Atoi(fields[2])
+	busPort, busPortErr := strconv.Atoi(fields[3])
+	if fields[0] == "" || portErr != nil || busPortErr != nil {
+		return clusterNode{}, fmt.Errorf("invalid node %q", strings.Join(fields, ","))
+	}
+
+	return clusterNode{id: fields[0], host: fields[1], port: port, busPort: busPort}, nil
+}
+
+// meet starts a handshake with the node at the address. It keeps a random ID until the node answers with its own.
+func (c *clusterState) meet(host string, port int, busPort int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, node := range c.nodes {
+		if node.host == host && node.port == port {
+			return
+		}
+	}
+
+	id := randomHex(40)
+	c.nodes[id] = &clusterNode{id: id, host: host, port: port, busPort: busPort, handshake: true, createdAt: time.Now()}
+}
+
+// process applies what a message tells about its sender and the nodes the sender knows.
+// from is the node the message is the answer of, nil for messages received on the bus.
+// remoteHost is the address the message came from, localHost the one it was received on.
+func (c *clusterState) process(msg clusterMessage, from *clusterNode, remoteHost string, localHost string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if c.myself.host == "" {
+		// This node only learns its own address from the connections of the other nodes.
+		c.myself.host = localHost
+	}
+
+	sender := c.nodes[msg.sender.id]
+	if from != nil && from.handshake {
+		delete(c.nodes, from.id)
+		if sender == nil && msg.sender.id != c.myself.id {
+			from.id = msg.sender.id
+			from.handshake = false
+			c.nodes[from.id] = from
+			sender = from
+		}
+	}
+	if msg.sender.id == c.myself.id {
+		return
+	}
+	if sender == nil {
+		if msg.kind != clusterMeet {
+			return
+		}
+		sender = &clusterNode{id: msg.sender.id, createdAt: now}
+		c.nodes[sender.id] = sender
+	}
+
+	sender.host = msg.sender.host
+	if sender.host == "" {
+		sender.host = remoteHost
+	}
+	sender.port = msg.sender.port
+	sender.busPort = msg.sender.busPort
+	sender.configEpoch = msg.sender.configEpoch
+	if from != nil {
+		sender.pingSent = time.Time{}
+		sender.pongReceived = now
+		sender.connected = true
+	}
+	c.currentEpoch = max(c.currentEpoch, msg.currentEpoch, sender.configEpoch)
+
+	// A slot claimed by the sender is reassigned to it when its owner's configuration is older.
+	for _, slot := range msg.slots {
+		owner := c.slots[slot]
+		if owner == sender || (owner != nil && owner.configEpoch >= sender.configEpoch) {
+			continue
+		}
+
+		c.slots[slot] = sender
+		if owner == c.myself {
+			delete(c.migrating, slot)
+		}
+	}
+
+	for _, node := range msg.gossip {
+		if _, known := c.nodes[node.id]; known || node.id == c.myself.id || node.host == "" {
+			continue
+		}
+
+		discovered := node
+		discovered.createdAt = now
+		c.nodes[node.id] = &discovered
+	}
+}
+
+// serveClusterBus accepts the connections of the other nodes.
+func (s *Server) serveClusterBus(ctx context.Context, listener net.Listener) {
+	s.logger.Printf("Accepting cluster bus connections on: %s\n", listener.Addr().String())
+
+	for {
+		connection, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			s.logger.Println("error accepting the cluster bus connection:", err)
+			continue
+		}
+
+		s.goTracked(func() {
+			defer closeOnDone(ctx, connection)()
+			s.handleClusterBus(connection)
+		})
+	}
+}
+
+func (s *Server) handleClusterBus(connection net.Conn) {
+	defer connection.Close()
+
+	remoteHost, _, _ := net.SplitHostPort(connection.RemoteAddr().String())
+	localHost, _, _ := net.SplitHostPort(connection.LocalAddr().String())
+	resp := NewResp(connection)
+	for {
+		value, err := resp.Read()
+		if err != nil {
+			return
+		}
+
+		msg, err := parseClusterMessage(value)
+		if err != nil {
+			s.logger.Printf("Closing cluster bus connection %s: %v\n", connection.RemoteAddr(), err)
+			return
+		}
+		s.cluster.process(msg, nil, remoteHost, localHost)
+
+		s.cluster.mu.Lock()
+		pong := s.cluster.message(clusterPong)
+		s.cluster.mu.Unlock()
+
+		if err := NewCommandFromArgs(pong...).Write(connection); err != nil {
+			return
+		}
+	}
+}
+
+// clusterCron pings every known node once per period.
+func (s *Server) clusterCron(ctx context.Context) {
+	links := newLinks(clusterQueryTimeout)
+	ticker := time.NewTicker(clusterPingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.pingNodes(links)
+		}
+	}
+}
+
+func (s *Server) pingNodes(links *links) {
+	c := &s.cluster
+
+	type target struct {
+		node    *clusterNode
+		address string
+		message []string
+	}
+
+	c.mu.Lock()
+	now := time.Now()
+	targets := []target{}
+	for _, node := range c.nodes {
+		if node == c.myself {
+			continue
+		}
+
+		kind := clusterPing
+		if node.handshake {
+			kind = clusterMeet
+		}
+		if node.pingSent.IsZero() {
+			node.pingSent = now
+		}
+		targets = append(targets, target{node: node, address: node.busAddress(), message: c.message(kind)})
+	}
+	c.mu.Unlock()
+
+	for _, t := range targets {
+		reply, err := links.query(t.address, t.message...)
+		var msg clusterMessage
+		if err == nil {
+			msg, err = parseClusterMessage(reply)
+		}
+		if err == nil && msg.kind != clusterPong {
+			err = fmt.Errorf("unexpected %s reply", msg.kind)
+		}
+		if err != nil {
+			c.mu.Lock()
+			if t.node.connected {
+				s.logger.Printf("Lost the cluster bus link to %s: %v\n", t.address, err)
+			}
+			t.node.connected = false
+			if t.node.handshake && time.Since(t.node.createdAt) > clusterNodeTimeout {
+				s.logger.Printf("Handshake with %s timed out\n", t.address)
+				delete(c.nodes, t.node.id)
+			}
+			c.mu.Unlock()
+			continue
+		}
+
+		remoteHost, _, _ := net.SplitHostPort(t.address)
+		c.process(msg, t.node, remoteHost, links.localHost(t.address))
+	}
+}
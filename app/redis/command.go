@@ -8,20 +8,107 @@ import (
 type CommandType string
 
 const (
-	Echo       CommandType = "echo"
-	Ping       CommandType = "ping"
-	Set        CommandType = "set"
-	Get        CommandType = "get"
-	Cfg        CommandType = "config"
-	Info       CommandType = "info"
-	Pong       CommandType = "pong"
-	ReplConf   CommandType = "replconf"
-	PSync      CommandType = "psync"
-	Fullresync CommandType = "fullresync"
-	Ok         CommandType = "ok"
-	Wait       CommandType = "wait"
+	Echo      CommandType = "echo"
+	Ping      CommandType = "ping"
+	Set       CommandType = "set"
+	Get       CommandType = "get"
+	Cfg       CommandType = "config"
+	Info      CommandType = "info"
+	Pong      CommandType = "pong"
+	ReplConf  CommandType = "replconf"
+	PSync     CommandType = "psync"
+	ReplicaOf CommandType = "replicaof"
+	SlaveOf   CommandType = "slaveof"
+	Failover  CommandType = "failover"
+	Cluster   CommandType = "cluster"
+	Asking    CommandType = "asking"
+	Migrate   CommandType = "migrate"
+	// SentinelCommand is only served in sentinel mode.
+	SentinelCommand CommandType = "sentinel"
+	Fullresync      CommandType = "fullresync"
+	Ok              CommandType = "ok"
+	Wait            CommandType = "wait"
+	Select          CommandType = "select"
+	SwapDB          CommandType = "swapdb"
+	Move            CommandType = "move"
+	FlushDB         CommandType = "flushdb"
+	FlushAll        CommandType = "flushall"
+	DBSize          CommandType = "dbsize"
+	RandomKey       CommandType = "randomkey"
+	Save            CommandType = "save"
+	BGSave          CommandType = "bgsave"
+	LastSave        CommandType = "lastsave"
+	BGRewriteAOF    CommandType = "bgrewriteaof"
+	Del             CommandType = "del"
+	Exists          CommandType = "exists"
+	Expire          CommandType = "expire"
+	PExpire         CommandType = "pexpire"
+	ExpireAt        CommandType = "expireat"
+	PExpireAt       CommandType = "pexpireat"
+	Persist         CommandType = "persist"
+	TTL             CommandType = "ttl"
+	PTTL            CommandType = "pttl"
+	Dump            CommandType = "dump"
+	Restore         CommandType = "restore"
+	Object          CommandType = "object"
+	Memory          CommandType = "memory"
+	ClientCommand   CommandType = "client"
+	Shutdown        CommandType = "shutdown"
+
+	IDGenNext    CommandType = "idgen.next"
+	IDGenSeq     CommandType = "idgen.seq"
+	IDGenAdvance CommandType = "idgen.advance"
 )
 
+type commandFlags uint
+
+const (
+	// cmdWrite marks commands that may modify the dataset. Their effects are propagated.
+	cmdWrite commandFlags = 1 << iota
+	// cmdNoDB marks commands that do not depend on the selected database.
+	cmdNoDB
+	// cmdDenyOOM marks commands that may grow the dataset, rejected while it does not fit in maxmemory.
+	cmdDenyOOM
+)
+
+// commandSpec describes a command. Keys are located by position among the
+// arguments following the command name, counted from 1; a lastKey of -1
+// stands for the last argument.
+type commandSpec struct {
+	flags    commandFlags
+	firstKey int
+	lastKey  int
+	keyStep  int
+	// getKeys locates the keys of commands that do not have them at fixed positions.
+	getKeys func(args []string) []string
+}
+
+var commandTable = map[CommandType]commandSpec{
+	Get:          {firstKey: 1, lastKey: 1, keyStep: 1},
+	Set:          {flags: cmdWrite | cmdDenyOOM, firstKey: 1, lastKey: 1, keyStep: 1},
+	Del:          {flags: cmdWrite, firstKey: 1, lastKey: -1, keyStep: 1},
+	Exists:       {firstKey: 1, lastKey: -1, keyStep: 1},
+	Expire:       {flags: cmdWrite, firstKey: 1, lastKey: 1, keyStep: 1},
+	PExpire:      {flags: cmdWrite, firstKey: 1, lastKey: 1, keyStep: 1},
+	ExpireAt:     {flags: cmdWrite, firstKey: 1, lastKey: 1, keyStep: 1},
+	PExpireAt:    {flags: cmdWrite, firstKey: 1, lastKey: 1, keyStep: 1},
+	Persist:      {flags: cmdWrite, firstKey: 1, lastKey: 1, keyStep: 1},
+	TTL:          {firstKey: 1, lastKey: 1, keyStep: 1},
+	PTTL:         {firstKey: 1, lastKey: 1, keyStep: 1},
+	Dump:         {firstKey: 1, lastKey: 1, keyStep: 1},
+	Restore:      {flags: cmdWrite | cmdDenyOOM, firstKey: 1, lastKey: 1, keyStep: 1},
+	Object:       {firstKey: 2, lastKey: 2, keyStep: 1},
+	Memory:       {firstKey: 2, lastKey: 2, keyStep: 1},
+	Move:         {flags: cmdWrite, firstKey: 1, lastKey: 1, keyStep: 1},
+	SwapDB:       {flags: cmdWrite | cmdNoDB},
+	FlushDB:      {flags: cmdWrite},
+	FlushAll:     {flags: cmdWrite | cmdNoDB},
+	IDGenNext:    {flags: cmdWrite | cmdNoDB},
+	IDGenSeq:     {flags: cmdWrite | cmdNoDB},
+	IDGenAdvance: {flags: cmdWrite | cmdNoDB},
+	Migrate:      {flags: cmdWrite, getKeys: migrateKeys},
+}
+
 type Command struct {
 	Type CommandType
 	Args []string
@@ -29,10 +116,50 @@ type Command struct {
 	value Value
 }
 
+func (c Command) spec() commandSpec {
+	return commandTable[c.Type]
+}
+
+func (c Command) IsWrite() bool {
+	return c.spec().flags&cmdWrite != 0
+}
+
+// Keys returns the keys the command operates on.
+func (c Command) Keys() []string {
+	spec := c.spec()
+	if spec.getKeys != nil {
+		return spec.getKeys(c.Args)
+	}
+	if spec.firstKey == 0 || len(c.Args) < spec.firstKey {
+		return nil
+	}
+
+	last := spec.lastKey
+	if last < 0 || last > len(c.Args) {
+		last = len(c.Args)
+	}
+
+	keys := []string{}
+	for i := spec.firstKey; i <= last; i += spec.keyStep {
+		keys = append(keys, c.Args[i-1])
+	}
+
+	return keys
+}
+
 func (c Command) Write(w io.Writer) error {
 	return c.value.Write(w)
 }
 
+func NewCommandFromArgs(args ...string) Command {
+	values := make([]Value, len(args))
+	for i, arg := range args {
+		values[i] = Value{Type: Bulk, Bulk: arg}
+	}
+
+	return NewCommand(Value{Type: Array, Array: values})
+}
+
 func NewCommand(value Value) Command {
 	switch value.Type {
 	case Array:
@@ -0,0 +1,887 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// configMaxIncludeDepth bounds the nesting of include directives, which would otherwise loop forever on a cycle.
+	configMaxIncludeDepth = 16
+	// configRewriteSignature precedes the parameters CONFIG REWRITE appends to the configuration file.
+	configRewriteSignature = "# Generated by CONFIG REWRITE"
+)
+
+var ErrUnknownConfig = errors.New("bad directive or wrong number of arguments")
+
+// configParam is a parameter of the configuration registry. Values are kept
+// as strings, in the canonical form returned by parse.
+type configParam struct {
+	name         string
+	defaultValue string
+	// parse validates a value and returns its canonical form.
+	parse func(value string) (string, error)
+	// appends joins the values of a parameter repeated in a configuration file,
+	// like the save policies written one per line.
+	appends bool
+	// merges completes a value with the current one, for parameters set in parts
+	// like client-output-buffer-limit, whose values only change the classes they name.
+	merges bool
+	// apply makes a value set by CONFIG SET take effect. Parameters without one are only read at startup.
+	apply func(s *Server, value string) error
+}
+
+var configParams = []configParam{
+	{name: "port", defaultValue: "6379", parse: intConfig(0, 65535)},
+	{name: "replicaof", defaultValue: "", parse: parseReplicaOfConfig},
+	{name: "databases", defaultValue: "16", parse: intConfig(1, 1<<20)},
+	{name: "node-id", defaultValue: "-1", parse: intConfig(-1, IDGenMaxNode)},
+
+	{name: "dir", defaultValue: ".", parse: stringConfig},
+	{name: "dbfilename", defaultValue: defaultDBFilename, parse: stringConfig},
+	{name: "save", defaultValue: "3600 1 300 100 60 10000", parse: parseSaveConfig, appends: true, apply: applySaveConfig},
+	{name: "appendonly", defaultValue: "no", parse: yesNoConfig, apply: applyAppendOnlyConfig},
+	{name: "appendfsync", defaultValue: string(AppendFsyncEverySec), parse: enumConfig(AppendFsyncAlways, AppendFsyncEverySec, AppendFsyncNo), apply: applyAppendFsyncConfig},
+	{name: "appenddirname", defaultValue: "appendonlydir", parse: stringConfig},
+	{name: "appendfilename", defaultValue: "appendonly.aof", parse: stringConfig},
+	{name: "aof-load-truncated", defaultValue: "yes", parse: yesNoConfig},
+
+	{name: "repl-diskless-sync", defaultValue: "yes", parse: yesNoConfig, apply: applyReplDisklessSyncConfig},
+	{name: "repl-backlog-size", defaultValue: "1048576", parse: memoryConfig(16 << 10)},
+	{name: "repl-timeout", defaultValue: "60", parse: intConfig(1, 1<<31-1), apply: applyReplTimeoutConfig},
+	{name: "repl-ping-replica-period", defaultValue: "10", parse: intConfig(1, 1<<31-1)},
+	{name: "replica-read-only", defaultValue: "yes", parse: yesNoConfig, apply: applyReplicaReadOnlyConfig},
+	{name: "replica-priority", defaultValue: strconv.Itoa(DefaultReplicaPriority), parse: intConfig(0, 1<<31-1)},
+
+	{name: "sentinel", defaultValue: "no", parse: yesNoConfig},
+	{name: "sentinel-monitor", defaultValue: "", parse: parseSentinelMonitorConfig},
+	{name: "sentinel-down-after-milliseconds", defaultValue: "30000", parse: intConfig(1, 1<<31-1)},
+	{name: "sentinel-failover-timeout", defaultValue: "180000", parse: intConfig(1, 1<<31-1)},
+	{name: "sentinel-known-sentinels", defaultValue: "", parse: stringConfig},
+
+	{name: "cluster-enabled", defaultValue: "no", parse: yesNoConfig},
+	{name: "cluster-port", defaultValue: "0", parse: intConfig(0, 65535)},
+	{name: "cluster-require-full-coverage", defaultValue: "yes", parse: yesNoConfig, apply: applyClusterCoverageConfig},
+
+	{name: "maxmemory", defaultValue: "0", parse: memoryConfig(0), apply: applyEvictionConfig},
+	{name: "maxmemory-policy", defaultValue: string(NoEviction), parse: enumConfig(NoEviction, AllKeysLRU, AllKeysLFU, AllKeysRandom, VolatileLRU, VolatileLFU, VolatileRandom, VolatileTTL), apply: applyEvictionConfig},
+	{name: "maxmemory-samples", defaultValue: strconv.Itoa(DefaultMaxMemorySamples), parse: intConfig(1, 64), apply: applyEvictionConfig},
+	{name: "lfu-log-factor", defaultValue: strconv.Itoa(DefaultLFULogFactor), parse: intConfig(0, 1<<31-1)},
+	{name: "lfu-decay-time", defaultValue: "1", parse: intConfig(0, 1<<31-1)},
+
+	{name: "timeout", defaultValue: "0", parse: intConfig(0, 1<<31-1), apply: applyClientTimeoutConfig},
+	{name: "tcp-keepalive", defaultValue: strconv.Itoa(int(DefaultTCPKeepAlive.Seconds())), parse: intConfig(0, 1<<31-1), apply: applyTCPKeepAliveConfig},
+	{name: "maxclients", defaultValue: strconv.Itoa(DefaultMaxClients), parse: intConfig(1, 1<<31-1), apply: applyMaxClientsConfig},
+	{name: "tcp-backlog", defaultValue: strconv.Itoa(DefaultTCPBacklog), parse: intConfig(0, 1<<31-1), apply: applyTCPBacklogConfig},
+	{name: "shutdown-timeout", defaultValue: strconv.Itoa(int(DefaultShutdownTimeout.Seconds())), parse: intConfig(0, 1<<31-1), apply: applyShutdownTimeoutConfig},
+	{name: "client-output-buffer-limit", defaultValue: DefaultOutputBufferLimits().String(), parse: parseOutputBufferLimitConfig, merges: true, apply: applyOutputBufferLimitConfig},
+}
+
+// configIndex indexes the registry by name. It is filled by init, as the
+// registry refers to functions that look parameters up.
+var configIndex = map[string]*configParam{}
+
+func init() {
+	for i := range configParams {
+		configIndex[configParams[i].name] = &configParams[i]
+	}
+}
+
+func lookupConfigParam(name string) (*configParam, bool) {
+	param, found := configIndex[name]
+	return param, found
+}
+
+// canonical validates a value replacing the current one, and returns its canonical form.
+func (p *configParam) canonical(current string, value string) (string, error) {
+	if p.merges {
+		value = current + " " + value
+	}
+
+	return p.parse(value)
+}
+
+func stringConfig(value string) (string, error) {
+	return value, nil
+}
+
+func yesNoConfig(value string) (string, error) {
+	switch lower := strings.ToLower(value); lower {
+	case "yes", "no":
+		return lower, nil
+	}
+
+	return "", errors.New("argument must be 'yes' or 'no'")
+}
+
+func intConfig(min int, max int) func(string) (string, error) {
+	return func(value string) (string, error) {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return "", errors.New("argument couldn't be parsed into an integer")
+		}
+		if n < min || n > max {
+			return "", fmt.Errorf("argument must be between %d and %d inclusive", min, max)
+		}
+
+		return strconv.Itoa(n), nil
+	}
+}
+
+// memoryConfig parses memory values, with their unit, into a number of bytes no lower than min.
+func memoryConfig(min int64) func(string) (string, error) {
+	return func(value string) (string, error) {
+		bytes, err := ParseMemory(value)
+		if err != nil {
+			return "", errors.New("argument must be a memory value")
+		}
+		if bytes < min {
+			return "", fmt.Errorf("argument must be a memory value of at least %d bytes", min)
+		}
+
+		return strconv.FormatInt(bytes, 10), nil
+	}
+}
+
+func enumConfig[T ~string](values ...T) func(string) (string, error) {
+	names := make([]string, len(values))
+	for i, value := range values {
+		names[i] = string(value)
+	}
+
+	return func(value string) (string, error) {
+		lower := strings.ToLower(value)
+		if slices.Contains(names, lower) {
+			return lower, nil
+		}
+
+		return "", fmt.Errorf("argument(s) must be one of the following: %s", strings.Join(names, ", "))
+	}
+}
+
+func parseSaveConfig(value string) (string, error) {
+	if _, err := ParseSavePolicies(value); err != nil {
+		return "", err
+	}
+
+	return strings.Join(strings.Fields(value), " "), nil
+}
+
+// parseReplicaOfConfig accepts "<host> <port>", or an empty value or "no one" for a master.
+func parseReplicaOfConfig(value string) (string, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 || (len(fields) == 2 && strings.EqualFold(fields[0], "no") && strings.EqualFold(fields[1], "one")) {
+		return "", nil
+	}
+	if len(fields) != 2 {
+		return "", errors.New("argument must be <host> <port>")
+	}
+	if _, err := strconv.Atoi(fields[1]); err != nil {
+		return "", fmt.Errorf("invalid master port %q", fields[1])
+	}
+
+	return fields[0] + " " + fields[1], nil
+}
+
+func parseSentinelMonitorConfig(value string) (string, error) {
+	if strings.TrimSpace(value) == "" {
+		return "", nil
+	}
+	if _, err := ParseSentinelMonitor(value); err != nil {
+		return "", err
+	}
+
+	return strings.Join(strings.Fields(value), " "), nil
+}
+
+func applySaveConfig(s *Server, value string) error {
+	policies, err := ParseSavePolicies(value)
+	if err != nil {
+		return err
+	}
+
+	s.rdb.mu.Lock()
+	s.savePolicies = policies
+	s.rdb.mu.Unlock()
+	return nil
+}
+
+// applyAppendOnlyConfig turns the AOF on, writing it from the dataset in the background, or off.
+func applyAppendOnlyConfig(s *Server, value string) error {
+	return s.setAOFEnabled(value == "yes")
+}
+
+func applyAppendFsyncConfig(s *Server, value string) error {
+	s.aof.mu.Lock()
+	s.aof.Fsync = AppendFsync(value)
+	s.aof.mu.Unlock()
+	return nil
+}
+
+// applyReplDisklessSyncConfig changes how the replicas that connect from now on receive the RDB.
+func applyReplDisklessSyncConfig(s *Server, value string) error {
+	s.replDisklessSync.Store(value == "yes")
+	return nil
+}
+
+func applyReplTimeoutConfig(s *Server, value string) error {
+	seconds, _ := strconv.Atoi(value)
+	s.replTimeout.Store(int64(time.Duration(seconds) * time.Second))
+	return nil
+}
+
+func applyReplicaReadOnlyConfig(s *Server, value string) error {
+	s.replicaReadOnly.Store(value == "yes")
+	return nil
+}
+
+func parseOutputBufferLimitConfig(value string) (string, error) {
+	limits, err := ParseOutputBufferLimits(value)
+	if err != nil {
+		return "", err
+	}
+
+	return limits.String(), nil
+}
+
+func applyOutputBufferLimitConfig(s *Server, value string) error {
+	limits, err := ParseOutputBufferLimits(value)
+	if err != nil {
+		return err
+	}
+
+	s.sessions.setOutputLimits(limits)
+	return nil
+}
+
+func applyClientTimeoutConfig(s *Server, value string) error {
+	seconds, _ := strconv.Atoi(value)
+
+	s.sessions.mu.Lock()
+	s.sessions.idleTimeout = time.Duration(seconds) * time.Second
+	s.sessions.mu.Unlock()
+	return nil
+}
+
+// applyTCPKeepAliveConfig changes the keep-alive period of the connections accepted from now on.
+func applyTCPKeepAliveConfig(s *Server, value string) error {
+	seconds, _ := strconv.Atoi(value)
+
+	s.sessions.mu.Lock()
+	s.sessions.keepAlive = time.Duration(seconds) * time.Second
+	s.sessions.mu.Unlock()
+	return nil
+}
+
+// applyMaxClientsConfig changes the limit of connections. The ones already served beyond it are kept.
+func applyMaxClientsConfig(s *Server, value string) error {
+	maxClients, _ := strconv.Atoi(value)
+
+	s.sessions.mu.Lock()
+	s.sessions.maxClients = maxClients
+	s.sessions.mu.Unlock()
+	return nil
+}
+
+func applyTCPBacklogConfig(s *Server, value string) error {
+	backlog, _ := strconv.Atoi(value)
+	return s.setTCPBacklog(backlog)
+}
+
+func applyShutdownTimeoutConfig(s *Server, value string) error {
+	seconds, _ := strconv.Atoi(value)
+
+	s.shutdown.mu.Lock()
+	s.shutdown.timeout = time.Duration(seconds) * time.Second
+	s.shutdown.mu.Unlock()
+	return nil
+}
+
+func applyClusterCoverageConfig(s *Server, value string) error {
+	s.cluster.mu.Lock()
+	s.cluster.RequireFullCoverage = value == "yes"
+	s.cluster.mu.Unlock()
+	return nil
+}
+
+// applyEvictionConfig applies the maxmemory parameters together, as they are read from the
+// configuration that is being changed.
+func applyEvictionConfig(s *Server, _ string) error {
+	maxMemory, _ := strconv.ParseInt(s.config.value("maxmemory"), 10, 64)
+	samples, _ := strconv.Atoi(s.config.value("maxmemory-samples"))
+
+	s.client.mu.Lock()
+	s.client.eviction = evictionConfig{
+		maxMemory: maxMemory,
+		policy:    EvictionPolicy(s.config.value("maxmemory-policy")),
+		samples:   samples,
+	}
+	s.client.mu.Unlock()
+	return nil
+}
+
+// Config holds the parameters set by the configuration file, the command line and CONFIG SET.
+// Parameters that were not set have their default value.
+type Config struct {
+	mu     sync.RWMutex
+	values map[string]string
+	// file is the configuration file the parameters were loaded from, rewritten by CONFIG REWRITE.
+	file string
+}
+
+func NewConfig() *Config {
+	return &Config{values: map[string]string{}}
+}
+
+// WithConfig configures the server with the parameters, and exposes them through CONFIG.
+// The parameters read at startup are set here, the others the way CONFIG SET sets them.
+func WithConfig(config *Config) func(*Server) {
+	return func(s *Server) {
+		s.config = config
+
+		s.dir = config.Get("dir")
+		s.dbFilename = config.Get("dbfilename")
+		s.aof.AOFConfig = AOFConfig{
+			Enabled:       config.Bool("appendonly"),
+			DirName:       config.Get("appenddirname"),
+			FileName:      config.Get("appendfilename"),
+			Fsync:         AppendFsync(config.Get("appendfsync")),
+			LoadTruncated: config.Bool("aof-load-truncated"),
+		}
+		s.repl.backlog = newBacklog(config.Int("repl-backlog-size"))
+		s.replPingPeriod = time.Duration(config.Int("repl-ping-replica-period")) * time.Second
+		s.replicaPriority = config.Int("replica-priority")
+		s.cluster.ClusterConfig = ClusterConfig{
+			Enabled:             config.Bool("cluster-enabled"),
+			Port:                config.Int("cluster-port"),
+			RequireFullCoverage: config.Bool("cluster-require-full-coverage"),
+		}
+
+		// The values were validated when they were set, and the server is not running yet,
+		// so applying them cannot fail. The AOF is already on if appendonly is set.
+		for _, param := range configParams {
+			if param.apply != nil {
+				param.apply(s, config.Get(param.name))
+			}
+		}
+	}
+}
+
+// LoadConfig loads the configuration from the arguments of the server: an optional
+// configuration file followed by --<name> <value>... options overriding its parameters.
+// The values following an option are joined with spaces, as in the file.
+func LoadConfig(args []string) (*Config, error) {
+	config := NewConfig()
+	if len(args) > 0 && !strings.HasPrefix(args[0], "--") {
+		if err := config.LoadFile(args[0]); err != nil {
+			return nil, err
+		}
+		args = args[1:]
+	}
+
+	for len(args) > 0 {
+		if !strings.HasPrefix(args[0], "--") {
+			return nil, fmt.Errorf("invalid option %q, expected --<name> <value>", args[0])
+		}
+
+		name := strings.ToLower(strings.TrimPrefix(args[0], "--"))
+		values := []string{}
+		for args = args[1:]; len(args) > 0 && !strings.HasPrefix(args[0], "--"); args = args[1:] {
+			values = append(values, args[0])
+		}
+
+		value := strings.Join(values, " ")
+		if name == "sentinel" && len(values) == 0 {
+			// A bare --sentinel starts a sentinel, as with redis-server.
+			value = "yes"
+		}
+		if err := config.Set(name, value); err != nil {
+			return nil, fmt.Errorf("invalid --%s: %w", name, err)
+		}
+	}
+
+	return config, nil
+}
+
+// LoadFile loads the parameters of a configuration file in the redis.conf format:
+// one "<name> <value>..." directive per line, # comments, and include <path> directives.
+// The file is the one CONFIG REWRITE rewrites.
+func (c *Config) LoadFile(file string) error {
+	if err := c.loadFile(file, map[string]bool{}, 0); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.file = file
+	c.mu.Unlock()
+	return nil
+}
+
+// loadFile loads the file and the files it includes, where the parameters in loaded were already set.
+func (c *Config) loadFile(file string, loaded map[string]bool, depth int) error {
+	if depth > configMaxIncludeDepth {
+		return fmt.Errorf("%s: includes nested more than %d levels deep", file, configMaxIncludeDepth)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	for number, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		args, err := splitConfigArgs(line)
+		if err == nil && len(args) < 2 {
+			err = ErrUnknownConfig
+		}
+		if err == nil {
+			name := strings.ToLower(args[0])
+			value := strings.Join(args[1:], " ")
+			if name == "include" {
+				err = c.loadFile(value, loaded, depth+1)
+			} else {
+				err = c.setLoaded(name, value, loaded)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("%s:%d: '%s': %w", file, number+1, line, err)
+		}
+	}
+
+	return nil
+}
+
+func (c *Config) setLoaded(name string, value string, loaded map[string]bool) error {
+	if param, found := lookupConfigParam(name); found && param.appends && loaded[name] && value != "" {
+		value = strings.TrimSpace(c.Get(name) + " " + value)
+	}
+
+	if err := c.Set(name, value); err != nil {
+		return err
+	}
+	loaded[name] = true
+	return nil
+}
+
+// Set validates the value of the parameter and sets it.
+func (c *Config) Set(name string, value string) error {
+	param, found := lookupConfigParam(strings.ToLower(name))
+	if !found {
+		return ErrUnknownConfig
+	}
+
+	canonical, err := param.canonical(c.Get(param.name), value)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.values[param.name] = canonical
+	c.mu.Unlock()
+	return nil
+}
+
+// Get returns the value of the parameter, in its canonical form.
+func (c *Config) Get(name string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.value(name)
+}
+
+// value must be called with mu held.
+func (c *Config) value(name string) string {
+	if value, found := c.values[name]; found {
+		return value
+	}
+
+	param, _ := lookupConfigParam(name)
+	return param.defaultValue
+}
+
+// File returns the configuration file the parameters were loaded from, if any.
+func (c *Config) File() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.file
+}
+
+// IsSet reports whether the parameter was set, rather than having its default value.
+func (c *Config) IsSet(name string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	_, found := c.values[name]
+	return found
+}
+
+func (c *Config) Bool(name string) bool {
+	return c.Get(name) == "yes"
+}
+
+// Int returns the value of an integer or memory parameter.
+func (c *Config) Int(name string) int {
+	n, _ := strconv.Atoi(c.Get(name))
+	return n
+}
+
+// Rewrite writes the current parameters to the configuration file. The lines of
+// the parameters it sets are updated in place, keeping comments and the rest of
+// the file as they are, and the parameters it does not set yet are appended when
+// they differ from their default.
+func (c *Config) Rewrite() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.file == "" {
+		return errors.New("the server is running without a config file")
+	}
+
+	data, err := os.ReadFile(c.file)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	lines := []string{}
+	if len(data) > 0 {
+		lines = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	}
+
+	rewritten := []string{}
+	written := map[string]bool{}
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			rewritten = append(rewritten, line)
+			continue
+		}
+
+		args, err := splitConfigArgs(trimmed)
+		if err != nil || len(args) == 0 {
+			rewritten = append(rewritten, line)
+			continue
+		}
+		name := strings.ToLower(args[0])
+		if _, found := lookupConfigParam(name); !found {
+			rewritten = append(rewritten, line)
+			continue
+		}
+
+		// Repeated parameters, like save policies written one per line, are collapsed into the first line.
+		if !written[name] {
+			rewritten = append(rewritten, formatConfigLine(name, c.value(name)))
+			written[name] = true
+		}
+	}
+
+	signed := slices.Contains(rewritten, configRewriteSignature)
+	for _, param := range configParams {
+		value := c.value(param.name)
+		if written[param.name] || value == param.defaultValue {
+			continue
+		}
+
+		if !signed {
+			rewritten = append(rewritten, configRewriteSignature)
+			signed = true
+		}
+		rewritten = append(rewritten, formatConfigLine(param.name, value))
+	}
+
+	return writeFileAtomically(c.file, []byte(strings.Join(rewritten, "\n")+"\n"))
+}
+
+// writeFileAtomically replaces the file through a temporary file, so that it is never left half written.
+func writeFileAtomically(file string, data []byte) error {
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(file); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Sync(); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(temp.Name(), mode); err != nil {
+		return err
+	}
+
+	return os.Rename(temp.Name(), file)
+}
+
+// formatConfigLine formats a directive. Values with spaces are written as several
+// arguments, which are joined back when loaded; values that would not survive that are quoted.
+func formatConfigLine(name string, value string) string {
+	if value != "" && !strings.ContainsFunc(value, func(r rune) bool {
+		return r < ' ' || r > '~' || r == '"' || r == '\'' || r == '\\'
+	}) {
+		return name + " " + value
+	}
+
+	var quoted strings.Builder
+	quoted.WriteByte('"')
+	for i := 0; i < len(value); i++ {
+		switch b := value[i]; b {
+		case '\\', '"':
+			quoted.WriteByte('\\')
+			quoted.WriteByte(b)
+		case '\n':
+			quoted.WriteString(`\n`)
+		case '\r':
+			quoted.WriteString(`\r`)
+		case '\t':
+			quoted.WriteString(`\t`)
+		case '\a':
+			quoted.WriteString(`\a`)
+		case '\b':
+			quoted.WriteString(`\b`)
+		default:
+			if b < ' ' || b > '~' {
+				fmt.Fprintf(&quoted, `\x%02x`, b)
+			} else {
+				quoted.WriteByte(b)
+			}
+		}
+	}
+	quoted.WriteByte('"')
+
+	return name + " " + quoted.String()
+}
+
+// splitConfigArgs splits a configuration line into its arguments, the way Redis does:
+// arguments are separated by spaces, and may be quoted. Double quoted arguments
+// support the \n, \r, \t, \a, \b and \xHH escapes, single quoted ones only \'.
+func splitConfigArgs(line string) ([]string, error) {
+	args := []string{}
+	i := 0
+	for {
+		for i < len(line) && isConfigSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+
+		var arg []byte
+		switch quote := line[i]; quote {
+		case '"', '\'':
+			i++
+			for {
+				if i == len(line) {
+					return nil, errors.New("unbalanced quotes in configuration line")
+				}
+
+				b := line[i]
+				if b == quote {
+					i++
+					if i < len(line) && !isConfigSpace(line[i]) {
+						return nil, errors.New("closing quote must be followed by a space or nothing at all")
+					}
+					break
+				}
+
+				if b == '\\' && i+1 < len(line) {
+					if quote == '\'' {
+						if line[i+1] == '\'' {
+							arg = append(arg, '\'')
+							i += 2
+							continue
+						}
+					} else if line[i+1] == 'x' && i+3 < len(line) {
+						if value, err := strconv.ParseUint(line[i+2:i+4], 16, 8); err == nil {
+							arg = append(arg, byte(value))
+							i += 4
+							continue
+						}
+					} else {
+						arg = append(arg, unescapeConfigByte(line[i+1]))
+						i += 2
+						continue
+					}
+				}
+
+				arg = append(arg, b)
+				i++
+			}
+		default:
+			for i < len(line) && !isConfigSpace(line[i]) {
+				arg = append(arg, line[i])
+				i++
+			}
+		}
+
+		args = append(args, string(arg))
+	}
+}
+
+func unescapeConfigByte(b byte) byte {
+	switch b {
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	case 'a':
+		return '\a'
+	case 'b':
+		return '\b'
+	default:
+		return b
+	}
+}
+
+func isConfigSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\r' || b == '\n' || b == '\v' || b == '\f'
+}
+
+var configHelp = []string{
+	"CONFIG <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"GET <pattern>",
+	"    Return parameters matching the glob-like <pattern> and their values.",
+	"SET <directive> <value>",
+	"    Set the configuration <directive> to <value>.",
+	"RESETSTAT",
+	"    Reset statistics reported by the INFO command.",
+	"REWRITE",
+	"    Rewrite the configuration file.",
+	"HELP",
+	"    Print this help.",
+}
+
+// configCommand implements CONFIG GET pattern..., CONFIG SET name value..., CONFIG RESETSTAT,
+// CONFIG REWRITE and CONFIG HELP.
+func (s *Server) configCommand(cmd Command) Value {
+	if len(cmd.Args) == 0 {
+		return wrongArgumentsError(cmd)
+	}
+
+	subcommand := strings.ToLower(cmd.Args[0])
+	switch subcommand {
+	case "get":
+		if len(cmd.Args) < 2 {
+			return errorValue("ERR wrong number of arguments for 'config|get' command")
+		}
+		return s.configGet(cmd.Args[1:])
+
+	case "set":
+		if len(cmd.Args) < 3 || len(cmd.Args)%2 == 0 {
+			return errorValue("ERR wrong number of arguments for 'config|set' command")
+		}
+		return s.configSet(cmd.Args[1:])
+
+	case "resetstat", "rewrite":
+		if len(cmd.Args) != 1 {
+			return errorValue("ERR wrong number of arguments for 'config|%s' command", subcommand)
+		}
+		if subcommand == "resetstat" {
+			s.client.ResetStats()
+			s.stats.reset()
+			return Value{Type: SimpleString, SimpleString: "OK"}
+		}
+
+		if err := s.config.Rewrite(); err != nil {
+			s.logger.Println("CONFIG REWRITE failed:", err)
+			return errorValue("ERR Rewriting config file: %s", err)
+		}
+		s.logger.Println("CONFIG REWRITE executed with success.")
+		return Value{Type: SimpleString, SimpleString: "OK"}
+
+	case "help":
+		return bulkFields(configHelp...)
+	}
+
+	return errorValue("ERR unknown subcommand '%s'. Try CONFIG HELP.", cmd.Args[0])
+}
+
+// configGet returns the parameters matching any of the glob-style patterns, with their values.
+func (s *Server) configGet(patterns []string) Value {
+	fields := []string{}
+	for _, param := range configParams {
+		for _, pattern := range patterns {
+			if matched, _ := path.Match(strings.ToLower(pattern), param.name); matched {
+				fields = append(fields, param.name, s.config.Get(param.name))
+				break
+			}
+		}
+	}
+
+	return bulkFields(fields...)
+}
+
+// configSet sets the name value pairs. Every value is validated before any is applied,
+// and the values already applied are reverted if one fails to apply, so that either all or none are set.
+func (s *Server) configSet(args []string) Value {
+	type change struct {
+		param    *configParam
+		value    string
+		previous string
+	}
+
+	s.config.mu.Lock()
+	defer s.config.mu.Unlock()
+
+	changes := []change{}
+	for i := 0; i < len(args); i += 2 {
+		name := strings.ToLower(args[i])
+		param, found := lookupConfigParam(name)
+		if !found {
+			return errorValue("ERR Unknown option or number of args for CONFIG SET - '%s'", args[i])
+		}
+		if param.apply == nil {
+			return errorValue("ERR CONFIG SET failed (possibly related to argument '%s') - can't set immutable config", args[i])
+		}
+		if slices.ContainsFunc(changes, func(c change) bool { return c.param == param }) {
+			return errorValue("ERR CONFIG SET failed (possibly related to argument '%s') - duplicate parameter", args[i])
+		}
+
+		value, err := param.canonical(s.config.value(param.name), args[i+1])
+		if err != nil {
+			return errorValue("ERR CONFIG SET failed (possibly related to argument '%s') - %s", args[i], err)
+		}
+		changes = append(changes, change{param: param, value: value, previous: s.config.value(name)})
+	}
+
+	for _, change := range changes {
+		s.config.values[change.param.name] = change.value
+	}
+	for i, change := range changes {
+		if err := change.param.apply(s, change.value); err != nil {
+			for _, applied := range changes {
+				s.config.values[applied.param.name] = applied.previous
+			}
+			for _, applied := range changes[:i] {
+				applied.param.apply(s, applied.previous)
+			}
+			return errorValue("ERR CONFIG SET failed (possibly related to argument '%s') - %s", change.param.name, err)
+		}
+	}
+
+	return Value{Type: SimpleString, SimpleString: "OK"}
+}
@@ -0,0 +1,176 @@
+package redis_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfig(t *testing.T, dir string, name string, content string) string {
+	t.Helper()
+
+	file := filepath.Join(dir, name)
+	assert.NoError(t, os.WriteFile(file, []byte(content), 0o644))
+	return file
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Run("file, includes and overrides", func(t *testing.T) {
+		dir := t.TempDir()
+		included := writeConfig(t, dir, "included.conf", "maxmemory-policy allkeys-LRU\n")
+		file := writeConfig(t, dir, "redis.conf", "# comment\n"+
+			"port 7000\n"+
+			"include "+included+"\n"+
+			"  MAXMEMORY 2mb\n"+
+			"save 900 1\n"+
+			"save 300 10\n"+
+			`dbfilename "my dump.rdb"`+"\n")
+
+		config, err := redis.LoadConfig([]string{file, "--port", "7001", "--replicaof", "localhost", "6379"})
+		assert.NoError(t, err)
+
+		assert.Equal(t, "7001", config.Get("port"))
+		assert.Equal(t, "localhost 6379", config.Get("replicaof"))
+		assert.Equal(t, "allkeys-lru", config.Get("maxmemory-policy"))
+		assert.Equal(t, 2<<20, config.Int("maxmemory"))
+		assert.Equal(t, "900 1 300 10", config.Get("save"))
+		assert.Equal(t, "my dump.rdb", config.Get("dbfilename"))
+		assert.Equal(t, "everysec", config.Get("appendfsync"))
+		assert.False(t, config.IsSet("appendfsync"))
+	})
+
+	t.Run("bare sentinel option and empty values", func(t *testing.T) {
+		config, err := redis.LoadConfig([]string{"--sentinel", "--save", ""})
+		assert.NoError(t, err)
+
+		assert.True(t, config.Bool("sentinel"))
+		assert.Equal(t, "", config.Get("save"))
+	})
+
+	t.Run("values completing the current one", func(t *testing.T) {
+		file := writeConfig(t, t.TempDir(), "redis.conf", "client-output-buffer-limit normal 1mb 512kb 10\n"+
+			"client-output-buffer-limit pubsub 0 0 0\n")
+
+		config, err := redis.LoadConfig([]string{file, "--client-output-buffer-limit", "slave", "64mb", "16mb", "30"})
+		assert.NoError(t, err)
+
+		assert.Equal(t, "normal 1048576 524288 10 replica 67108864 16777216 30 pubsub 0 0 0", config.Get("client-output-buffer-limit"))
+	})
+
+	invalid := []struct {
+		name    string
+		content string
+	}{
+		{"unknown directive", "foo bar\n"},
+		{"missing value", "port\n"},
+		{"invalid value", "appendonly maybe\n"},
+		{"unbalanced quotes", `dir "/tmp` + "\n"},
+		{"text after a closing quote", `dir "/tmp"x` + "\n"},
+		{"unknown client class", "client-output-buffer-limit master 0 0 0\n"},
+	}
+	for _, tc := range invalid {
+		t.Run(tc.name, func(t *testing.T) {
+			file := writeConfig(t, t.TempDir(), "redis.conf", tc.content)
+
+			_, err := redis.LoadConfig([]string{file})
+			assert.Error(t, err)
+		})
+	}
+
+	t.Run("include cycle", func(t *testing.T) {
+		dir := t.TempDir()
+		file := filepath.Join(dir, "redis.conf")
+		writeConfig(t, dir, "redis.conf", "include "+file+"\n")
+
+		_, err := redis.LoadConfig([]string{file})
+		assert.Error(t, err)
+	})
+}
+
+func TestConfigRewrite(t *testing.T) {
+	dir := t.TempDir()
+	file := writeConfig(t, dir, "redis.conf", "# Persistence\n"+
+		"save 900 1\n"+
+		"save 300 10\n"+
+		"\n"+
+		"# Memory\n"+
+		"maxmemory 1mb\n"+
+		"port 7000\n")
+
+	config := redis.NewConfig()
+	assert.NoError(t, config.LoadFile(file))
+	assert.NoError(t, config.Set("save", "60 1000"))
+	assert.NoError(t, config.Set("port", "6379"))
+	assert.NoError(t, config.Set("maxmemory-policy", "allkeys-lfu"))
+	assert.NoError(t, config.Set("dir", `/tmp/a "quoted" dir`))
+
+	assert.NoError(t, config.Rewrite())
+	content, err := os.ReadFile(file)
+	assert.NoError(t, err)
+	assert.Equal(t, "# Persistence\n"+
+		"save 60 1000\n"+
+		"\n"+
+		"# Memory\n"+
+		"maxmemory 1048576\n"+
+		"port 6379\n"+
+		"# Generated by CONFIG REWRITE\n"+
+		`dir "/tmp/a \"quoted\" dir"`+"\n"+
+		"maxmemory-policy allkeys-lfu\n", string(content))
+
+	reloaded, err := redis.LoadConfig([]string{file})
+	assert.NoError(t, err)
+	assert.Equal(t, `/tmp/a "quoted" dir`, reloaded.Get("dir"))
+	assert.Equal(t, "60 1000", reloaded.Get("save"))
+
+	assert.NoError(t, config.Rewrite())
+	again, err := os.ReadFile(file)
+	assert.NoError(t, err)
+	assert.Equal(t, string(content), string(again))
+}
+
+func TestServerConfig(t *testing.T) {
+	dir := t.TempDir()
+	config := redis.NewConfig()
+	assert.NoError(t, config.Set("dir", dir))
+	assert.NoError(t, config.Set("maxmemory-policy", "allkeys-lfu"))
+	assert.NoError(t, config.Set("replica-read-only", "no"))
+
+	master := startServer(t)
+	replica := startServer(t, redis.WithConfig(config))
+	assert.Contains(t, replica.do("INFO", "memory").Bulk, "maxmemory_policy:allkeys-lfu")
+
+	replicate(t, replica, master)
+	assert.Equal(t, okReply, replica.do("SET", "local", "1"))
+	assert.Equal(t, okReply, replica.do("CONFIG", "SET", "replica-read-only", "yes", "repl-timeout", "30", "repl-diskless-sync", "no"))
+	assert.Equal(t, redis.Value{Type: redis.Error, Error: "READONLY You can't write against a read only replica."}, replica.do("SET", "local", "2"))
+
+	assert.Equal(t, okReply, replica.do("REPLICAOF", "NO", "ONE"))
+	assert.Equal(t, redis.Value{Type: redis.Error, Error: "ERR AOF is disabled, enable it with appendonly yes"}, replica.do("BGREWRITEAOF"))
+
+	// Turning the AOF on writes it from the dataset, and the writes that follow are appended to it.
+	assert.Equal(t, okReply, replica.do("CONFIG", "SET", "appendonly", "yes"))
+	assert.Contains(t, replica.do("INFO", "persistence").Bulk, "aof_enabled:1")
+	assert.Eventually(t, func() bool {
+		return strings.Contains(replica.do("INFO", "persistence").Bulk, "aof_rewrite_in_progress:0")
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, okReply, replica.do("SET", "appended", "1"))
+
+	manifest, err := os.ReadFile(filepath.Join(dir, "appendonlydir", "appendonly.aof.manifest"))
+	assert.NoError(t, err)
+	assert.Equal(t, "file appendonly.aof.1.base.rdb seq 1 type b\nfile appendonly.aof.1.incr.aof seq 1 type i\n", string(manifest))
+	incr, err := os.ReadFile(filepath.Join(dir, "appendonlydir", "appendonly.aof.1.incr.aof"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(incr), "appended")
+
+	assert.Equal(t, okReply, replica.do("CONFIG", "SET", "appendonly", "no"))
+	assert.Contains(t, replica.do("INFO", "persistence").Bulk, "aof_enabled:0")
+	assert.Equal(t, okReply, replica.do("SET", "dropped", "1"))
+	incr, err = os.ReadFile(filepath.Join(dir, "appendonlydir", "appendonly.aof.1.incr.aof"))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(incr), "dropped")
+}
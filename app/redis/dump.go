@@ -0,0 +1,174 @@
+package redis
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EncodeDump serializes a value the way DUMP does: its RDB type and encoding,
+// followed by the RDB version and the CRC64 of everything before it, both little endian.
+func EncodeDump(value string) []byte {
+	var buf bytes.Buffer
+	rw := &rdbWriter{w: &buf}
+
+	rw.writeByte(rdbTypeString)
+	rw.writeString(value)
+
+	version := make([]byte, 2)
+	binary.LittleEndian.PutUint16(version, rdbVersion)
+	rw.write(version)
+
+	checksum := make([]byte, 8)
+	binary.LittleEndian.PutUint64(checksum, rw.crc)
+	rw.write(checksum)
+
+	return buf.Bytes()
+}
+
+// DecodeDump verifies the version and checksum of a DUMP payload and decodes its value.
+func DecodeDump(payload []byte) (string, error) {
+	if len(payload) < 10 {
+		return "", fmt.Errorf("%w: DUMP payload is too short", ErrInvalidRDB)
+	}
+
+	footer := payload[len(payload)-10:]
+	version := binary.LittleEndian.Uint16(footer[:2])
+	if version > rdbVersion {
+		return "", fmt.Errorf("%w: unsupported DUMP payload version %d", ErrInvalidRDB, version)
+	}
+	if CRC64(0, payload[:len(payload)-8]) != binary.LittleEndian.Uint64(footer[2:]) {
+		return "", fmt.Errorf("%w: DUMP payload checksum mismatch", ErrInvalidRDB)
+	}
+
+	body := bytes.NewReader(payload[:len(payload)-10])
+	rr := &rdbReader{r: body}
+	valueType, err := rr.readByte()
+	if err != nil {
+		return "", err
+	}
+	if valueType != rdbTypeString {
+		return "", fmt.Errorf("%w: unsupported value type %d", ErrInvalidRDB, valueType)
+	}
+
+	value, err := rr.readString()
+	if err != nil {
+		return "", err
+	}
+	if body.Len() != 0 {
+		return "", fmt.Errorf("%w: trailing data in DUMP payload", ErrInvalidRDB)
+	}
+
+	return value, nil
+}
+
+// restore implements RESTORE key ttl payload [REPLACE] [ABSTTL] [IDLETIME seconds] [FREQ frequency].
+// A relative TTL is propagated as an absolute one, so that replicas agree on the expiry.
+func (c *Client) restore(session *Session, store Store, cmd Command) Value {
+	if len(cmd.Args) < 3 {
+		return wrongArgumentsError(cmd)
+	}
+
+	key, payload := cmd.Args[0], cmd.Args[2]
+	ttl, err := strconv.ParseInt(cmd.Args[1], 10, 64)
+	if err != nil {
+		return errorValue("ERR value is not an integer or out of range")
+	}
+	if ttl < 0 {
+		return errorValue("ERR Invalid TTL value, must be >= 0")
+	}
+
+	var replace, absTTL bool
+	var idleTime, freq *int64
+	for i := 3; i < len(cmd.Args); i++ {
+		switch option := strings.ToUpper(cmd.Args[i]); option {
+		case "REPLACE":
+			replace = true
+		case "ABSTTL":
+			absTTL = true
+		case "IDLETIME", "FREQ":
+			if i+1 == len(cmd.Args) {
+				return errorValue("ERR syntax error")
+			}
+
+			i++
+			amount, err := strconv.ParseInt(cmd.Args[i], 10, 64)
+			if err != nil {
+				return errorValue("ERR value is not an integer or out of range")
+			}
+			if option == "IDLETIME" {
+				if amount < 0 {
+					return errorValue("ERR Invalid IDLETIME value, must be >= 0")
+				}
+				idleTime = &amount
+			} else {
+				if amount < 0 || amount > 255 {
+					return errorValue("ERR Invalid FREQ value, must be >= 0 and <= 255")
+				}
+				freq = &amount
+			}
+		default:
+			return errorValue("ERR syntax error")
+		}
+	}
+	if idleTime != nil && freq != nil {
+		return errorValue("ERR syntax error")
+	}
+
+	_, exists := store.GetEntry(key)
+	if exists && !replace {
+		return errorValue("BUSYKEY Target key name already exists.")
+	}
+
+	value, err := DecodeDump([]byte(payload))
+	if err != nil {
+		return errorValue("ERR DUMP payload version or checksum are wrong")
+	}
+
+	var expiresAt *time.Time
+	if ttl > 0 {
+		unit := "PX"
+		if absTTL {
+			unit = "PXAT"
+		}
+		at, ok := c.expiryTime(unit, ttl)
+		if !ok {
+			return errorValue("ERR invalid expire time in 'restore' command")
+		}
+		expiresAt = &at
+	}
+
+	if expiresAt != nil && !expiresAt.After(c.nower()) {
+		// The key expired in transit: it is not created, but it still replaces the existing one.
+		if exists {
+			store.Delete(key)
+			c.dirty.Add(1)
+			session.alsoPropagate(session.db, NewCommandFromArgs(string(Del), key))
+		}
+		return Value{Type: SimpleString, SimpleString: "OK"}
+	}
+
+	store.SetEntry(key, Entry{Value: value, ExpiresAt: expiresAt})
+	if idleTime != nil {
+		store.SetIdle(key, time.Duration(min(*idleTime, lruClockMax))*time.Second)
+	}
+	if freq != nil {
+		store.SetFrequency(key, uint8(*freq))
+	}
+	c.dirty.Add(1)
+	if expiresAt != nil && !absTTL {
+		args := []string{string(Restore), key, strconv.FormatInt(expiresAt.UnixMilli(), 10), payload, "REPLACE", "ABSTTL"}
+		if idleTime != nil {
+			args = append(args, "IDLETIME", strconv.FormatInt(*idleTime, 10))
+		}
+		if freq != nil {
+			args = append(args, "FREQ", strconv.FormatInt(*freq, 10))
+		}
+		session.alsoPropagate(session.db, NewCommandFromArgs(args...))
+	}
+
+	return Value{Type: SimpleString, SimpleString: "OK"}
+}
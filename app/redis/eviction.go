@@ -0,0 +1,143 @@
+package redis
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strings"
+)
+
+// EvictionPolicy selects the keys evicted when the dataset uses more than maxmemory.
+type EvictionPolicy string
+
+const (
+	NoEviction     EvictionPolicy = "noeviction"
+	AllKeysLRU     EvictionPolicy = "allkeys-lru"
+	AllKeysLFU     EvictionPolicy = "allkeys-lfu"
+	AllKeysRandom  EvictionPolicy = "allkeys-random"
+	VolatileLRU    EvictionPolicy = "volatile-lru"
+	VolatileLFU    EvictionPolicy = "volatile-lfu"
+	VolatileRandom EvictionPolicy = "volatile-random"
+	VolatileTTL    EvictionPolicy = "volatile-ttl"
+
+	// DefaultMaxMemorySamples is how many keys of every database are sampled to pick each evicted key.
+	DefaultMaxMemorySamples = 5
+)
+
+func ParseEvictionPolicy(policy string) (EvictionPolicy, error) {
+	switch parsed := EvictionPolicy(strings.ToLower(policy)); parsed {
+	case NoEviction, AllKeysLRU, AllKeysLFU, AllKeysRandom, VolatileLRU, VolatileLFU, VolatileRandom, VolatileTTL:
+		return parsed, nil
+	}
+
+	return "", fmt.Errorf("unknown maxmemory policy %q", policy)
+}
+
+// volatile reports whether the policy only evicts keys with an expiry.
+func (p EvictionPolicy) volatile() bool {
+	return strings.HasPrefix(string(p), "volatile-")
+}
+
+// lfu reports whether the policy evicts by access frequency, which keys then track instead of their idle time.
+func (p EvictionPolicy) lfu() bool {
+	return p == AllKeysLFU || p == VolatileLFU
+}
+
+type evictionConfig struct {
+	// maxMemory is the memory the dataset may use, 0 for no limit.
+	maxMemory int64
+	policy    EvictionPolicy
+	samples   int
+}
+
+// WithMaxMemory limits the memory used by the dataset, beyond which keys are evicted according to the policy.
+func WithMaxMemory(maxMemory int64, policy EvictionPolicy, samples int) func(*Client) {
+	return func(c *Client) {
+		c.eviction = evictionConfig{maxMemory: maxMemory, policy: policy, samples: samples}
+	}
+}
+
+// UsedMemory returns the approximate memory used by the dataset.
+func (c *Client) UsedMemory() int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	used := int64(0)
+	for _, db := range c.databases {
+		used += db.UsedMemory()
+	}
+
+	return used
+}
+
+func (c *Client) EvictedKeys() int64 {
+	return c.evictedKeys.Load()
+}
+
+// Evict deletes keys according to the eviction policy until the dataset fits in
+// maxmemory, recording their deletion in the session. It reports whether the
+// dataset fits, which it cannot under noeviction or when no key is eligible.
+func (c *Client) Evict(session *Session) bool {
+	c.mu.RLock()
+	config := c.eviction
+	c.mu.RUnlock()
+
+	if config.maxMemory == 0 || !c.master.Load() {
+		return true
+	}
+
+	c.frozen.RLock()
+	defer c.frozen.RUnlock()
+
+	for c.UsedMemory() > config.maxMemory {
+		if config.policy == NoEviction {
+			return false
+		}
+
+		db, key, found := c.evictionCandidate(config)
+		if !found {
+			return false
+		}
+
+		c.db(db).Delete(key)
+		c.evictedKeys.Add(1)
+		session.alsoPropagate(db, NewCommandFromArgs(string(Del), key))
+	}
+
+	return true
+}
+
+// evictionCandidate samples keys of every database and returns the best one to evict under the policy.
+func (c *Client) evictionCandidate(config evictionConfig) (int, string, bool) {
+	bestDB := -1
+	var best KeyStats
+	candidates := 0
+	for db := range c.Databases() {
+		for _, stats := range c.db(db).Sample(config.samples, config.policy.volatile()) {
+			candidates++
+			if bestDB < 0 || evictsBefore(config.policy, stats, best, candidates) {
+				bestDB, best = db, stats
+			}
+		}
+	}
+
+	return bestDB, best.Key, bestDB >= 0
+}
+
+// evictsBefore reports whether the sampled key should be evicted rather than the current best,
+// candidates being the number of keys sampled so far.
+func evictsBefore(policy EvictionPolicy, sampled KeyStats, best KeyStats, candidates int) bool {
+	switch policy {
+	case AllKeysLRU, VolatileLRU:
+		return sampled.Idle > best.Idle
+	case AllKeysLFU, VolatileLFU:
+		if sampled.Frequency != best.Frequency {
+			return sampled.Frequency < best.Frequency
+		}
+		return sampled.Idle > best.Idle
+	case VolatileTTL:
+		return sampled.ExpiresAt.Before(*best.ExpiresAt)
+	default:
+		// Reservoir sampling keeps every sampled key equally likely to be picked.
+		return rand.IntN(candidates) == 0
+	}
+}
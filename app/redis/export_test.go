@@ -0,0 +1,64 @@
+package redis
+
+import (
+	"os"
+	"time"
+)
+
+// These hooks let the sentinel tests drive the checks the cron goroutine runs.
+
+func (s *Sentinel) Handle(cmd Command) Value {
+	return s.handle(cmd)
+}
+
+func (s *Sentinel) CheckMaster(name string) {
+	s.mu.Lock()
+	m := s.masters[name]
+	s.mu.Unlock()
+
+	s.checkMaster(m)
+}
+
+// RequestVotes runs an election for the master in epoch, and returns the votes
+// this sentinel got and the votes it needed.
+func (s *Sentinel) RequestVotes(name string, epoch int64) (int, int) {
+	s.mu.Lock()
+	m := s.masters[name]
+	s.mu.Unlock()
+
+	return s.requestVotes(m.Host, m.Port, epoch), s.votesNeeded(m.Quorum)
+}
+
+type SentinelReplica struct {
+	Host     string
+	Port     string
+	Priority int
+	Offset   int
+	LastOK   time.Time
+}
+
+// PromotionOrder returns the addresses of the replicas that can be promoted, the best first.
+func PromotionOrder(replicas []SentinelReplica, now time.Time) []string {
+	known := map[string]*sentinelReplica{}
+	for _, r := range replicas {
+		replica := &sentinelReplica{host: r.Host, port: r.Port, priority: r.Priority, offset: r.Offset, lastOK: r.LastOK, role: slave}
+		known[replica.address()] = replica
+	}
+
+	addresses := []string{}
+	for _, r := range promotionCandidates(known, now) {
+		addresses = append(addresses, r.address())
+	}
+	return addresses
+}
+
+// SwapAOFFile makes the server append to file instead of its current incremental file,
+// which it returns, so that the tests can make writing fail.
+func (s *Server) SwapAOFFile(file *os.File) *os.File {
+	s.aof.mu.Lock()
+	defer s.aof.mu.Unlock()
+
+	previous := s.aof.file
+	s.aof.file = file
+	return previous
+}
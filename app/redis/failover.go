@@ -0,0 +1,300 @@
+package redis
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	failoverNone       = "no-failover"
+	failoverWaitSync   = "waiting-for-sync"
+	failoverInProgress = "failover-in-progress"
+)
+
+type failoverState struct {
+	// switchMu serializes the role changes of a failover: handing over to the
+	// target, and turning back into a master when it is aborted or fails. It is
+	// taken before mu and execMu.
+	switchMu sync.Mutex
+
+	mu    sync.Mutex
+	state string
+	// abort is closed by FAILOVER ABORT, it is nil once the failover ended.
+	abort chan struct{}
+}
+
+func (s *Server) failoverState() string {
+	s.failover.mu.Lock()
+	defer s.failover.mu.Unlock()
+
+	return s.failover.state
+}
+
+// failoverCommand implements FAILOVER [TO host port] [TIMEOUT ms] [FORCE] and FAILOVER ABORT.
+// The failover itself runs in the background, its progress is reported by INFO.
+func (s *Server) failoverCommand(cmd Command) Value {
+	if len(cmd.Args) == 1 && strings.EqualFold(cmd.Args[0], "abort") {
+		if !s.abortFailover() {
+			return errorValue("ERR No failover in progress.")
+		}
+		return Value{Type: SimpleString, SimpleString: "OK"}
+	}
+
+	var host, port string
+	var timeout time.Duration
+	var force bool
+	for i := 0; i < len(cmd.Args); i++ {
+		switch strings.ToUpper(cmd.Args[i]) {
+		case "TO":
+			if i+2 >= len(cmd.Args) {
+				return errorValue("ERR syntax error")
+			}
+			host, port = cmd.Args[i+1], cmd.Args[i+2]
+			i += 2
+		case "TIMEOUT":
+			if i+1 >= len(cmd.Args) {
+				return errorValue("ERR syntax error")
+			}
+			ms, err := strconv.Atoi(cmd.Args[i+1])
+			if err != nil || ms <= 0 {
+				return errorValue("ERR FAILOVER timeout must be greater than 0")
+			}
+			timeout = time.Duration(ms) * time.Millisecond
+			i++
+		case "FORCE":
+			force = true
+		default:
+			return errorValue("ERR syntax error")
+		}
+	}
+
+	if s.role() == slave {
+		return errorValue("ERR FAILOVER is not valid when server is a replica.")
+	}
+	if force && (host == "" || timeout == 0) {
+		return errorValue("ERR FAILOVER with force option requires both a timeout and target HOST and PORT.")
+	}
+
+	// The host is resolved once, as a slow lookup must not hold back other commands.
+	ips := resolveHost(host)
+
+	s.execMu.Lock()
+	replicas := len(s.replicas)
+	found := false
+	for _, r := range s.replicas {
+		found = found || r.matches(ips, port)
+	}
+	s.execMu.Unlock()
+
+	if replicas == 0 {
+		return errorValue("ERR FAILOVER requires connected replicas.")
+	}
+	if host != "" && !found {
+		return errorValue("ERR FAILOVER target HOST and PORT is not a replica.")
+	}
+
+	s.failover.mu.Lock()
+	if s.failover.state != failoverNone {
+		s.failover.mu.Unlock()
+		return errorValue("ERR FAILOVER already in progress.")
+	}
+	s.failover.state = failoverWaitSync
+	abort := make(chan struct{})
+	s.failover.abort = abort
+	s.failover.mu.Unlock()
+
+	s.goTracked(func() { s.runFailover(ips, port, timeout, force, abort) })
+
+	return Value{Type: SimpleString, SimpleString: "OK"}
+}
+
+// runFailover pauses writes until a replica, or the target one, caught up
+// with the master, then makes this server a replica of it. The handshake asks
+// the replica to promote itself with PSYNC FAILOVER.
+func (s *Server) runFailover(ips []net.IP, port string, timeout time.Duration, force bool, abort <-chan struct{}) {
+	s.pause.pause(pauseByFailover, false, time.Time{})
+
+	s.execMu.Lock()
+	_, offset := s.replicationInfo()
+	err := s.feedReplicas(NewCommandFromArgs(string(ReplConf), "GETACK", "*"))
+	s.execMu.Unlock()
+	if err != nil {
+		s.logger.Println("Failed to ask replicas for acknowledgements:", err)
+	}
+
+	s.logger.Printf("Failover: writes are paused, waiting for a replica to reach offset %d\n", offset)
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	var host string
+	for {
+		targetHost, targetPort, caughtUp, acked := s.failoverTarget(ips, port, offset)
+		if caughtUp {
+			host, port = targetHost, targetPort
+			break
+		}
+
+		select {
+		case <-acked:
+			continue
+		case <-abort:
+			return
+		case <-s.ctx.Done():
+			s.endFailover()
+			return
+		case <-expired:
+		}
+
+		if !force {
+			s.logger.Println("Failover: timed out waiting for a replica to catch up")
+			s.endFailover()
+			return
+		}
+
+		s.logger.Println("Failover: timed out, forcing the failover")
+		host = ips[0].String()
+		break
+	}
+
+	// An abort either comes first and the failover stops here, or waits for the
+	// hand over and turns this server back into a master.
+	s.failover.switchMu.Lock()
+	defer s.failover.switchMu.Unlock()
+
+	s.failover.mu.Lock()
+	select {
+	case <-abort:
+		s.failover.mu.Unlock()
+		return
+	default:
+	}
+	s.failover.state = failoverInProgress
+	s.failover.mu.Unlock()
+
+	s.logger.Printf("Failover: handing over to %s:%s\n", host, port)
+	s.replicaOf(host, port)
+}
+
+// failoverTarget looks for a replica, matching host and port if set, that
+// acknowledged the offset. It also returns a channel closed on the next acknowledgement.
+func (s *Server) failoverTarget(ips []net.IP, port string, offset int) (string, string, bool, <-chan struct{}) {
+	s.execMu.Lock()
+	defer s.execMu.Unlock()
+
+	s.repl.mu.Lock()
+	acked := s.repl.acked
+	s.repl.mu.Unlock()
+
+	for _, r := range s.replicas {
+		if !r.matches(ips, port) || r.acknowledged() < offset {
+			continue
+		}
+
+		replicaHost, _, _ := net.SplitHostPort(r.connection.RemoteAddr().String())
+		return replicaHost, r.listeningPort, true, acked
+	}
+
+	return "", "", false, acked
+}
+
+// endFailover resumes writes once the failover completed or failed.
+func (s *Server) endFailover() {
+	s.failover.mu.Lock()
+	s.failover.state = failoverNone
+	s.failover.abort = nil
+	s.failover.mu.Unlock()
+
+	s.pause.unpause(pauseByFailover)
+}
+
+// abortFailover stops a failover in progress, turning this server back into a
+// master if it already started following the new one.
+func (s *Server) abortFailover() bool {
+	s.failover.switchMu.Lock()
+	defer s.failover.switchMu.Unlock()
+
+	s.failover.mu.Lock()
+	state := s.failover.state
+	if state == failoverNone {
+		s.failover.mu.Unlock()
+		return false
+	}
+	close(s.failover.abort)
+	s.failover.abort = nil
+	s.failover.state = failoverNone
+	s.failover.mu.Unlock()
+
+	if state == failoverInProgress {
+		s.replicaOf("", "")
+	}
+
+	s.logger.Println("Failover: aborted")
+	s.pause.unpause(pauseByFailover)
+	return true
+}
+
+// failoverHandshakeFailed reverts a failover whose target did not accept the promotion.
+func (s *Server) failoverHandshakeFailed() {
+	s.failover.switchMu.Lock()
+	defer s.failover.switchMu.Unlock()
+
+	if s.failoverState() != failoverInProgress {
+		return
+	}
+
+	s.logger.Println("Failover: the target did not take over, resuming as master")
+	s.replicaOf("", "")
+	s.endFailover()
+}
+
+// resolveHost returns the addresses of host, nil if it is empty or cannot be resolved.
+func resolveHost(host string) []net.IP {
+	if host == "" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}
+	}
+
+	addresses, err := net.LookupHost(host)
+	if err != nil {
+		return nil
+	}
+	ips := []net.IP{}
+	for _, address := range addresses {
+		if ip := net.ParseIP(address); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+
+	return ips
+}
+
+// matches reports whether the replica listens on port at one of the addresses.
+// An empty port matches every replica.
+func (r *replica) matches(ips []net.IP, port string) bool {
+	if port == "" {
+		return true
+	}
+	if port != r.listeningPort {
+		return false
+	}
+
+	replicaHost, _, _ := net.SplitHostPort(r.connection.RemoteAddr().String())
+	replicaIP := net.ParseIP(replicaHost)
+	for _, ip := range ips {
+		if ip.Equal(replicaIP) {
+			return true
+		}
+	}
+
+	return false
+}
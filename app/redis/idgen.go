@@ -0,0 +1,157 @@
+package redis
+
+import (
+	"sync"
+	"time"
+)
+
+// IDs produced by IDGEN.NEXT follow the Snowflake layout:
+// 41 bits of milliseconds since idgenEpoch, 10 bits of node ID and 12 bits of sequence.
+const (
+	idgenNodeBits     = 10
+	idgenSequenceBits = 12
+
+	IDGenMaxNode     = 1<<idgenNodeBits - 1
+	idgenMaxSequence = 1<<idgenSequenceBits - 1
+
+	// IDGenMaxCount bounds how many IDs a single IDGEN.NEXT returns.
+	IDGenMaxCount = 10000
+)
+
+var idgenEpoch = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+type idgenKind string
+
+const (
+	idgenSnowflake idgenKind = "next"
+	idgenSequence  idgenKind = "seq"
+)
+
+type idgenKey struct {
+	kind idgenKind
+	name string
+}
+
+// IDGenerator hands out IDs that never go backwards for a given name.
+// The last issued value is the whole state, so replicating it with Advance
+// is enough for a promoted replica to carry on where the master stopped,
+// even if its clock lags behind.
+type IDGenerator struct {
+	mu    sync.Mutex
+	node  int64
+	last  map[idgenKey]int64
+	nower Nower
+}
+
+func NewIDGenerator(node int, opts ...func(*IDGenerator)) *IDGenerator {
+	generator := &IDGenerator{
+		node:  int64(node) & IDGenMaxNode,
+		last:  map[idgenKey]int64{},
+		nower: time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(generator)
+	}
+
+	return generator
+}
+
+func WithIDGenNower(nower Nower) func(*IDGenerator) {
+	return func(g *IDGenerator) {
+		g.nower = nower
+	}
+}
+
+// Next returns count time-ordered IDs for the given name.
+func (g *IDGenerator) Next(name string, count int) []int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	key := idgenKey{kind: idgenSnowflake, name: name}
+	ids := make([]int64, count)
+	for i := range ids {
+		ids[i] = g.nextSnowflake(g.last[key])
+		g.last[key] = ids[i]
+	}
+
+	return ids
+}
+
+func (g *IDGenerator) nextSnowflake(last int64) int64 {
+	lastMs := last >> (idgenNodeBits + idgenSequenceBits)
+	lastNode := (last >> idgenSequenceBits) & IDGenMaxNode
+	lastSequence := last & idgenMaxSequence
+
+	nowMs := g.nower().Sub(idgenEpoch).Milliseconds()
+	if nowMs > lastMs {
+		return composeSnowflake(nowMs, g.node, 0)
+	}
+
+	// The clock did not move forward (or is behind the previous owner of this name).
+	// Keep counting from the last issued timestamp instead.
+	if lastNode == g.node && lastSequence < idgenMaxSequence {
+		return composeSnowflake(lastMs, g.node, lastSequence+1)
+	}
+
+	candidate := composeSnowflake(lastMs, g.node, 0)
+	if candidate > last {
+		return candidate
+	}
+
+	return composeSnowflake(lastMs+1, g.node, 0)
+}
+
+func composeSnowflake(ms int64, node int64, sequence int64) int64 {
+	return ms<<(idgenNodeBits+idgenSequenceBits) | node<<idgenSequenceBits | sequence
+}
+
+// Seq returns the next value of a gapless integer sequence, starting at 1.
+func (g *IDGenerator) Seq(name string) int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	key := idgenKey{kind: idgenSequence, name: name}
+	g.last[key] = g.last[key] + 1
+
+	return g.last[key]
+}
+
+// AdvanceNext moves the IDGEN.NEXT state of name forward to value. It never moves it backwards.
+func (g *IDGenerator) AdvanceNext(name string, value int64) {
+	g.advance(idgenSnowflake, name, value)
+}
+
+// AdvanceSeq moves the IDGEN.SEQ state of name forward to value. It never moves it backwards.
+func (g *IDGenerator) AdvanceSeq(name string, value int64) {
+	g.advance(idgenSequence, name, value)
+}
+
+func (g *IDGenerator) advance(kind idgenKind, name string, value int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	key := idgenKey{kind: kind, name: name}
+	if value > g.last[key] {
+		g.last[key] = value
+	}
+}
+
+// State returns the last issued values, keyed by name, of IDGEN.NEXT and IDGEN.SEQ respectively.
+func (g *IDGenerator) State() (map[string]int64, map[string]int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	next := map[string]int64{}
+	seq := map[string]int64{}
+	for key, last := range g.last {
+		switch key.kind {
+		case idgenSnowflake:
+			next[key.name] = last
+		case idgenSequence:
+			seq[key.name] = last
+		}
+	}
+
+	return next, seq
+}
@@ -0,0 +1,55 @@
+package redis_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIDGenerator(t *testing.T) {
+	t.Run("ids are increasing when the clock stands still", func(t *testing.T) {
+		now := time.Date(2025, time.March, 1, 0, 0, 0, 0, time.UTC)
+		generator := redis.NewIDGenerator(1, redis.WithIDGenNower(func() time.Time { return now }))
+
+		ids := generator.Next("orders", 5000)
+		for i := 1; i < len(ids); i++ {
+			assert.Greater(t, ids[i], ids[i-1])
+		}
+	})
+
+	t.Run("ids are increasing when the clock goes backwards", func(t *testing.T) {
+		now := time.Date(2025, time.March, 1, 0, 0, 0, 0, time.UTC)
+		generator := redis.NewIDGenerator(1, redis.WithIDGenNower(func() time.Time { return now }))
+
+		first := generator.Next("orders", 1)[0]
+		now = now.Add(-time.Hour)
+		second := generator.Next("orders", 1)[0]
+
+		assert.Greater(t, second, first)
+	})
+
+	t.Run("promoted node continues after the replicated state", func(t *testing.T) {
+		now := time.Date(2025, time.March, 1, 0, 0, 0, 0, time.UTC)
+		master := redis.NewIDGenerator(900, redis.WithIDGenNower(func() time.Time { return now }))
+		last := master.Next("orders", 3)[2]
+
+		behind := now.Add(-time.Minute)
+		replica := redis.NewIDGenerator(2, redis.WithIDGenNower(func() time.Time { return behind }))
+		replica.AdvanceNext("orders", last)
+
+		assert.Greater(t, replica.Next("orders", 1)[0], last)
+	})
+
+	t.Run("sequences are gapless and follow the replicated state", func(t *testing.T) {
+		generator := redis.NewIDGenerator(0)
+
+		assert.Equal(t, int64(1), generator.Seq("invoices"))
+		assert.Equal(t, int64(2), generator.Seq("invoices"))
+
+		generator.AdvanceSeq("invoices", 10)
+		generator.AdvanceSeq("invoices", 5)
+		assert.Equal(t, int64(11), generator.Seq("invoices"))
+	})
+}
@@ -0,0 +1,504 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"math/bits"
+	"net"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	// RedisVersion is the version of Redis the server reports being compatible with.
+	RedisVersion = "7.2.0"
+
+	// opsSamples is how many samples of the operations per second are averaged, one taken every opsSampleInterval.
+	opsSamples        = 16
+	opsSampleInterval = 100 * time.Millisecond
+
+	// keyspaceTTLSamples is how many keys with an expiry of each database are sampled to estimate their average TTL.
+	keyspaceTTLSamples = 20
+)
+
+// serverStats are the statistics INFO reports about connections and commands.
+// The ones about the dataset are kept by the client.
+type serverStats struct {
+	connectionsReceived atomic.Int64
+	// rejectedConnections counts the connections refused for maxclients.
+	rejectedConnections atomic.Int64
+	blockedClients      atomic.Int64
+	commandsProcessed   atomic.Int64
+	netInputBytes       atomic.Int64
+	netOutputBytes      atomic.Int64
+	// outputBufferLimitDisconnections counts the clients disconnected for exceeding their output buffer limits.
+	outputBufferLimitDisconnections atomic.Int64
+
+	mu       sync.Mutex
+	commands map[CommandType]*commandStats
+	// errors counts the error replies by their prefix, such as ERR or WRONGTYPE.
+	errors       map[string]int64
+	errorReplies int64
+	ops          instantaneousMetric
+}
+
+type commandStats struct {
+	calls    int64
+	duration time.Duration
+	// rejected counts the calls refused before running, failed the ones that ran and replied with an error.
+	rejected int64
+	failed   int64
+	latency  latencyHistogram
+}
+
+// recordCall records a command that was served in duration. errorReply is the error it replied with,
+// if any, and rejected is set if the command was refused before running.
+func (st *serverStats) recordCall(cmd Command, duration time.Duration, errorReply string, rejected bool) {
+	st.commandsProcessed.Add(1)
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.commands == nil {
+		st.commands = map[CommandType]*commandStats{}
+		st.errors = map[string]int64{}
+	}
+	stats, found := st.commands[cmd.Type]
+	if !found {
+		stats = &commandStats{}
+		st.commands[cmd.Type] = stats
+	}
+
+	stats.calls++
+	stats.duration += duration
+	stats.latency.record(duration)
+	if errorReply == "" {
+		return
+	}
+
+	// Commands checking their own arguments only reject them once running.
+	if rejected || strings.HasPrefix(errorReply, "ERR wrong number of arguments") {
+		stats.rejected++
+	} else {
+		stats.failed++
+	}
+	prefix, _, _ := strings.Cut(errorReply, " ")
+	st.errors[prefix]++
+	st.errorReplies++
+}
+
+// sample records the operations per second since the previous sample.
+func (st *serverStats) sample(now time.Time) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.ops.sample(st.commandsProcessed.Load(), now)
+}
+
+func (st *serverStats) reset() {
+	st.connectionsReceived.Store(0)
+	st.rejectedConnections.Store(0)
+	st.commandsProcessed.Store(0)
+	st.netInputBytes.Store(0)
+	st.netOutputBytes.Store(0)
+	st.outputBufferLimitDisconnections.Store(0)
+
+	st.mu.Lock()
+	st.commands = nil
+	st.errors = nil
+	st.errorReplies = 0
+	st.ops = instantaneousMetric{}
+	st.mu.Unlock()
+}
+
+// instantaneousMetric averages the rate of a counter over its last samples.
+type instantaneousMetric struct {
+	samples   [opsSamples]int64
+	index     int
+	lastCount int64
+	lastTime  time.Time
+}
+
+func (m *instantaneousMetric) sample(count int64, now time.Time) {
+	if !m.lastTime.IsZero() {
+		if elapsed := now.Sub(m.lastTime); elapsed > 0 {
+			m.samples[m.index] = (count - m.lastCount) * int64(time.Second) / int64(elapsed)
+			m.index = (m.index + 1) % opsSamples
+		}
+	}
+
+	m.lastCount, m.lastTime = count, now
+}
+
+func (m *instantaneousMetric) value() int64 {
+	sum := int64(0)
+	for _, sample := range m.samples {
+		sum += sample
+	}
+
+	return sum / opsSamples
+}
+
+// latencyHistogram counts durations in microseconds, in buckets that split every power of two in
+// four, so that percentiles are reported within 25% of the actual durations.
+type latencyHistogram struct {
+	buckets [4 + 62*4]int64
+	count   int64
+}
+
+func (h *latencyHistogram) record(duration time.Duration) {
+	h.buckets[latencyBucket(max(duration.Microseconds(), 1))]++
+	h.count++
+}
+
+// percentile returns the upper bound of the bucket holding the percentile p, in microseconds.
+func (h *latencyHistogram) percentile(p float64) float64 {
+	target := int64(float64(h.count)*p/100 + 0.5)
+	seen := int64(0)
+	for bucket, count := range h.buckets {
+		seen += count
+		if count > 0 && seen >= max(target, 1) {
+			return float64(latencyBucketMax(bucket))
+		}
+	}
+
+	return 0
+}
+
+func latencyBucket(usec int64) int {
+	if usec < 4 {
+		return int(usec)
+	}
+
+	shift := bits.Len64(uint64(usec)) - 3
+	return shift*4 + int(usec>>shift)
+}
+
+func latencyBucketMax(bucket int) int64 {
+	if bucket < 4 {
+		return int64(bucket)
+	}
+
+	shift := (bucket - 4) / 4
+	return int64(bucket-shift*4+1)<<shift - 1
+}
+
+// replyRecorder counts the bytes written to a connection and notes the first error replied.
+// It may outlive the command, when it is the connection a replica is fed through.
+type replyRecorder struct {
+	net.Conn
+	stats *serverStats
+
+	mu    sync.Mutex
+	error string
+}
+
+func (r *replyRecorder) Write(data []byte) (int, error) {
+	if len(data) > 0 && data[0] == byte(rError) {
+		line, _, _ := strings.Cut(string(data[1:]), "\r\n")
+		r.mu.Lock()
+		if r.error == "" {
+			r.error = line
+		}
+		r.mu.Unlock()
+	}
+
+	n, err := r.Conn.Write(data)
+	r.stats.netOutputBytes.Add(int64(n))
+	return n, err
+}
+
+func (r *replyRecorder) errorReply() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.error
+}
+
+// infoSection is a section of INFO. The default sections are the ones INFO returns without arguments.
+type infoSection struct {
+	name      string
+	isDefault bool
+	lines     func() []string
+}
+
+// info formats the INFO sections named in args: the default sections if none is, or
+// every section for all and everything. Unknown sections are ignored.
+func (s *Server) info(args []string) string {
+	sections := []infoSection{
+		{name: "Server", isDefault: true, lines: s.serverInfoSection},
+		{name: "Clients", isDefault: true, lines: s.clientsInfoSection},
+		{name: "Memory", isDefault: true, lines: s.memoryInfoSection},
+		{name: "Persistence", isDefault: true, lines: s.persistenceInfoSection},
+		{name: "Stats", isDefault: true, lines: s.statsInfoSection},
+		{name: "Replication", isDefault: true, lines: s.replicationInfoSection},
+		{name: "CPU", isDefault: true, lines: cpuInfoSection},
+		{name: "Commandstats", lines: s.commandstatsInfoSection},
+		{name: "Errorstats", isDefault: true, lines: s.errorstatsInfoSection},
+		{name: "Latencystats", lines: s.latencystatsInfoSection},
+		{name: "Cluster", isDefault: true, lines: s.clusterInfoSection},
+		{name: "Keyspace", isDefault: true, lines: s.keyspaceInfoSection},
+	}
+
+	defaults, all := len(args) == 0, false
+	requested := map[string]bool{}
+	for _, arg := range args {
+		switch name := strings.ToLower(arg); name {
+		case "default":
+			defaults = true
+		case "all", "everything":
+			all = true
+		default:
+			requested[name] = true
+		}
+	}
+
+	var info strings.Builder
+	for _, section := range sections {
+		if !all && !(defaults && section.isDefault) && !requested[strings.ToLower(section.name)] {
+			continue
+		}
+
+		if info.Len() > 0 {
+			info.WriteString("\r\n")
+		}
+		info.WriteString("# " + section.name + "\r\n")
+		for _, line := range section.lines() {
+			info.WriteString(line + "\r\n")
+		}
+	}
+
+	return info.String()
+}
+
+func (s *Server) serverInfoSection() []string {
+	mode := "standalone"
+	if s.cluster.Enabled {
+		mode = "cluster"
+	}
+	executable, _ := os.Executable()
+	now := time.Now()
+	uptime := now.Sub(s.startedAt)
+
+	return []string{
+		"redis_version:" + RedisVersion,
+		"redis_mode:" + mode,
+		fmt.Sprintf("os:%s %s", runtime.GOOS, runtime.GOARCH),
+		fmt.Sprintf("arch_bits:%d", strconv.IntSize),
+		"go_version:" + runtime.Version(),
+		fmt.Sprintf("process_id:%d", os.Getpid()),
+		"run_id:" + s.runID,
+		"tcp_port:" + s.Port,
+		fmt.Sprintf("server_time_usec:%d", now.UnixMicro()),
+		fmt.Sprintf("uptime_in_seconds:%d", int64(uptime.Seconds())),
+		fmt.Sprintf("uptime_in_days:%d", int64(uptime.Hours()/24)),
+		fmt.Sprintf("hz:%d", time.Second/activeExpireInterval),
+		fmt.Sprintf("lru_clock:%d", lruClock(now)),
+		"executable:" + executable,
+		"config_file:" + s.config.File(),
+	}
+}
+
+func (s *Server) clientsInfoSection() []string {
+	s.execMu.Lock()
+	replicas := len(s.replicas)
+	s.execMu.Unlock()
+
+	return []string{
+		fmt.Sprintf("connected_clients:%d", s.sessions.count()-replicas),
+		fmt.Sprintf("maxclients:%d", s.sessions.limit()),
+		fmt.Sprintf("blocked_clients:%d", s.stats.blockedClients.Load()),
+	}
+}
+
+func (s *Server) memoryInfoSection() []string {
+	used := s.client.UsedMemory()
+
+	s.client.mu.RLock()
+	eviction := s.client.eviction
+	s.client.mu.RUnlock()
+
+	return []string{
+		fmt.Sprintf("used_memory:%d", used),
+		"used_memory_human:" + humanMemory(used),
+		fmt.Sprintf("maxmemory:%d", eviction.maxMemory),
+		"maxmemory_human:" + humanMemory(eviction.maxMemory),
+		fmt.Sprintf("maxmemory_policy:%s", eviction.policy),
+	}
+}
+
+func (s *Server) persistenceInfoSection() []string {
+	s.rdb.mu.Lock()
+	saving, lastSave, lastDirty, lastFailed := s.rdb.saving, s.rdb.lastSave, s.rdb.lastDirty, s.rdb.lastFailed
+	s.rdb.mu.Unlock()
+
+	s.aof.mu.Lock()
+	enabled, rewriting, writeErr := s.aof.Enabled, s.aof.rewriting, s.aof.writeErr
+	s.aof.mu.Unlock()
+
+	saveStatus := "ok"
+	if lastFailed.After(lastSave) {
+		saveStatus = "err"
+	}
+	writeStatus := "ok"
+	if writeErr != nil {
+		writeStatus = "err"
+	}
+
+	return []string{
+		// The dataset is loaded before the server accepts connections.
+		"loading:0",
+		fmt.Sprintf("rdb_changes_since_last_save:%d", s.client.Dirty()-lastDirty),
+		fmt.Sprintf("rdb_bgsave_in_progress:%d", boolInfo(saving)),
+		fmt.Sprintf("rdb_last_save_time:%d", lastSave.Unix()),
+		"rdb_last_bgsave_status:" + saveStatus,
+		fmt.Sprintf("aof_enabled:%d", boolInfo(enabled)),
+		fmt.Sprintf("aof_rewrite_in_progress:%d", boolInfo(rewriting)),
+		"aof_last_write_status:" + writeStatus,
+	}
+}
+
+func (s *Server) statsInfoSection() []string {
+	clientStats := s.client.Stats()
+
+	s.stats.mu.Lock()
+	ops := s.stats.ops.value()
+	errorReplies := s.stats.errorReplies
+	s.stats.mu.Unlock()
+
+	return []string{
+		fmt.Sprintf("total_connections_received:%d", s.stats.connectionsReceived.Load()),
+		fmt.Sprintf("rejected_connections:%d", s.stats.rejectedConnections.Load()),
+		fmt.Sprintf("total_commands_processed:%d", s.stats.commandsProcessed.Load()),
+		fmt.Sprintf("instantaneous_ops_per_sec:%d", ops),
+		fmt.Sprintf("total_net_input_bytes:%d", s.stats.netInputBytes.Load()),
+		fmt.Sprintf("total_net_output_bytes:%d", s.stats.netOutputBytes.Load()),
+		fmt.Sprintf("client_output_buffer_limit_disconnections:%d", s.stats.outputBufferLimitDisconnections.Load()),
+		fmt.Sprintf("expired_keys:%d", clientStats.ExpiredKeys),
+		fmt.Sprintf("evicted_keys:%d", clientStats.EvictedKeys),
+		fmt.Sprintf("keyspace_hits:%d", clientStats.KeyspaceHits),
+		fmt.Sprintf("keyspace_misses:%d", clientStats.KeyspaceMisses),
+		fmt.Sprintf("total_error_replies:%d", errorReplies),
+	}
+}
+
+func cpuInfoSection() []string {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return nil
+	}
+
+	return []string{
+		fmt.Sprintf("used_cpu_sys:%.6f", time.Duration(usage.Stime.Nano()).Seconds()),
+		fmt.Sprintf("used_cpu_user:%.6f", time.Duration(usage.Utime.Nano()).Seconds()),
+	}
+}
+
+func (s *Server) commandstatsInfoSection() []string {
+	s.stats.mu.Lock()
+	defer s.stats.mu.Unlock()
+
+	lines := []string{}
+	for _, name := range sortedKeys(s.stats.commands) {
+		stats := s.stats.commands[name]
+		usec := stats.duration.Microseconds()
+		lines = append(lines, fmt.Sprintf("cmdstat_%s:calls=%d,usec=%d,usec_per_call=%.2f,rejected_calls=%d,failed_calls=%d",
+			name, stats.calls, usec, float64(usec)/float64(stats.calls), stats.rejected, stats.failed))
+	}
+
+	return lines
+}
+
+func (s *Server) errorstatsInfoSection() []string {
+	s.stats.mu.Lock()
+	defer s.stats.mu.Unlock()
+
+	lines := []string{}
+	for _, prefix := range sortedKeys(s.stats.errors) {
+		lines = append(lines, fmt.Sprintf("errorstat_%s:count=%d", prefix, s.stats.errors[prefix]))
+	}
+
+	return lines
+}
+
+func (s *Server) latencystatsInfoSection() []string {
+	s.stats.mu.Lock()
+	defer s.stats.mu.Unlock()
+
+	lines := []string{}
+	for _, name := range sortedKeys(s.stats.commands) {
+		latency := &s.stats.commands[name].latency
+		lines = append(lines, fmt.Sprintf("latency_percentiles_usec_%s:p50=%.3f,p99=%.3f,p99.9=%.3f",
+			name, latency.percentile(50), latency.percentile(99), latency.percentile(99.9)))
+	}
+
+	return lines
+}
+
+func (s *Server) clusterInfoSection() []string {
+	return []string{fmt.Sprintf("cluster_enabled:%d", boolInfo(s.cluster.Enabled))}
+}
+
+func (s *Server) keyspaceInfoSection() []string {
+	now := s.client.nower()
+	lines := []string{}
+	for index := range s.client.Databases() {
+		db := s.client.db(index)
+		keys := db.Len()
+		if keys == 0 {
+			continue
+		}
+
+		avgTTL := int64(0)
+		if sampled := db.Sample(keyspaceTTLSamples, true); len(sampled) > 0 {
+			for _, stats := range sampled {
+				avgTTL += max(stats.ExpiresAt.Sub(now).Milliseconds(), 0)
+			}
+			avgTTL /= int64(len(sampled))
+		}
+
+		lines = append(lines, fmt.Sprintf("db%d:keys=%d,expires=%d,avg_ttl=%d", index, keys, db.ExpiresLen(), avgTTL))
+	}
+
+	return lines
+}
+
+// statsLoop samples the operations per second.
+func (s *Server) statsLoop(ctx context.Context) {
+	ticker := time.NewTicker(opsSampleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.stats.sample(now)
+		}
+	}
+}
+
+func boolInfo(value bool) int {
+	if value {
+		return 1
+	}
+
+	return 0
+}
+
+func sortedKeys[K ~string, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	return keys
+}
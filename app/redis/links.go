@@ -0,0 +1,81 @@
+package redis
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// links are cached connections to other instances, by address. They are not
+// safe for concurrent use, each cron goroutine owns its links.
+type links struct {
+	timeout     time.Duration
+	connections map[string]*link
+}
+
+type link struct {
+	connection net.Conn
+	resp       *Resp
+}
+
+func newLinks(timeout time.Duration) *links {
+	return &links{timeout: timeout, connections: map[string]*link{}}
+}
+
+// query sends a command and reads its reply, reconnecting if needed.
+// Error replies are returned as errors.
+func (l *links) query(address string, args ...string) (Value, error) {
+	link, err := l.connect(address)
+	if err != nil {
+		return Value{}, err
+	}
+
+	link.connection.SetDeadline(time.Now().Add(l.timeout))
+	err = NewCommandFromArgs(args...).Write(link.connection)
+	var reply Value
+	if err == nil {
+		reply, err = link.resp.Read()
+	}
+	if err != nil {
+		l.close(address)
+		return Value{}, err
+	}
+	if reply.Type == Error {
+		return reply, errors.New(reply.Error)
+	}
+
+	return reply, nil
+}
+
+func (l *links) connect(address string) (*link, error) {
+	if found, ok := l.connections[address]; ok {
+		return found, nil
+	}
+
+	connection, err := net.DialTimeout(protocol, address, l.timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	connected := &link{connection: connection, resp: NewResp(connection)}
+	l.connections[address] = connected
+	return connected, nil
+}
+
+func (l *links) close(address string) {
+	if found, ok := l.connections[address]; ok {
+		found.connection.Close()
+		delete(l.connections, address)
+	}
+}
+
+// localHost returns the local address of the connection to the address, empty if there is none.
+func (l *links) localHost(address string) string {
+	found, ok := l.connections[address]
+	if !ok {
+		return ""
+	}
+
+	host, _, _ := net.SplitHostPort(found.connection.LocalAddr().String())
+	return host
+}
@@ -0,0 +1,302 @@
+package redis
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// migrateLinkIdle is how long a connection cached by MIGRATE may stay unused before it is closed.
+	migrateLinkIdle = 10 * time.Second
+	// migrateDefaultTimeout replaces a MIGRATE timeout that is not positive.
+	migrateDefaultTimeout = time.Second
+)
+
+var errMigrateBusyKey = errors.New("BUSYKEY Target key name already exists.")
+
+// migrateLink is a connection to a MIGRATE target, kept open for the next transfers.
+type migrateLink struct {
+	connection net.Conn
+	resp       *Resp
+	// db is the database last selected on the target, -1 if none was.
+	db      int
+	lastUse time.Time
+}
+
+// migrateKeys returns the key of MIGRATE, or the keys following its KEYS option.
+func migrateKeys(args []string) []string {
+	if len(args) < 5 {
+		return nil
+	}
+	if args[2] != "" {
+		return []string{args[2]}
+	}
+
+	for i := 5; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "AUTH":
+			i++
+		case "AUTH2":
+			i += 2
+		case "KEYS":
+			return args[i+1:]
+		}
+	}
+
+	return nil
+}
+
+// migrate implements MIGRATE host port key|"" destination-db timeout [COPY] [REPLACE] [AUTH password]
+// [AUTH2 username password] [KEYS key...]. No other command runs until the keys were written to the
+// target and, unless COPY is set, deleted here.
+func (s *Server) migrate(session *Session, cmd Command) Value {
+	if len(cmd.Args) < 5 {
+		return wrongArgumentsError(cmd)
+	}
+
+	address := net.JoinHostPort(cmd.Args[0], cmd.Args[1])
+	db, dbErr := strconv.Atoi(cmd.Args[3])
+	timeoutMs, timeoutErr := strconv.Atoi(cmd.Args[4])
+	if dbErr != nil || timeoutErr != nil {
+		return errorValue("ERR value is not an integer or out of range")
+	}
+	timeout := time.Duration(timeoutMs) * time.Millisecond
+	if timeout <= 0 {
+		timeout = migrateDefaultTimeout
+	}
+
+	var copyKeys, replace bool
+	var auth []string
+	keys := []string{cmd.Args[2]}
+	for i := 5; i < len(cmd.Args); i++ {
+		switch strings.ToUpper(cmd.Args[i]) {
+		case "COPY":
+			copyKeys = true
+		case "REPLACE":
+			replace = true
+		case "AUTH":
+			if i+1 >= len(cmd.Args) {
+				return errorValue("ERR syntax error")
+			}
+			auth = []string{"AUTH", cmd.Args[i+1]}
+			i++
+		case "AUTH2":
+			if i+2 >= len(cmd.Args) {
+				return errorValue("ERR syntax error")
+			}
+			auth = []string{"AUTH", cmd.Args[i+1], cmd.Args[i+2]}
+			i += 2
+		case "KEYS":
+			if cmd.Args[2] != "" {
+				return errorValue("ERR When using MIGRATE KEYS option, the key argument must be set to the empty string")
+			}
+			keys = cmd.Args[i+1:]
+			i = len(cmd.Args)
+		default:
+			return errorValue("ERR syntax error")
+		}
+	}
+
+	s.execMu.Lock()
+	defer s.execMu.Unlock()
+
+	store := s.client.db(session.db)
+	commands := [][]string{}
+	present := []string{}
+	for _, key := range keys {
+		entry, found := store.GetEntry(key)
+		if !found {
+			continue
+		}
+
+		// The remaining TTL is sent rather than the expiry time, so that clocks do not need to agree.
+		set := []string{string(Set), key, entry.Value}
+		if entry.ExpiresAt != nil {
+			set = append(set, "PX", strconv.FormatInt(max(entry.ExpiresAt.Sub(s.client.nower()).Milliseconds(), 1), 10))
+		}
+		if !replace {
+			set = append(set, "NX")
+		}
+		if s.cluster.Enabled {
+			// The target serves the keys of a slot it is importing only when asked to.
+			commands = append(commands, []string{string(Asking)})
+		}
+		commands = append(commands, set)
+		present = append(present, key)
+	}
+	if len(present) == 0 {
+		return Value{Type: SimpleString, SimpleString: "NOKEY"}
+	}
+
+	written, err := s.migrateTransfer(address, db, timeout, auth, commands)
+	if !copyKeys && len(written) > 0 {
+		moved := []string{}
+		for i, key := range present {
+			if written[i] {
+				moved = append(moved, key)
+			}
+		}
+
+		_, delErr := s.client.Handle(session, NewCommandFromArgs(append([]string{string(Del)}, moved...)...))
+		if delErr == nil {
+			delErr = s.replicate(session)
+		}
+		if delErr != nil {
+			s.logger.Println("Failed to delete migrated keys:", delErr)
+		}
+	}
+	if err != nil {
+		return errorValue("%s", err.Error())
+	}
+
+	return Value{Type: SimpleString, SimpleString: "OK"}
+}
+
+// migrateTransfer sends the commands, after selecting db, over the cached connection to the address.
+// It reports which of the SET commands were applied by the target. A stale cached connection is retried once.
+// It must be called with execMu held.
+func (s *Server) migrateTransfer(address string, db int, timeout time.Duration, auth []string, commands [][]string) ([]bool, error) {
+	for attempt := 0; ; attempt++ {
+		link, cached, err := s.migrateLinkTo(address, timeout)
+		if err != nil {
+			return nil, errors.New("IOERR error or timeout connecting to the client")
+		}
+
+		written, err := link.transfer(db, timeout, auth, commands)
+		var ioErr *migrateIOError
+		if errors.As(err, &ioErr) {
+			s.closeMigrateLink(address)
+			if cached && attempt == 0 {
+				continue
+			}
+			return nil, errors.New("IOERR error or timeout reading to target instance")
+		}
+
+		return written, err
+	}
+}
+
+// migrateIOError reports a failure to talk to the target, as opposed to an error it replied with.
+type migrateIOError struct {
+	err error
+}
+
+func (e *migrateIOError) Error() string {
+	return e.err.Error()
+}
+
+func (l *migrateLink) transfer(db int, timeout time.Duration, auth []string, commands [][]string) ([]bool, error) {
+	l.lastUse = time.Now()
+	l.connection.SetDeadline(time.Now().Add(timeout))
+
+	// The keys are only sent once the target selected db, so that they are never written to another one.
+	setup := [][]string{}
+	if auth != nil {
+		setup = append(setup, auth)
+	}
+	if l.db != db {
+		setup = append(setup, []string{string(Select), strconv.Itoa(db)})
+	}
+	if len(setup) > 0 {
+		if _, err := l.send(db, setup); err != nil {
+			return nil, err
+		}
+	}
+
+	return l.send(db, commands)
+}
+
+// send pipelines the commands and reports which of the SET commands were applied.
+func (l *migrateLink) send(db int, pipeline [][]string) ([]bool, error) {
+	var buffer bytes.Buffer
+	for _, args := range pipeline {
+		NewCommandFromArgs(args...).Write(&buffer)
+	}
+	if _, err := l.connection.Write(buffer.Bytes()); err != nil {
+		return nil, &migrateIOError{err: err}
+	}
+
+	// Every reply is read, even after an error, so that the connection can be reused.
+	var failure error
+	written := []bool{}
+	for _, args := range pipeline {
+		reply, err := l.resp.Read()
+		if err != nil {
+			return nil, &migrateIOError{err: err}
+		}
+
+		switch CommandType(strings.ToLower(args[0])) {
+		case Select:
+			if reply.Type == Error {
+				l.db = -1
+			} else {
+				l.db = db
+			}
+		case Set:
+			written = append(written, reply.Type != Error && reply.Type != NullBulk)
+			if reply.Type == NullBulk && failure == nil {
+				failure = errMigrateBusyKey
+			}
+		}
+
+		if reply.Type == Error && failure == nil {
+			failure = errors.New("ERR Target instance replied with error: " + reply.Error)
+		}
+	}
+
+	return written, failure
+}
+
+// migrateLinkTo returns the cached connection to the address, connecting if there is none.
+// It must be called with execMu held.
+func (s *Server) migrateLinkTo(address string, timeout time.Duration) (*migrateLink, bool, error) {
+	if link, found := s.migrateLinks[address]; found {
+		return link, true, nil
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, timeout)
+	defer cancel()
+
+	connection, err := s.connect(ctx, address)
+	if err != nil {
+		return nil, false, err
+	}
+
+	link := &migrateLink{connection: connection, resp: NewResp(connection), db: -1, lastUse: time.Now()}
+	s.migrateLinks[address] = link
+	return link, false, nil
+}
+
+// closeMigrateLink must be called with execMu held.
+func (s *Server) closeMigrateLink(address string) {
+	if link, found := s.migrateLinks[address]; found {
+		link.connection.Close()
+		delete(s.migrateLinks, address)
+	}
+}
+
+// migrateLinksLoop closes the connections MIGRATE did not use for a while.
+func (s *Server) migrateLinksLoop(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.execMu.Lock()
+			for address, link := range s.migrateLinks {
+				if time.Since(link.lastUse) > migrateLinkIdle {
+					s.closeMigrateLink(address)
+				}
+			}
+			s.execMu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package redis_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/codecrafters-io/redis-starter-go/app/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrate(t *testing.T) {
+	null := redis.Value{Type: redis.NullBulk}
+	bulk := func(s string) redis.Value { return redis.Value{Type: redis.Bulk, Bulk: s} }
+
+	tests := []struct {
+		name   string
+		source [][]string
+		target [][]string
+		// args follow MIGRATE host port.
+		args       []string
+		want       redis.Value
+		wantSource map[string]redis.Value
+		wantTarget map[string]redis.Value
+	}{
+		{
+			name:       "moves the key",
+			source:     [][]string{{"SET", "key", "value"}},
+			args:       []string{"key", "0", "1000"},
+			want:       okReply,
+			wantSource: map[string]redis.Value{"key": null},
+			wantTarget: map[string]redis.Value{"key": bulk("value")},
+		},
+		{
+			name:       "copies the key",
+			source:     [][]string{{"SET", "key", "value"}},
+			args:       []string{"key", "0", "1000", "COPY"},
+			want:       okReply,
+			wantSource: map[string]redis.Value{"key": bulk("value")},
+			wantTarget: map[string]redis.Value{"key": bulk("value")},
+		},
+		{
+			name:       "keeps an existing key",
+			source:     [][]string{{"SET", "key", "value"}},
+			target:     [][]string{{"SET", "key", "other"}},
+			args:       []string{"key", "0", "1000"},
+			want:       redis.Value{Type: redis.Error, Error: "BUSYKEY Target key name already exists."},
+			wantSource: map[string]redis.Value{"key": bulk("value")},
+			wantTarget: map[string]redis.Value{"key": bulk("other")},
+		},
+		{
+			name:       "replaces an existing key",
+			source:     [][]string{{"SET", "key", "value"}},
+			target:     [][]string{{"SET", "key", "other"}},
+			args:       []string{"key", "0", "1000", "REPLACE"},
+			want:       okReply,
+			wantSource: map[string]redis.Value{"key": null},
+			wantTarget: map[string]redis.Value{"key": bulk("value")},
+		},
+		{
+			name:       "moves several keys",
+			source:     [][]string{{"SET", "a", "1"}, {"SET", "b", "2"}},
+			args:       []string{"", "0", "1000", "KEYS", "a", "b", "missing"},
+			want:       okReply,
+			wantSource: map[string]redis.Value{"a": null, "b": null},
+			wantTarget: map[string]redis.Value{"a": bulk("1"), "b": bulk("2"), "missing": null},
+		},
+		{
+			name:       "into another database",
+			source:     [][]string{{"SET", "key", "value"}},
+			args:       []string{"key", "1", "1000"},
+			want:       okReply,
+			wantSource: map[string]redis.Value{"key": null},
+			wantTarget: map[string]redis.Value{"key": null},
+		},
+		{
+			name:       "into a missing database",
+			source:     [][]string{{"SET", "key", "value"}},
+			args:       []string{"key", "16", "1000"},
+			want:       redis.Value{Type: redis.Error, Error: "ERR Target instance replied with error: ERR DB index is out of range"},
+			wantSource: map[string]redis.Value{"key": bulk("value")},
+			wantTarget: map[string]redis.Value{"key": null},
+		},
+		{
+			name:       "missing key",
+			args:       []string{"key", "0", "1000"},
+			want:       redis.Value{Type: redis.SimpleString, SimpleString: "NOKEY"},
+			wantTarget: map[string]redis.Value{"key": null},
+		},
+		{
+			name: "key with KEYS",
+			args: []string{"key", "0", "1000", "KEYS", "a"},
+			want: redis.Value{Type: redis.Error, Error: "ERR When using MIGRATE KEYS option, the key argument must be set to the empty string"},
+		},
+		{
+			name: "invalid timeout",
+			args: []string{"key", "0", "soon"},
+			want: redis.Value{Type: redis.Error, Error: "ERR value is not an integer or out of range"},
+		},
+		{
+			name: "unknown option",
+			args: []string{"key", "0", "1000", "MOVE"},
+			want: redis.Value{Type: redis.Error, Error: "ERR syntax error"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := startServer(t)
+			target := startServer(t)
+			for _, args := range tt.source {
+				assert.Equal(t, okReply, source.do(args...))
+			}
+			for _, args := range tt.target {
+				assert.Equal(t, okReply, target.do(args...))
+			}
+
+			host, port, _ := net.SplitHostPort(target.address)
+			assert.Equal(t, tt.want, source.do(append([]string{"MIGRATE", host, port}, tt.args...)...))
+			for key, want := range tt.wantSource {
+				assert.Equal(t, want, source.do("GET", key), key)
+			}
+			for key, want := range tt.wantTarget {
+				assert.Equal(t, want, target.do("GET", key), key)
+			}
+		})
+	}
+}
+
+func TestMigrateTTL(t *testing.T) {
+	source := startServer(t)
+	target := startServer(t)
+	assert.Equal(t, okReply, source.do("SET", "key", "value", "EX", "100"))
+
+	host, port, _ := net.SplitHostPort(target.address)
+	assert.Equal(t, okReply, source.do("MIGRATE", host, port, "key", "0", "1000"))
+
+	ttl := target.do("TTL", "key")
+	assert.Equal(t, redis.Number, ttl.Type)
+	assert.InDelta(t, 100, ttl.Number, 1)
+}
+
+func TestMigrateUnreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	listener.Close()
+
+	source := startServer(t)
+	assert.Equal(t, okReply, source.do("SET", "key", "value"))
+
+	reply := source.do("MIGRATE", host, port, "key", "0", "100")
+	assert.Equal(t, redis.Value{Type: redis.Error, Error: "IOERR error or timeout connecting to the client"}, reply)
+	assert.Equal(t, redis.Value{Type: redis.Bulk, Bulk: "value"}, source.do("GET", "key"))
+}
@@ -0,0 +1,251 @@
+package redis
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Strings are stored as integers when they are canonical 64-bit integers,
+	// embedded in their object when short, and in a separate allocation otherwise.
+	encodingInt    = "int"
+	encodingEmbstr = "embstr"
+	encodingRaw    = "raw"
+
+	// embstrMaxLength is the longest string Redis embeds in its object.
+	embstrMaxLength = 44
+
+	// MEMORY DOCTOR samples that many keys of every database, and reports the ones bigger than memoryDoctorBigKey.
+	memoryDoctorSamples = 64
+	memoryDoctorBigKey  = 512 << 10
+)
+
+func stringEncoding(value string) string {
+	if len(value) <= 20 {
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil && strconv.FormatInt(n, 10) == value {
+			return encodingInt
+		}
+	}
+	if len(value) <= embstrMaxLength {
+		return encodingEmbstr
+	}
+
+	return encodingRaw
+}
+
+var objectHelp = []string{
+	"OBJECT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"ENCODING <key>",
+	"    Return the kind of internal representation used in order to store the value",
+	"    associated with a <key>.",
+	"FREQ <key>",
+	"    Return the access frequency index of the <key>. The returned integer is",
+	"    proportional to the logarithm of the recent access frequency of the key.",
+	"IDLETIME <key>",
+	"    Return the idle time of the <key>, that is the approximated number of",
+	"    seconds elapsed since the last access to the key.",
+	"REFCOUNT <key>",
+	"    Return the number of references of the value associated with the specified",
+	"    <key>.",
+	"HELP",
+	"    Print this help.",
+}
+
+// object implements OBJECT ENCODING|FREQ|IDLETIME|REFCOUNT key and OBJECT HELP.
+// Looking a key up this way does not count as an access. Like Redis, FREQ is
+// only answered under an LFU eviction policy, and IDLETIME under any other.
+func (c *Client) object(store Store, cmd Command) Value {
+	if len(cmd.Args) == 1 && strings.EqualFold(cmd.Args[0], "help") {
+		return bulkFields(objectHelp...)
+	}
+	if len(cmd.Args) != 2 {
+		return wrongArgumentsError(cmd)
+	}
+
+	subcommand := strings.ToLower(cmd.Args[0])
+	switch subcommand {
+	case "encoding", "freq", "idletime", "refcount":
+	default:
+		return errorValue("ERR unknown subcommand '%s'. Try OBJECT HELP.", cmd.Args[0])
+	}
+
+	stats, found := store.Stats(cmd.Args[1])
+	if !found {
+		return Value{Type: NullBulk}
+	}
+
+	c.mu.RLock()
+	lfu := c.eviction.policy.lfu()
+	c.mu.RUnlock()
+
+	switch subcommand {
+	case "encoding":
+		return Value{Type: Bulk, Bulk: stats.Encoding}
+	case "freq":
+		if !lfu {
+			return errorValue("ERR An LFU maxmemory policy is not selected, access frequency not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.")
+		}
+		return Value{Type: Number, Number: int(stats.Frequency)}
+	case "idletime":
+		if lfu {
+			return errorValue("ERR An LFU maxmemory policy is selected, idle time not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.")
+		}
+		return Value{Type: Number, Number: int(stats.Idle.Seconds())}
+	default:
+		// Values are never shared between keys.
+		return Value{Type: Number, Number: 1}
+	}
+}
+
+var memoryHelp = []string{
+	"MEMORY <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"DOCTOR",
+	"    Return memory problems reports.",
+	"STATS",
+	"    Return information about the memory usage of the server.",
+	"USAGE <key> [SAMPLES <count>]",
+	"    Return memory in bytes used by <key> and its value.",
+	"HELP",
+	"    Print this help.",
+}
+
+// memoryCommand implements MEMORY USAGE key [SAMPLES count], MEMORY STATS, MEMORY DOCTOR and MEMORY HELP.
+func (s *Server) memoryCommand(session *Session, cmd Command) Value {
+	if len(cmd.Args) == 0 {
+		return wrongArgumentsError(cmd)
+	}
+
+	switch strings.ToLower(cmd.Args[0]) {
+	case "usage":
+		if len(cmd.Args) != 2 && len(cmd.Args) != 4 {
+			return errorValue("ERR wrong number of arguments for 'memory|usage' command")
+		}
+		if len(cmd.Args) == 4 {
+			// Values are plain strings, there are no elements to sample.
+			if !strings.EqualFold(cmd.Args[2], "samples") {
+				return errorValue("ERR syntax error")
+			}
+			if samples, err := strconv.Atoi(cmd.Args[3]); err != nil || samples < 0 {
+				return errorValue("ERR value is not an integer or out of range")
+			}
+		}
+
+		stats, found := s.client.db(session.db).Stats(cmd.Args[1])
+		if !found {
+			return Value{Type: NullBulk}
+		}
+		return Value{Type: Number, Number: int(stats.Size)}
+
+	case "stats":
+		if len(cmd.Args) != 1 {
+			return errorValue("ERR wrong number of arguments for 'memory|stats' command")
+		}
+		return s.memoryStats()
+
+	case "doctor":
+		if len(cmd.Args) != 1 {
+			return errorValue("ERR wrong number of arguments for 'memory|doctor' command")
+		}
+		return Value{Type: Bulk, Bulk: s.memoryDoctor()}
+
+	case "help":
+		return bulkFields(memoryHelp...)
+	}
+
+	return errorValue("ERR unknown subcommand '%s'. Try MEMORY HELP.", cmd.Args[0])
+}
+
+func (s *Server) memoryStats() Value {
+	s.repl.mu.Lock()
+	backlog := len(s.repl.backlog.buf)
+	s.repl.mu.Unlock()
+
+	dataset := s.client.UsedMemory()
+	values := []Value{
+		{Type: Bulk, Bulk: "total.allocated"}, {Type: Number, Number: int(dataset) + backlog},
+		{Type: Bulk, Bulk: "replication.backlog"}, {Type: Number, Number: backlog},
+	}
+
+	keys := 0
+	for index := range s.client.Databases() {
+		db := s.client.db(index)
+		count := db.Len()
+		if count == 0 {
+			continue
+		}
+
+		keys += count
+		values = append(values,
+			Value{Type: Bulk, Bulk: fmt.Sprintf("db.%d", index)},
+			Value{Type: Array, Array: []Value{
+				{Type: Bulk, Bulk: "keys"}, {Type: Number, Number: count},
+				{Type: Bulk, Bulk: "expires"}, {Type: Number, Number: db.ExpiresLen()},
+			}},
+		)
+	}
+
+	bytesPerKey := 0
+	percentage := "0"
+	if keys > 0 {
+		bytesPerKey = int(dataset) / keys
+	}
+	if total := dataset + int64(backlog); total > 0 {
+		percentage = strconv.FormatFloat(float64(dataset)*100/float64(total), 'f', -1, 64)
+	}
+
+	values = append(values,
+		Value{Type: Bulk, Bulk: "keys.count"}, Value{Type: Number, Number: keys},
+		Value{Type: Bulk, Bulk: "keys.bytes-per-key"}, Value{Type: Number, Number: bytesPerKey},
+		Value{Type: Bulk, Bulk: "dataset.bytes"}, Value{Type: Number, Number: int(dataset)},
+		Value{Type: Bulk, Bulk: "dataset.percentage"}, Value{Type: Bulk, Bulk: percentage},
+	)
+
+	return Value{Type: Array, Array: values}
+}
+
+// memoryDoctor reports the memory issues it detects, in the friendly tone of Redis.
+func (s *Server) memoryDoctor() string {
+	keys := 0
+	for index := range s.client.Databases() {
+		keys += s.client.db(index).Len()
+	}
+	if keys == 0 {
+		return "Hi Sam, this instance is empty or is using very little memory, my issues detector can't be used in these conditions. " +
+			"Please, leave for your mission on Earth and fill it with some data. The new Sam and I will be back to our programming as soon as I finished rebooting."
+	}
+
+	s.client.mu.RLock()
+	eviction := s.client.eviction
+	s.client.mu.RUnlock()
+
+	used := s.client.UsedMemory()
+	issues := []string{}
+	if eviction.maxMemory > 0 && used*10 > eviction.maxMemory*9 {
+		issues = append(issues, fmt.Sprintf(" * High memory usage: the dataset uses %s of the %s maxmemory. "+
+			"Consider raising maxmemory, or setting expiries on keys that are not needed forever.",
+			humanMemory(used), humanMemory(eviction.maxMemory)))
+	}
+	if evicted := s.client.EvictedKeys(); evicted > 0 {
+		issues = append(issues, fmt.Sprintf(" * Evicted keys: %d keys were evicted by the %s policy to stay under maxmemory. "+
+			"Check that this is expected, evicted keys are lost.", evicted, eviction.policy))
+	}
+	for index := range s.client.Databases() {
+		for _, stats := range s.client.db(index).Sample(memoryDoctorSamples, false) {
+			if stats.Size > memoryDoctorBigKey {
+				issues = append(issues, fmt.Sprintf(" * Big key: %q in db %d takes %s. "+
+					"Big values are slow to read, replicate and evict, consider splitting them.", stats.Key, index, humanMemory(stats.Size)))
+			}
+		}
+	}
+	if eviction.maxMemory > 0 && eviction.policy == NoEviction && used > eviction.maxMemory {
+		issues = append(issues, " * Writes are rejected: the dataset does not fit in maxmemory and the noeviction policy does not free any memory.")
+	}
+
+	if len(issues) == 0 {
+		return "Hi Sam, I can't find any memory issue in your instance. I can only account for what occurs on this base."
+	}
+
+	return "Sam, I detected a few issues in this Redis instance memory implants:\n\n" + strings.Join(issues, "\n\n") +
+		"\n\nI'm here to keep you safe, Sam. I want to help you.\n"
+}
@@ -0,0 +1,327 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// outputDrainTimeout bounds how long the replies left to a closing connection are sent for.
+const outputDrainTimeout = 10 * time.Second
+
+// OutputBufferLimit bounds the replies queued for a client. The client is disconnected
+// once they exceed Hard, or once they stay above Soft for longer than SoftDuration.
+// A zero limit is disabled.
+type OutputBufferLimit struct {
+	Hard         int64
+	Soft         int64
+	SoftDuration time.Duration
+}
+
+// OutputBufferLimits are the limits of each class of clients. The connection
+// of a replica to its master is never limited.
+type OutputBufferLimits struct {
+	Normal  OutputBufferLimit
+	Replica OutputBufferLimit
+	PubSub  OutputBufferLimit
+}
+
+func DefaultOutputBufferLimits() OutputBufferLimits {
+	return OutputBufferLimits{
+		Replica: OutputBufferLimit{Hard: 256 << 20, Soft: 64 << 20, SoftDuration: 60 * time.Second},
+		PubSub:  OutputBufferLimit{Hard: 32 << 20, Soft: 8 << 20, SoftDuration: 60 * time.Second},
+	}
+}
+
+// class returns the limit of a client type, see parseClientType.
+func (l *OutputBufferLimits) class(clientType string) *OutputBufferLimit {
+	switch clientType {
+	case clientNormal:
+		return &l.Normal
+	case clientReplica:
+		return &l.Replica
+	case clientPubSub:
+		return &l.PubSub
+	}
+
+	return nil
+}
+
+// ParseOutputBufferLimits parses the "<class> <hard> <soft> <soft-seconds> ..." format of
+// the client-output-buffer-limit option, where the class is normal, replica or pubsub.
+// The classes it does not name keep their default limits.
+func ParseOutputBufferLimits(spec string) (OutputBufferLimits, error) {
+	limits := DefaultOutputBufferLimits()
+	fields := strings.Fields(spec)
+	if len(fields)%4 != 0 {
+		return limits, errors.New("wrong number of arguments in buffer limit configuration")
+	}
+
+	for i := 0; i < len(fields); i += 4 {
+		clientType, ok := parseClientType(fields[i])
+		if !ok || clientType == clientMaster {
+			return limits, errors.New("invalid client class specified in buffer limit configuration")
+		}
+
+		hard, hardErr := ParseMemory(fields[i+1])
+		soft, softErr := ParseMemory(fields[i+2])
+		seconds, secondsErr := strconv.ParseInt(fields[i+3], 10, 64)
+		if hardErr != nil || softErr != nil || secondsErr != nil || hard < 0 || soft < 0 || seconds < 0 {
+			return limits, errors.New("error in hard, soft or soft_seconds setting in buffer limit configuration")
+		}
+
+		*limits.class(clientType) = OutputBufferLimit{Hard: hard, Soft: soft, SoftDuration: time.Duration(seconds) * time.Second}
+	}
+
+	return limits, nil
+}
+
+// String formats the limits the way ParseOutputBufferLimits parses them.
+func (l OutputBufferLimits) String() string {
+	classes := []string{}
+	for _, clientType := range []string{clientNormal, clientReplica, clientPubSub} {
+		limit := l.class(clientType)
+		classes = append(classes, fmt.Sprintf("%s %d %d %d", clientType, limit.Hard, limit.Soft, int64(limit.SoftDuration.Seconds())))
+	}
+
+	return strings.Join(classes, " ")
+}
+
+// WithOutputBufferLimits disconnects the clients that do not read their replies fast enough.
+func WithOutputBufferLimits(limits OutputBufferLimits) func(*Server) {
+	return func(s *Server) {
+		s.sessions.outputLimits = limits
+	}
+}
+
+var errOutputBufferLimit = errors.New("output buffer limit reached")
+
+// outputBuffer queues what is written to a connection, and sends it from its own
+// goroutine, so that a client that does not read its replies, or a stalled
+// replica, does not hold up the server. The connection is closed as soon as the
+// queue exceeds the limits of the client's class.
+type outputBuffer struct {
+	net.Conn
+	// limit returns the limit of a class of clients, overflow is called when the connection is closed for exceeding it.
+	limit    func(clientType string) OutputBufferLimit
+	overflow func(size int64)
+
+	mu sync.Mutex
+	// cond is signalled when data is queued or sent, and when the buffer closes.
+	cond       *sync.Cond
+	clientType string
+	queued     []byte
+	// writes counts the writes in queued, sending the bytes being sent.
+	writes  int
+	sending int
+	// softSince is when the queue went above the soft limit.
+	softSince time.Time
+	// direct is set while writes bypass the queue, see bypass.
+	direct bool
+	closed bool
+	// done is closed once sendLoop returned.
+	done chan struct{}
+}
+
+func newOutputBuffer(connection net.Conn, limit func(clientType string) OutputBufferLimit, overflow func(size int64)) *outputBuffer {
+	b := &outputBuffer{
+		Conn:       connection,
+		limit:      limit,
+		overflow:   overflow,
+		clientType: clientNormal,
+		done:       make(chan struct{}),
+	}
+	b.cond = sync.NewCond(&b.mu)
+	go b.sendLoop()
+
+	return b
+}
+
+func (b *outputBuffer) setClientType(clientType string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.clientType = clientType
+}
+
+// Write queues a copy of data. It fails once the connection is closed, or if
+// data makes the queue exceed its limits, which closes the connection.
+func (b *outputBuffer) Write(data []byte) (int, error) {
+	b.mu.Lock()
+	if b.direct {
+		b.mu.Unlock()
+		return b.Conn.Write(data)
+	}
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return 0, net.ErrClosed
+	}
+
+	b.queued = append(b.queued, data...)
+	b.writes++
+	if size := int64(len(b.queued) + b.sending); b.exceedsLimit(size, time.Now()) {
+		b.abort()
+		b.overflow(size)
+		return 0, errOutputBufferLimit
+	}
+
+	b.cond.Broadcast()
+	return len(data), nil
+}
+
+// checkLimit closes the connection if the queue stayed above the soft limit for too long. Write
+// checks the limits as data is queued, this catches the clients that stopped getting replies.
+func (b *outputBuffer) checkLimit(now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed || b.direct {
+		return
+	}
+	if size := int64(len(b.queued) + b.sending); b.exceedsLimit(size, now) {
+		b.abort()
+		b.overflow(size)
+	}
+}
+
+// hold closes the connection if size bytes kept aside for it, on top of the queue, exceed
+// the limits. Replicas keep the stream aside this way while they receive the RDB.
+func (b *outputBuffer) hold(size int64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return net.ErrClosed
+	}
+	if size += int64(len(b.queued) + b.sending); b.exceedsLimit(size, time.Now()) {
+		b.abort()
+		b.overflow(size)
+		return errOutputBufferLimit
+	}
+
+	return nil
+}
+
+// exceedsLimit reports whether a queue of size bytes exceeds the limits, mu held.
+func (b *outputBuffer) exceedsLimit(size int64, now time.Time) bool {
+	limit := b.limit(b.clientType)
+	if limit.Hard > 0 && size >= limit.Hard {
+		return true
+	}
+	if limit.Soft == 0 || size < limit.Soft {
+		b.softSince = time.Time{}
+		return false
+	}
+
+	if b.softSince.IsZero() {
+		b.softSince = now
+		return false
+	}
+	return now.Sub(b.softSince) > limit.SoftDuration
+}
+
+// sendLoop sends the queued data until the buffer closes. It closes the connection once
+// the queue is drained, or right away if sending fails.
+func (b *outputBuffer) sendLoop() {
+	defer close(b.done)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	defer b.Conn.Close()
+
+	for {
+		for (len(b.queued) == 0 || b.direct) && !b.closed {
+			b.cond.Wait()
+		}
+		if len(b.queued) == 0 {
+			return
+		}
+
+		data := b.queued
+		b.queued = nil
+		b.writes = 0
+		b.sending = len(data)
+		b.mu.Unlock()
+
+		_, err := b.Conn.Write(data)
+
+		b.mu.Lock()
+		b.sending = 0
+		b.cond.Broadcast()
+		if err != nil {
+			b.closed = true
+			b.queued = nil
+			return
+		}
+	}
+}
+
+// bypass runs write with the queue bypassed: the data queued so far is sent
+// first, then writes go to the connection directly, without limits. Replicas
+// receive the RDB this way, rather than through a copy of it held in memory.
+func (b *outputBuffer) bypass(write func() error) error {
+	b.mu.Lock()
+	for (len(b.queued) > 0 || b.sending > 0) && !b.closed {
+		b.cond.Wait()
+	}
+	if b.closed {
+		b.mu.Unlock()
+		return net.ErrClosed
+	}
+	b.direct = true
+	b.mu.Unlock()
+
+	err := write()
+
+	b.mu.Lock()
+	b.direct = false
+	b.cond.Broadcast()
+	b.mu.Unlock()
+	return err
+}
+
+// usage returns how many writes are queued, and how many bytes are left to send.
+func (b *outputBuffer) usage() (int, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.writes, len(b.queued) + b.sending
+}
+
+// Close drops what is left to send and closes the connection.
+func (b *outputBuffer) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.abort()
+	return nil
+}
+
+// abort drops the queue and closes the connection, mu held.
+func (b *outputBuffer) abort() {
+	b.closed = true
+	b.queued = nil
+	b.writes = 0
+	b.Conn.Close()
+	b.cond.Broadcast()
+}
+
+// drain stops accepting writes and closes the connection once what is queued
+// was sent, or after outputDrainTimeout if the client does not read it.
+func (b *outputBuffer) drain() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	b.Conn.SetWriteDeadline(time.Now().Add(outputDrainTimeout))
+	b.cond.Broadcast()
+}
+
+// wait blocks until the connection is closed, after drain or Close.
+func (b *outputBuffer) wait() {
+	<-b.done
+}
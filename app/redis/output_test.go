@@ -0,0 +1,63 @@
+package redis_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutputBufferLimits(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit redis.OutputBufferLimit
+	}{
+		{
+			name:  "hard limit",
+			limit: redis.OutputBufferLimit{Hard: 1 << 20},
+		},
+		{
+			// The client sends nothing after the reply, so only the periodic check sees the queue.
+			name:  "soft limit",
+			limit: redis.OutputBufferLimit{Soft: 1 << 20, SoftDuration: time.Second / 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			admin := startServer(t, redis.WithOutputBufferLimits(redis.OutputBufferLimits{Normal: tt.limit}))
+			assert.Equal(t, okReply, admin.do("SET", "big", strings.Repeat("x", 8<<20)))
+
+			// The reply is larger than what the socket buffers hold, and is never read.
+			client := admin.dial()
+			client.send("GET", "big")
+
+			assert.Eventually(t, func() bool {
+				return strings.Contains(admin.do("INFO", "stats").Bulk, "client_output_buffer_limit_disconnections:1")
+			}, 5*time.Second, 50*time.Millisecond)
+		})
+	}
+}
+
+func TestReplicaOutputBufferLimit(t *testing.T) {
+	master := startServer(t, redis.WithOutputBufferLimits(redis.OutputBufferLimits{Replica: redis.OutputBufferLimit{Hard: 1 << 20}}))
+	assert.Equal(t, okReply, master.do("SET", "big", strings.Repeat("x", 8<<20)))
+
+	// The RDB is larger than what the socket buffers hold, and is never read,
+	// so the stream is kept aside for the replica until it exceeds the limit.
+	replica := master.dial()
+	replica.send("PSYNC", "?", "-1")
+	assert.Eventually(t, func() bool {
+		return strings.Contains(master.do("INFO", "replication").Bulk, "connected_slaves:1")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, okReply, master.do("SET", "stream", strings.Repeat("y", 1<<20)))
+	assert.Eventually(t, func() bool {
+		return strings.Contains(master.do("INFO", "stats").Bulk, "client_output_buffer_limit_disconnections:1")
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		return strings.Contains(master.do("INFO", "replication").Bulk, "connected_slaves:0")
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,282 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDBFilename = "dump.rdb"
+
+	// After a failed background save, automatic saves are retried no sooner than this.
+	bgsaveRetryDelay = 5 * time.Second
+)
+
+var ErrSaveInProgress = errors.New("background save already in progress")
+
+// SavePolicy triggers a background save once Changes writes happened within Seconds.
+type SavePolicy struct {
+	Seconds int
+	Changes int64
+}
+
+// ParseSavePolicies parses the "<seconds> <changes> ..." format of the `save` option.
+// An empty string disables automatic saves.
+func ParseSavePolicies(spec string) ([]SavePolicy, error) {
+	fields := strings.Fields(spec)
+	if len(fields)%2 != 0 {
+		return nil, fmt.Errorf("invalid save policy %q: expected pairs of <seconds> <changes>", spec)
+	}
+
+	policies := []SavePolicy{}
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err := strconv.Atoi(fields[i])
+		if err != nil || seconds < 1 {
+			return nil, fmt.Errorf("invalid save policy seconds %q", fields[i])
+		}
+
+		changes, err := strconv.ParseInt(fields[i+1], 10, 64)
+		if err != nil || changes < 0 {
+			return nil, fmt.Errorf("invalid save policy changes %q", fields[i+1])
+		}
+
+		policies = append(policies, SavePolicy{Seconds: seconds, Changes: changes})
+	}
+
+	return policies, nil
+}
+
+func WithRDB(dir string, dbFilename string) func(*Server) {
+	return func(s *Server) {
+		s.dir = dir
+		s.dbFilename = dbFilename
+	}
+}
+
+func WithSavePolicies(policies []SavePolicy) func(*Server) {
+	return func(s *Server) {
+		s.savePolicies = policies
+	}
+}
+
+type rdbState struct {
+	mu sync.Mutex
+	// saving is set while a SAVE or BGSAVE is writing the file, saved is closed once it is done.
+	saving     bool
+	saved      chan struct{}
+	lastSave   time.Time
+	lastDirty  int64
+	lastFailed time.Time
+}
+
+func (s *Server) rdbPath() string {
+	return filepath.Join(s.dir, s.dbFilename)
+}
+
+// loadRDB loads the dataset from the RDB file. A missing file is not an error.
+func (s *Server) loadRDB() error {
+	file, err := os.Open(s.rdbPath())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			s.logger.Printf("No RDB file at %s, starting empty\n", s.rdbPath())
+			return nil
+		}
+
+		return fmt.Errorf("failed to open RDB file: %w", err)
+	}
+	defer file.Close()
+
+	snapshot, err := ReadRDB(bufio.NewReader(file))
+	if err != nil {
+		return fmt.Errorf("failed to read RDB file %s: %w", s.rdbPath(), err)
+	}
+
+	err = s.client.Load(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to load RDB file %s: %w", s.rdbPath(), err)
+	}
+
+	s.logger.Printf("Loaded the dataset from %s\n", s.rdbPath())
+	return nil
+}
+
+func (s *Server) beginSave() (int64, error) {
+	s.rdb.mu.Lock()
+	defer s.rdb.mu.Unlock()
+
+	if s.rdb.saving {
+		return 0, ErrSaveInProgress
+	}
+
+	s.rdb.saving = true
+	s.rdb.saved = make(chan struct{})
+	return s.client.Dirty(), nil
+}
+
+// waitBeginSave begins a save once the one in progress is done. It gives up when
+// cancel is closed or the server stops.
+func (s *Server) waitBeginSave(cancel <-chan struct{}) (int64, error) {
+	for {
+		dirty, err := s.beginSave()
+		if !errors.Is(err, ErrSaveInProgress) {
+			return dirty, err
+		}
+
+		s.rdb.mu.Lock()
+		saved := s.rdb.saved
+		s.rdb.mu.Unlock()
+
+		select {
+		case <-saved:
+		case <-cancel:
+			return 0, ErrSaveInProgress
+		case <-s.ctx.Done():
+			return 0, ErrSaveInProgress
+		}
+	}
+}
+
+func (s *Server) endSave(dirty int64, err error) {
+	s.rdb.mu.Lock()
+	defer s.rdb.mu.Unlock()
+
+	s.rdb.saving = false
+	close(s.rdb.saved)
+	if err != nil {
+		s.rdb.lastFailed = time.Now()
+		return
+	}
+
+	s.rdb.lastSave = time.Now()
+	s.rdb.lastDirty = dirty
+}
+
+// save writes the dataset to the RDB file, blocking until it is done.
+func (s *Server) save() error {
+	dirty, err := s.beginSave()
+	if err != nil {
+		return err
+	}
+
+	return s.finishSave(dirty)
+}
+
+// finishSave writes the dataset once beginSave succeeded.
+func (s *Server) finishSave(dirty int64) error {
+	err := writeRDBFile(s.rdbPath(), s.client.Snapshot()())
+	s.endSave(dirty, err)
+
+	return err
+}
+
+// bgsave takes a snapshot of the dataset and writes it in the background.
+// Commands are held back only while the dataset is frozen.
+func (s *Server) bgsave() error {
+	dirty, err := s.beginSave()
+	if err != nil {
+		return err
+	}
+
+	takeSnapshot := s.client.Snapshot()
+	s.goTracked(func() {
+		err := writeRDBFile(s.rdbPath(), takeSnapshot())
+		if err != nil {
+			s.logger.Println("Background save failed:", err)
+		} else {
+			s.logger.Println("Background save finished")
+		}
+
+		s.endSave(dirty, err)
+	})
+
+	return nil
+}
+
+// writeRDBFile writes the snapshot to a temporary file first, so a crash never leaves a partial RDB file behind.
+func writeRDBFile(path string, snapshot Snapshot) error {
+	tempPath := filepath.Join(filepath.Dir(path), fmt.Sprintf("temp-%d-%d.rdb", os.Getpid(), time.Now().UnixNano()))
+	file, err := os.Create(tempPath)
+	if err != nil {
+		return fmt.Errorf("failed to create RDB file: %w", err)
+	}
+	defer os.Remove(tempPath)
+
+	writer := bufio.NewWriter(file)
+	err = WriteRDB(writer, snapshot)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err != nil {
+		return fmt.Errorf("failed to write RDB file: %w", err)
+	}
+	if closeErr != nil {
+		return fmt.Errorf("failed to close RDB file: %w", closeErr)
+	}
+
+	err = os.Rename(tempPath, path)
+	if err != nil {
+		return fmt.Errorf("failed to move RDB file in place: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Server) lastSave() time.Time {
+	s.rdb.mu.Lock()
+	defer s.rdb.mu.Unlock()
+
+	return s.rdb.lastSave
+}
+
+// saveLoop triggers background saves according to the save policies.
+func (s *Server) saveLoop(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !s.shouldSave() {
+				continue
+			}
+
+			err := s.bgsave()
+			if err != nil && !errors.Is(err, ErrSaveInProgress) {
+				s.logger.Println("Failed to start background save:", err)
+			}
+		}
+	}
+}
+
+func (s *Server) shouldSave() bool {
+	s.rdb.mu.Lock()
+	defer s.rdb.mu.Unlock()
+
+	if s.rdb.saving || time.Since(s.rdb.lastFailed) < bgsaveRetryDelay {
+		return false
+	}
+
+	changes := s.client.Dirty() - s.rdb.lastDirty
+	elapsed := time.Since(s.rdb.lastSave)
+	for _, policy := range s.savePolicies {
+		if changes >= policy.Changes && changes > 0 && elapsed >= time.Duration(policy.Seconds)*time.Second {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,560 @@
+package redis
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	rdbVersion = 11
+
+	rdbOpcodeSlotInfo     = 0xF4
+	rdbOpcodeFunction2    = 0xF5
+	rdbOpcodeModuleAux    = 0xF7
+	rdbOpcodeIdle         = 0xF8
+	rdbOpcodeFreq         = 0xF9
+	rdbOpcodeAux          = 0xFA
+	rdbOpcodeResizeDB     = 0xFB
+	rdbOpcodeExpireTimeMs = 0xFC
+	rdbOpcodeExpireTime   = 0xFD
+	rdbOpcodeSelectDB     = 0xFE
+	rdbOpcodeEOF          = 0xFF
+
+	rdbTypeString = 0
+
+	rdbLen6Bit  = 0
+	rdbLen14Bit = 1
+	rdbLen32Bit = 0x80
+	rdbLen64Bit = 0x81
+	rdbEncVal   = 3
+
+	rdbEncInt8  = 0
+	rdbEncInt16 = 1
+	rdbEncInt32 = 2
+	rdbEncLZF   = 3
+
+	// rdbMaxStringLength bounds the strings read, like proto-max-bulk-len bounds the ones clients send.
+	rdbMaxStringLength = 512 << 20
+	// rdbMaxDatabases is the most databases the databases option allows.
+	rdbMaxDatabases = 1 << 20
+	// rdbReadChunk is how much is allocated at once for a long string, so that a
+	// corrupt length fails on the missing data before it allocates all of it.
+	rdbReadChunk = 1 << 20
+
+	// IDGEN state is persisted as aux fields, which other tools skip over.
+	rdbAuxIDGenNextPrefix = "idgen-next:"
+	rdbAuxIDGenSeqPrefix  = "idgen-seq:"
+)
+
+var ErrInvalidRDB = errors.New("invalid RDB")
+
+// Redis checksums RDB files with the Jones CRC64 (reflected, no initial or final xor).
+var crc64Table = crc64.MakeTable(0x95ac9329ac4bc9b5)
+
+func CRC64(crc uint64, data []byte) uint64 {
+	return ^crc64.Update(^crc, crc64Table, data)
+}
+
+// Snapshot is a point-in-time copy of everything that is persisted.
+type Snapshot struct {
+	Databases []map[string]Entry
+	IDGenNext map[string]int64
+	IDGenSeq  map[string]int64
+	CreatedAt time.Time
+}
+
+type rdbWriter struct {
+	w   io.Writer
+	crc uint64
+	err error
+}
+
+func (w *rdbWriter) write(data []byte) {
+	if w.err != nil {
+		return
+	}
+
+	w.crc = CRC64(w.crc, data)
+	_, w.err = w.w.Write(data)
+}
+
+func (w *rdbWriter) writeByte(b byte) {
+	w.write([]byte{b})
+}
+
+func (w *rdbWriter) writeLength(length uint64) {
+	switch {
+	case length < 1<<6:
+		w.writeByte(byte(length))
+	case length < 1<<14:
+		w.write([]byte{byte(length>>8) | rdbLen14Bit<<6, byte(length)})
+	case length <= 0xFFFFFFFF:
+		buf := make([]byte, 5)
+		buf[0] = rdbLen32Bit
+		binary.BigEndian.PutUint32(buf[1:], uint32(length))
+		w.write(buf)
+	default:
+		buf := make([]byte, 9)
+		buf[0] = rdbLen64Bit
+		binary.BigEndian.PutUint64(buf[1:], length)
+		w.write(buf)
+	}
+}
+
+func (w *rdbWriter) writeString(value string) {
+	// Small integers are stored in their binary form, like Redis does.
+	if n, err := strconv.ParseInt(value, 10, 32); err == nil && strconv.FormatInt(n, 10) == value {
+		switch {
+		case n >= -1<<7 && n < 1<<7:
+			w.write([]byte{rdbEncVal<<6 | rdbEncInt8, byte(int8(n))})
+		case n >= -1<<15 && n < 1<<15:
+			buf := []byte{rdbEncVal<<6 | rdbEncInt16, 0, 0}
+			binary.LittleEndian.PutUint16(buf[1:], uint16(int16(n)))
+			w.write(buf)
+		default:
+			buf := []byte{rdbEncVal<<6 | rdbEncInt32, 0, 0, 0, 0}
+			binary.LittleEndian.PutUint32(buf[1:], uint32(int32(n)))
+			w.write(buf)
+		}
+		return
+	}
+
+	w.writeLength(uint64(len(value)))
+	w.write([]byte(value))
+}
+
+func (w *rdbWriter) writeAux(key string, value string) {
+	w.writeByte(rdbOpcodeAux)
+	w.writeString(key)
+	w.writeString(value)
+}
+
+// writeEntry writes a key with its expiry, without any opcode for the database it belongs to.
+func (w *rdbWriter) writeEntry(key string, entry Entry) {
+	if entry.ExpiresAt != nil {
+		buf := make([]byte, 9)
+		buf[0] = rdbOpcodeExpireTimeMs
+		binary.LittleEndian.PutUint64(buf[1:], uint64(entry.ExpiresAt.UnixMilli()))
+		w.write(buf)
+	}
+
+	w.writeByte(rdbTypeString)
+	w.writeString(key)
+	w.writeString(entry.Value)
+}
+
+// WriteRDB encodes the snapshot in the RDB format, checksum included.
+func WriteRDB(w io.Writer, snapshot Snapshot) error {
+	rw := &rdbWriter{w: w}
+
+	rw.write([]byte(fmt.Sprintf("REDIS%04d", rdbVersion)))
+	rw.writeAux("redis-ver", "7.2.0")
+	rw.writeAux("redis-bits", "64")
+	rw.writeAux("ctime", strconv.FormatInt(snapshot.CreatedAt.Unix(), 10))
+	rw.writeAux("aof-base", "0")
+
+	for name, last := range snapshot.IDGenNext {
+		rw.writeAux(rdbAuxIDGenNextPrefix+name, strconv.FormatInt(last, 10))
+	}
+	for name, last := range snapshot.IDGenSeq {
+		rw.writeAux(rdbAuxIDGenSeqPrefix+name, strconv.FormatInt(last, 10))
+	}
+
+	for db, entries := range snapshot.Databases {
+		if len(entries) == 0 {
+			continue
+		}
+
+		expires := 0
+		for _, entry := range entries {
+			if entry.ExpiresAt != nil {
+				expires++
+			}
+		}
+
+		rw.writeByte(rdbOpcodeSelectDB)
+		rw.writeLength(uint64(db))
+		rw.writeByte(rdbOpcodeResizeDB)
+		rw.writeLength(uint64(len(entries)))
+		rw.writeLength(uint64(expires))
+
+		for key, entry := range entries {
+			rw.writeEntry(key, entry)
+		}
+	}
+
+	rw.writeByte(rdbOpcodeEOF)
+	checksum := make([]byte, 8)
+	binary.LittleEndian.PutUint64(checksum, rw.crc)
+	rw.write(checksum)
+
+	return rw.err
+}
+
+type rdbReader struct {
+	r   io.Reader
+	crc uint64
+}
+
+func (r *rdbReader) read(n int) ([]byte, error) {
+	if n < 0 || n > rdbMaxStringLength {
+		return nil, fmt.Errorf("%w: length %d out of range", ErrInvalidRDB, n)
+	}
+	// A reader that knows how much is left, like the body of a DUMP payload, rejects longer lengths before allocating.
+	if remaining, ok := r.r.(interface{ Len() int }); ok && n > remaining.Len() {
+		return nil, fmt.Errorf("%w: length %d exceeds the %d bytes left", ErrInvalidRDB, n, remaining.Len())
+	}
+
+	buf := make([]byte, min(n, rdbReadChunk))
+	_, err := io.ReadFull(r.r, buf)
+	for err == nil && len(buf) < n {
+		chunk := min(n-len(buf), rdbReadChunk)
+		buf = append(buf, make([]byte, chunk)...)
+		_, err = io.ReadFull(r.r, buf[len(buf)-chunk:])
+	}
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("%w: %w", ErrInvalidRDB, err)
+	}
+
+	r.crc = CRC64(r.crc, buf)
+	return buf, nil
+}
+
+func (r *rdbReader) readByte() (byte, error) {
+	buf, err := r.read(1)
+	if err != nil {
+		return 0, err
+	}
+
+	return buf[0], nil
+}
+
+// readLength returns the decoded length, or with encoded set, the special string encoding that follows.
+func (r *rdbReader) readLength() (length uint64, encoded bool, err error) {
+	first, err := r.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+
+	switch first >> 6 {
+	case rdbLen6Bit:
+		return uint64(first & 0x3F), false, nil
+	case rdbLen14Bit:
+		next, err := r.readByte()
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(first&0x3F)<<8 | uint64(next), false, nil
+	case rdbEncVal:
+		return uint64(first & 0x3F), true, nil
+	}
+
+	switch first {
+	case rdbLen32Bit:
+		buf, err := r.read(4)
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(binary.BigEndian.Uint32(buf)), false, nil
+	case rdbLen64Bit:
+		buf, err := r.read(8)
+		if err != nil {
+			return 0, false, err
+		}
+		return binary.BigEndian.Uint64(buf), false, nil
+	}
+
+	return 0, false, fmt.Errorf("%w: unknown length encoding %#x", ErrInvalidRDB, first)
+}
+
+func (r *rdbReader) readPlainLength() (uint64, error) {
+	length, encoded, err := r.readLength()
+	if err != nil {
+		return 0, err
+	}
+	if encoded {
+		return 0, fmt.Errorf("%w: expected a length, got an encoded value", ErrInvalidRDB)
+	}
+
+	return length, nil
+}
+
+func (r *rdbReader) readString() (string, error) {
+	length, encoded, err := r.readLength()
+	if err != nil {
+		return "", err
+	}
+
+	if !encoded {
+		if length > rdbMaxStringLength {
+			return "", fmt.Errorf("%w: string length %d out of range", ErrInvalidRDB, length)
+		}
+		buf, err := r.read(int(length))
+		if err != nil {
+			return "", err
+		}
+		return string(buf), nil
+	}
+
+	switch length {
+	case rdbEncInt8:
+		buf, err := r.read(1)
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(int(int8(buf[0]))), nil
+	case rdbEncInt16:
+		buf, err := r.read(2)
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(int(int16(binary.LittleEndian.Uint16(buf)))), nil
+	case rdbEncInt32:
+		buf, err := r.read(4)
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(int(int32(binary.LittleEndian.Uint32(buf)))), nil
+	case rdbEncLZF:
+		compressedLength, err := r.readPlainLength()
+		if err != nil {
+			return "", err
+		}
+		length, err := r.readPlainLength()
+		if err != nil {
+			return "", err
+		}
+		if compressedLength > rdbMaxStringLength || length > rdbMaxStringLength {
+			return "", fmt.Errorf("%w: LZF string length %d out of range", ErrInvalidRDB, length)
+		}
+		compressed, err := r.read(int(compressedLength))
+		if err != nil {
+			return "", err
+		}
+		value, err := lzfDecompress(compressed, int(length))
+		if err != nil {
+			return "", err
+		}
+		return string(value), nil
+	}
+
+	return "", fmt.Errorf("%w: unknown string encoding %d", ErrInvalidRDB, length)
+}
+
+// ReadRDB decodes an RDB file, verifying its checksum when one is present.
+// It reads exactly up to the end of the checksum, so r can be a connection carrying more data.
+func ReadRDB(r io.Reader) (Snapshot, error) {
+	rr := &rdbReader{r: r}
+	snapshot := Snapshot{
+		IDGenNext: map[string]int64{},
+		IDGenSeq:  map[string]int64{},
+	}
+
+	header, err := rr.read(9)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if !bytes.HasPrefix(header, []byte("REDIS")) {
+		return Snapshot{}, fmt.Errorf("%w: wrong signature %q", ErrInvalidRDB, header)
+	}
+	version, err := strconv.Atoi(string(header[5:]))
+	if err != nil || version < 1 || version > rdbVersion {
+		return Snapshot{}, fmt.Errorf("%w: unsupported version %q", ErrInvalidRDB, header[5:])
+	}
+
+	db := 0
+	var expiresAt *time.Time
+	for {
+		opcode, err := rr.readByte()
+		if err != nil {
+			return Snapshot{}, err
+		}
+
+		switch opcode {
+		case rdbOpcodeEOF:
+			if version < 5 {
+				return snapshot, nil
+			}
+
+			expected := rr.crc
+			buf, err := rr.read(8)
+			if err != nil {
+				return Snapshot{}, err
+			}
+			checksum := binary.LittleEndian.Uint64(buf)
+			if checksum != 0 && checksum != expected {
+				return Snapshot{}, fmt.Errorf("%w: checksum mismatch", ErrInvalidRDB)
+			}
+			return snapshot, nil
+
+		case rdbOpcodeSelectDB:
+			index, err := rr.readPlainLength()
+			if err != nil {
+				return Snapshot{}, err
+			}
+			if index >= rdbMaxDatabases {
+				return Snapshot{}, fmt.Errorf("%w: database %d out of range", ErrInvalidRDB, index)
+			}
+			db = int(index)
+
+		case rdbOpcodeResizeDB:
+			if _, err := rr.readPlainLength(); err != nil {
+				return Snapshot{}, err
+			}
+			if _, err := rr.readPlainLength(); err != nil {
+				return Snapshot{}, err
+			}
+
+		case rdbOpcodeAux:
+			key, err := rr.readString()
+			if err != nil {
+				return Snapshot{}, err
+			}
+			value, err := rr.readString()
+			if err != nil {
+				return Snapshot{}, err
+			}
+			snapshot.readAux(key, value)
+
+		case rdbOpcodeExpireTimeMs:
+			buf, err := rr.read(8)
+			if err != nil {
+				return Snapshot{}, err
+			}
+			at := time.UnixMilli(int64(binary.LittleEndian.Uint64(buf)))
+			expiresAt = &at
+
+		case rdbOpcodeExpireTime:
+			buf, err := rr.read(4)
+			if err != nil {
+				return Snapshot{}, err
+			}
+			at := time.Unix(int64(binary.LittleEndian.Uint32(buf)), 0)
+			expiresAt = &at
+
+		case rdbOpcodeIdle:
+			if _, err := rr.readPlainLength(); err != nil {
+				return Snapshot{}, err
+			}
+
+		case rdbOpcodeFreq:
+			if _, err := rr.readByte(); err != nil {
+				return Snapshot{}, err
+			}
+
+		case rdbOpcodeSlotInfo:
+			for i := 0; i < 3; i++ {
+				if _, err := rr.readPlainLength(); err != nil {
+					return Snapshot{}, err
+				}
+			}
+
+		case rdbOpcodeFunction2, rdbOpcodeModuleAux:
+			return Snapshot{}, fmt.Errorf("%w: functions and modules are not supported", ErrInvalidRDB)
+
+		case rdbTypeString:
+			key, err := rr.readString()
+			if err != nil {
+				return Snapshot{}, err
+			}
+			value, err := rr.readString()
+			if err != nil {
+				return Snapshot{}, err
+			}
+
+			for len(snapshot.Databases) <= db {
+				snapshot.Databases = append(snapshot.Databases, map[string]Entry{})
+			}
+			snapshot.Databases[db][key] = Entry{Value: value, ExpiresAt: expiresAt}
+			expiresAt = nil
+
+		default:
+			return Snapshot{}, fmt.Errorf("%w: unsupported value type %d", ErrInvalidRDB, opcode)
+		}
+	}
+}
+
+func (s *Snapshot) readAux(key string, value string) {
+	switch {
+	case key == "ctime":
+		if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+			s.CreatedAt = time.Unix(seconds, 0)
+		}
+	case strings.HasPrefix(key, rdbAuxIDGenNextPrefix):
+		if last, err := strconv.ParseInt(value, 10, 64); err == nil {
+			s.IDGenNext[strings.TrimPrefix(key, rdbAuxIDGenNextPrefix)] = last
+		}
+	case strings.HasPrefix(key, rdbAuxIDGenSeqPrefix):
+		if last, err := strconv.ParseInt(value, 10, 64); err == nil {
+			s.IDGenSeq[strings.TrimPrefix(key, rdbAuxIDGenSeqPrefix)] = last
+		}
+	}
+}
+
+// lzfMaxRatio is the most an LZF back reference expands: 264 bytes out of 3.
+const lzfMaxRatio = 88
+
+func lzfDecompress(in []byte, length int) ([]byte, error) {
+	if length > len(in)*lzfMaxRatio {
+		return nil, fmt.Errorf("%w: LZF length %d is more than %d bytes can hold", ErrInvalidRDB, length, len(in))
+	}
+	out := make([]byte, 0, length)
+
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+
+		if ctrl < 1<<5 {
+			literal := ctrl + 1
+			if i+literal > len(in) {
+				return nil, fmt.Errorf("%w: truncated LZF literal", ErrInvalidRDB)
+			}
+			if len(out)+literal > length {
+				return nil, fmt.Errorf("%w: LZF data longer than %d bytes", ErrInvalidRDB, length)
+			}
+			out = append(out, in[i:i+literal]...)
+			i += literal
+			continue
+		}
+
+		backref := ctrl >> 5
+		if backref == 7 {
+			if i >= len(in) {
+				return nil, fmt.Errorf("%w: truncated LZF back reference", ErrInvalidRDB)
+			}
+			backref += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, fmt.Errorf("%w: truncated LZF back reference", ErrInvalidRDB)
+		}
+		ref := len(out) - (ctrl&0x1F)<<8 - int(in[i]) - 1
+		i++
+		if ref < 0 {
+			return nil, fmt.Errorf("%w: LZF back reference out of bounds", ErrInvalidRDB)
+		}
+		if len(out)+backref+2 > length {
+			return nil, fmt.Errorf("%w: LZF data longer than %d bytes", ErrInvalidRDB, length)
+		}
+
+		for j := 0; j < backref+2; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+
+	if len(out) != length {
+		return nil, fmt.Errorf("%w: LZF length mismatch - got: %d, want: %d", ErrInvalidRDB, len(out), length)
+	}
+
+	return out, nil
+}
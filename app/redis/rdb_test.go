@@ -0,0 +1,131 @@
+package redis_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCRC64(t *testing.T) {
+	assert.Equal(t, uint64(0xe9c6d914c4b8d9ca), redis.CRC64(0, []byte("123456789")))
+}
+
+func TestRDB(t *testing.T) {
+	t.Run("round trip", func(t *testing.T) {
+		expiresAt := time.UnixMilli(1956528000000)
+		snapshot := redis.Snapshot{
+			Databases: []map[string]redis.Entry{
+				{
+					"name":    {Value: "redis"},
+					"counter": {Value: "-40000"},
+					"small":   {Value: "12"},
+					"session": {Value: "abc", ExpiresAt: &expiresAt},
+				},
+				{},
+				{"long": {Value: string(bytes.Repeat([]byte("x"), 20000))}},
+			},
+			IDGenNext: map[string]int64{"orders": 370478771816726528},
+			IDGenSeq:  map[string]int64{"invoices": 42},
+			CreatedAt: time.Unix(1700000000, 0),
+		}
+
+		var buf bytes.Buffer
+		assert.NoError(t, redis.WriteRDB(&buf, snapshot))
+
+		decoded, err := redis.ReadRDB(&buf)
+		assert.NoError(t, err)
+		assert.Equal(t, snapshot.Databases[0], decoded.Databases[0])
+		assert.Empty(t, decoded.Databases[1])
+		assert.Equal(t, snapshot.Databases[2], decoded.Databases[2])
+		assert.Equal(t, snapshot.IDGenNext, decoded.IDGenNext)
+		assert.Equal(t, snapshot.IDGenSeq, decoded.IDGenSeq)
+		assert.Equal(t, snapshot.CreatedAt, decoded.CreatedAt)
+	})
+
+	t.Run("empty RDB from Redis", func(t *testing.T) {
+		data, err := base64.StdEncoding.DecodeString("UkVESVMwMDEx+glyZWRpcy12ZXIFNy4yLjD6CnJlZGlzLWJpdHPAQPoFY3RpbWXCbQi8ZfoIdXNlZC1tZW3CsMQQAPoIYW9mLWJhc2XAAP/wbjv+wP9aog==")
+		assert.NoError(t, err)
+
+		decoded, err := redis.ReadRDB(bytes.NewReader(data))
+		assert.NoError(t, err)
+		assert.Empty(t, decoded.Databases)
+	})
+
+	t.Run("corrupted checksum", func(t *testing.T) {
+		var buf bytes.Buffer
+		assert.NoError(t, redis.WriteRDB(&buf, redis.Snapshot{Databases: []map[string]redis.Entry{{"key": {Value: "value"}}}}))
+
+		data := buf.Bytes()
+		data[len(data)-1] ^= 0xFF
+
+		_, err := redis.ReadRDB(bytes.NewReader(data))
+		assert.ErrorIs(t, err, redis.ErrInvalidRDB)
+	})
+
+	t.Run("truncated file", func(t *testing.T) {
+		var buf bytes.Buffer
+		assert.NoError(t, redis.WriteRDB(&buf, redis.Snapshot{Databases: []map[string]redis.Entry{{"key": {Value: "value"}}}}))
+
+		_, err := redis.ReadRDB(bytes.NewReader(buf.Bytes()[:buf.Len()-12]))
+		assert.ErrorIs(t, err, redis.ErrInvalidRDB)
+	})
+
+	t.Run("corrupt lengths", func(t *testing.T) {
+		for _, data := range []string{
+			"REDIS0011\x00\x81\xff\xff\xff\xff\xff\xff\xff\xff",
+			"REDIS0011\x00\x80\x10\x00\x00\x00key",
+			"REDIS0011\x00\xc3\x02\x80\x10\x00\x00\x00\x01ab",
+			"REDIS0011\xfe\x81\x00\x00\x00\x00\x7f\xff\xff\xff",
+		} {
+			_, err := redis.ReadRDB(bytes.NewReader([]byte(data)))
+			assert.ErrorIs(t, err, redis.ErrInvalidRDB, data)
+		}
+	})
+}
+
+func TestDump(t *testing.T) {
+	t.Run("payload from Redis", func(t *testing.T) {
+		value, err := redis.DecodeDump([]byte("\x00\xc0\n\t\x00\xbem\x06\x89Z(\x00\n"))
+		assert.NoError(t, err)
+		assert.Equal(t, "10", value)
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		for _, value := range []string{"", "10", "-40000", "redis", string(bytes.Repeat([]byte("x"), 20000))} {
+			decoded, err := redis.DecodeDump(redis.EncodeDump(value))
+			assert.NoError(t, err)
+			assert.Equal(t, value, decoded)
+		}
+	})
+
+	t.Run("corrupted checksum", func(t *testing.T) {
+		payload := redis.EncodeDump("value")
+		payload[len(payload)-1] ^= 0xFF
+
+		_, err := redis.DecodeDump(payload)
+		assert.ErrorIs(t, err, redis.ErrInvalidRDB)
+	})
+
+	t.Run("length beyond the payload", func(t *testing.T) {
+		// A string of 256MB, in a payload of a few bytes.
+		payload := []byte("\x00\x80\x10\x00\x00\x00\n\x00")
+		payload = binary.LittleEndian.AppendUint64(payload, redis.CRC64(0, payload))
+
+		_, err := redis.DecodeDump(payload)
+		assert.ErrorIs(t, err, redis.ErrInvalidRDB)
+		assert.ErrorContains(t, err, "exceeds the 0 bytes left")
+	})
+
+	t.Run("newer version", func(t *testing.T) {
+		payload := redis.EncodeDump("value")
+		payload[len(payload)-10] = 99
+
+		_, err := redis.DecodeDump(payload)
+		assert.ErrorIs(t, err, redis.ErrInvalidRDB)
+	})
+}
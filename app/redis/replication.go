@@ -0,0 +1,759 @@
+package redis
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultReplBacklogSize = 1 << 20
+	DefaultReplicaPriority = 100
+	DefaultReplTimeout     = 60 * time.Second
+	DefaultReplPingPeriod  = 10 * time.Second
+
+	replReconnectMinDelay = time.Second
+	replReconnectMaxDelay = 30 * time.Second
+
+	noReplicationID = "0000000000000000000000000000000000000000"
+)
+
+func WithReplBacklogSize(size int) func(*Server) {
+	return func(s *Server) {
+		s.repl.backlog = newBacklog(size)
+	}
+}
+
+// WithReplTimeout sets how long a replication link may stay silent before it is considered dead.
+func WithReplTimeout(timeout time.Duration) func(*Server) {
+	return func(s *Server) {
+		s.replTimeout.Store(int64(timeout))
+	}
+}
+
+// WithReplPingPeriod sets how often a master pings its replicas through the replication stream.
+func WithReplPingPeriod(period time.Duration) func(*Server) {
+	return func(s *Server) {
+		s.replPingPeriod = period
+	}
+}
+
+func WithReplicaReadOnly(readOnly bool) func(*Server) {
+	return func(s *Server) {
+		s.replicaReadOnly.Store(readOnly)
+	}
+}
+
+// WithReplicaPriority sets the priority sentinels promote this replica with, lower first. 0 means never.
+func WithReplicaPriority(priority int) func(*Server) {
+	return func(s *Server) {
+		s.replicaPriority = priority
+	}
+}
+
+func WithReplDisklessSync(diskless bool) func(*Server) {
+	return func(s *Server) {
+		s.replDisklessSync.Store(diskless)
+	}
+}
+
+// backlog keeps the tail of the replication stream, so that replicas that
+// were briefly disconnected can catch up without a full resync.
+type backlog struct {
+	buf []byte
+	// next is the position the next byte is written at.
+	next    int
+	histlen int
+}
+
+func newBacklog(size int) *backlog {
+	return &backlog{buf: make([]byte, size)}
+}
+
+func (b *backlog) write(data []byte) {
+	if len(data) >= len(b.buf) {
+		copy(b.buf, data[len(data)-len(b.buf):])
+		b.next = 0
+		b.histlen = len(b.buf)
+		return
+	}
+
+	n := copy(b.buf[b.next:], data)
+	copy(b.buf, data[n:])
+	b.next = (b.next + len(data)) % len(b.buf)
+	b.histlen = min(b.histlen+len(data), len(b.buf))
+}
+
+// last returns the last n bytes written, n must not exceed histlen.
+func (b *backlog) last(n int) []byte {
+	out := make([]byte, n)
+	start := (b.next - n + len(b.buf)) % len(b.buf)
+	copied := copy(out, b.buf[start:])
+	copy(out[copied:], b.buf)
+
+	return out
+}
+
+func (b *backlog) reset() {
+	b.next = 0
+	b.histlen = 0
+}
+
+type replicationState struct {
+	mu sync.Mutex
+	// id identifies the history of the dataset. A replica takes the id of its master.
+	id string
+	// id2 is the id of the master this server replicated before being promoted,
+	// whose history is shared up to secondOffset. It lets the other replicas
+	// of that master continue from this server.
+	id2          string
+	secondOffset int
+	// offset counts the bytes of the replication stream produced, or on a replica, processed.
+	offset  int
+	backlog *backlog
+	// cachedMaster is set on a replica holding the history of its master, so it can ask for a partial resync.
+	cachedMaster bool
+	// masterDB is the database selected in the stream of the master, which a partial resync continues in.
+	masterDB int
+	// acked is closed and replaced whenever a replica acknowledges an offset.
+	acked chan struct{}
+
+	// On a replica, masterConnection is the link to the master while it is up.
+	masterConnection net.Conn
+	masterLastIO     time.Time
+}
+
+func newReplicationState() replicationState {
+	return replicationState{
+		id:           randomHex(40),
+		id2:          noReplicationID,
+		secondOffset: -1,
+		backlog:      newBacklog(DefaultReplBacklogSize),
+		acked:        make(chan struct{}),
+	}
+}
+
+// feedStream records bytes of the replication stream in the backlog.
+func (s *Server) feedStream(data []byte) {
+	s.repl.mu.Lock()
+	defer s.repl.mu.Unlock()
+
+	s.repl.backlog.write(data)
+	s.repl.offset += len(data)
+}
+
+func (s *Server) replicationID() string {
+	s.repl.mu.Lock()
+	defer s.repl.mu.Unlock()
+
+	return s.repl.id
+}
+
+func (s *Server) replicationInfo() (id string, offset int) {
+	s.repl.mu.Lock()
+	defer s.repl.mu.Unlock()
+
+	return s.repl.id, s.repl.offset
+}
+
+// shiftReplicationID starts a new history, keeping the current one as the
+// secondary id, so that replicas sharing it can still partially resync.
+// It must be called with repl.mu held.
+func (s *Server) shiftReplicationID() {
+	s.repl.id2 = s.repl.id
+	s.repl.secondOffset = s.repl.offset + 1
+	s.repl.id = randomHex(40)
+}
+
+type replica struct {
+	connection    net.Conn
+	output        *outputBuffer
+	listeningPort string
+	// offset is the replication offset of the last byte sent to the replica.
+	offset int
+	// ackOffset is the replication offset the replica last acknowledged as processed.
+	ackOffset int
+	lastAck   time.Time
+
+	mu sync.Mutex
+	// online is set once the RDB was transferred. Until then, the stream is buffered
+	// in pending, which counts against the output buffer limits of the replica.
+	online  bool
+	pending []byte
+}
+
+func newReplica(session *Session, connection net.Conn, offset int) *replica {
+	return &replica{
+		connection:    connection,
+		output:        session.output,
+		listeningPort: session.replicaListeningPort,
+		offset:        offset,
+		lastAck:       time.Now(),
+	}
+}
+
+func (r *replica) acknowledged() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.ackOffset
+}
+
+// info describes the replica in the format of INFO replication.
+func (r *replica) info() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	host, _, _ := net.SplitHostPort(r.connection.RemoteAddr().String())
+	state := "wait_bgsave"
+	if r.online {
+		state = "online"
+	}
+
+	return fmt.Sprintf("ip=%s,port=%s,state=%s,offset=%d,lag=%d",
+		host, r.listeningPort, state, r.ackOffset, int(time.Since(r.lastAck).Seconds()))
+}
+
+func (r *replica) write(data []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.offset += len(data)
+	if !r.online {
+		r.pending = append(r.pending, data...)
+		err := r.output.hold(int64(len(r.pending)))
+		if err != nil {
+			// The replica is disconnected, what it would have been sent is of no use anymore.
+			r.pending = nil
+		}
+		return err
+	}
+
+	_, err := r.connection.Write(data)
+	return err
+}
+
+// goOnline sends everything buffered during the RDB transfer and starts streaming directly.
+func (r *replica) goOnline() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, err := r.connection.Write(r.pending)
+	if err != nil {
+		return err
+	}
+
+	r.pending = nil
+	r.online = true
+	return nil
+}
+
+func randomHex(length int) string {
+	buf := make([]byte, length/2)
+	_, err := rand.Read(buf)
+	if err != nil {
+		panic(fmt.Sprintf("failed to read random bytes: %v", err))
+	}
+
+	return hex.EncodeToString(buf)
+}
+
+// fullResync sends the replica a snapshot of the dataset, followed by every
+// write applied since the snapshot was taken.
+func (s *Server) fullResync(session *Session, connection net.Conn) error {
+	s.execMu.Lock()
+	takeSnapshot := s.client.Snapshot()
+	id, offset := s.replicationInfo()
+	r := newReplica(session, connection, offset)
+	s.replicas = append(s.replicas, r)
+	session.replica = r
+	session.output.setClientType(clientReplica)
+	// The new replica has no database selected yet.
+	s.replicationDB = -1
+	s.execMu.Unlock()
+	snapshot := takeSnapshot()
+
+	resyncValue := Value{Type: SimpleString, SimpleString: fmt.Sprintf("FULLRESYNC %s %d", id, offset)}
+	err := resyncValue.Write(connection)
+	if err == nil {
+		err = session.output.bypass(func() error {
+			return s.sendRDB(session, connection, snapshot)
+		})
+	}
+	if err == nil {
+		err = r.goOnline()
+	}
+	if err != nil {
+		s.removeReplica(r)
+		return fmt.Errorf("failed to synchronize replica %s: %w", connection.RemoteAddr(), err)
+	}
+
+	s.logger.Printf("Replica %s is in sync\n", connection.RemoteAddr())
+	return nil
+}
+
+// partialResync lets the replica continue from psyncOffset, the first byte of
+// the stream it is missing, if that part of the history is still in the backlog.
+func (s *Server) partialResync(session *Session, connection net.Conn, replicationID string, psyncOffset int) (bool, error) {
+	s.execMu.Lock()
+	defer s.execMu.Unlock()
+
+	s.repl.mu.Lock()
+	known := replicationID == s.repl.id || replicationID == s.repl.id2 && psyncOffset <= s.repl.secondOffset
+	backlogStart := s.repl.offset - s.repl.backlog.histlen + 1
+	if !known || psyncOffset < backlogStart || psyncOffset > s.repl.offset+1 {
+		s.repl.mu.Unlock()
+		return false, nil
+	}
+
+	missing := s.repl.backlog.last(s.repl.offset + 1 - psyncOffset)
+	id := s.repl.id
+	s.repl.mu.Unlock()
+
+	_, err := fmt.Fprintf(connection, "+CONTINUE %s\r\n%s", id, missing)
+	if err != nil {
+		return false, fmt.Errorf("failed to continue replica %s: %w", connection.RemoteAddr(), err)
+	}
+
+	r := newReplica(session, connection, psyncOffset-1+len(missing))
+	r.online = true
+	s.replicas = append(s.replicas, r)
+	session.replica = r
+	session.output.setClientType(clientReplica)
+
+	s.logger.Printf("Replica %s continues from offset %d\n", connection.RemoteAddr(), psyncOffset)
+	return true, nil
+}
+
+// acknowledge records the offset a replica reported with REPLCONF ACK and wakes up the clients in WAIT.
+func (s *Server) acknowledge(r *replica, offset int) {
+	r.mu.Lock()
+	r.ackOffset = max(r.ackOffset, offset)
+	r.lastAck = time.Now()
+	r.mu.Unlock()
+
+	s.repl.mu.Lock()
+	close(s.repl.acked)
+	s.repl.acked = make(chan struct{})
+	s.repl.mu.Unlock()
+}
+
+// countAcked returns the number of replicas that acknowledged the offset, and
+// a channel closed on the next acknowledgement.
+func (s *Server) countAcked(offset int) (int, <-chan struct{}) {
+	s.execMu.Lock()
+	defer s.execMu.Unlock()
+
+	s.repl.mu.Lock()
+	acked := s.repl.acked
+	s.repl.mu.Unlock()
+
+	count := 0
+	for _, r := range s.replicas {
+		if r.acknowledged() >= offset {
+			count++
+		}
+	}
+
+	return count, acked
+}
+
+// waitForReplicas blocks until numReplicas replicas acknowledged the offset,
+// or the timeout expires, and returns the number of replicas that did. A zero
+// timeout waits forever.
+func (s *Server) waitForReplicas(offset int, numReplicas int, timeout time.Duration) int {
+	count, acked := s.countAcked(offset)
+	if count >= numReplicas {
+		return count
+	}
+
+	// The request travels through the stream, so the replicas answer once they processed everything before it.
+	s.execMu.Lock()
+	err := s.feedReplicas(NewCommandFromArgs(string(ReplConf), "GETACK", "*"))
+	s.execMu.Unlock()
+	if err != nil {
+		s.logger.Println("Failed to ask replicas for acknowledgements:", err)
+	}
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	for count < numReplicas {
+		select {
+		case <-acked:
+			count, acked = s.countAcked(offset)
+		case <-expired:
+			return count
+		case <-s.ctx.Done():
+			return count
+		}
+	}
+
+	return count
+}
+
+// replicationCron runs the periodic replication tasks. A master pings its
+// replicas and drops the ones that timed out; a replica acknowledges the
+// offset it processed and drops the link to a master that timed out.
+func (s *Server) replicationCron(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	pinged := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.dropTimedOutReplicas()
+			if s.role() == slave {
+				// Sub-replicas receive the pings of the master through the forwarded stream.
+				s.ackMaster()
+				continue
+			}
+
+			if time.Since(pinged) >= s.replPingPeriod {
+				s.pingReplicas()
+				pinged = time.Now()
+			}
+		}
+	}
+}
+
+func (s *Server) pingReplicas() {
+	s.execMu.Lock()
+	defer s.execMu.Unlock()
+
+	// The pings would move the offset a failover waits for the replicas to reach.
+	if len(s.replicas) == 0 || s.pause.paused() {
+		return
+	}
+
+	err := s.feedReplicas(NewCommandFromArgs(string(Ping)))
+	if err != nil {
+		s.logger.Println("Failed to ping replicas:", err)
+	}
+}
+
+func (s *Server) dropTimedOutReplicas() {
+	s.execMu.Lock()
+	defer s.execMu.Unlock()
+
+	timeout := time.Duration(s.replTimeout.Load())
+	for _, r := range s.replicas {
+		r.mu.Lock()
+		timedOut := r.online && time.Since(r.lastAck) > timeout
+		r.mu.Unlock()
+
+		if timedOut {
+			// The replica is removed once its connection loop notices the connection is closed.
+			s.logger.Printf("Replica %s timed out\n", r.connection.RemoteAddr())
+			r.connection.Close()
+		}
+	}
+}
+
+func (s *Server) ackMaster() {
+	s.repl.mu.Lock()
+	connection := s.repl.masterConnection
+	lastIO := s.repl.masterLastIO
+	offset := s.repl.offset
+	s.repl.mu.Unlock()
+
+	if connection == nil {
+		return
+	}
+
+	if time.Since(lastIO) > time.Duration(s.replTimeout.Load()) {
+		s.logger.Println("Master timed out")
+		connection.Close()
+		return
+	}
+
+	err := NewCommandFromArgs(string(ReplConf), "ACK", strconv.Itoa(offset)).Write(connection)
+	if err != nil {
+		s.logger.Println("Failed to acknowledge the offset to master:", err)
+	}
+}
+
+func (s *Server) setMasterConnection(connection net.Conn) {
+	s.repl.mu.Lock()
+	defer s.repl.mu.Unlock()
+
+	s.repl.masterConnection = connection
+	s.repl.masterLastIO = time.Now()
+}
+
+// dropMasterConnection records the loss of the link, unless a new one replaced it already.
+func (s *Server) dropMasterConnection(connection net.Conn) {
+	s.repl.mu.Lock()
+	defer s.repl.mu.Unlock()
+
+	if s.repl.masterConnection == connection {
+		s.repl.masterConnection = nil
+	}
+}
+
+func (s *Server) startReplication() {
+	ctx, cancel := context.WithCancel(s.ctx)
+
+	s.roleMu.Lock()
+	s.stopReplication = cancel
+	s.roleMu.Unlock()
+
+	s.goTracked(func() { s.replicationLoop(ctx) })
+}
+
+// replicaOf makes the server a replica of the master at host:port or, with an
+// empty host, promotes it to a master. The dataset is kept either way: a
+// promoted replica starts a new history and keeps the old one as its secondary
+// id, and a demoted master offers its own history to the new master, so that
+// both can resynchronize partially when the histories match.
+func (s *Server) replicaOf(host string, port string) {
+	s.execMu.Lock()
+	defer s.execMu.Unlock()
+
+	wasMaster := s.role() == master
+	s.roleMu.Lock()
+	s.MasterHost, s.MasterPort = host, port
+	stop := s.stopReplication
+	s.stopReplication = nil
+	s.roleMu.Unlock()
+	s.config.Set("replicaof", host+" "+port)
+
+	if stop != nil {
+		stop()
+	}
+
+	s.repl.mu.Lock()
+	if s.repl.masterConnection != nil {
+		s.repl.masterConnection.Close()
+		s.repl.masterConnection = nil
+	}
+	if host == "" && !wasMaster {
+		s.shiftReplicationID()
+	}
+	s.repl.cachedMaster = host != ""
+	s.repl.mu.Unlock()
+
+	s.client.SetMaster(host == "")
+	s.logger.SetPrefix(fmt.Sprintf("[%s on %s:%s] ", s.role(), s.Host, s.Port))
+
+	if host == "" {
+		s.logger.Println("Promoted to master")
+		return
+	}
+
+	// The replicas resynchronize with this server once it follows the new master.
+	s.disconnectReplicas()
+	s.replicationDB = -1
+
+	s.logger.Printf("Replicating %s:%s\n", host, port)
+	s.startReplication()
+}
+
+func (s *Server) masterIO() {
+	s.repl.mu.Lock()
+	defer s.repl.mu.Unlock()
+
+	s.repl.masterLastIO = time.Now()
+}
+
+// replicationInfoSection renders the replication section of INFO.
+func (s *Server) replicationInfoSection() []string {
+	lines := []string{fmt.Sprintf("role:%s", s.role())}
+	failover := s.failoverState()
+
+	host, port := s.masterHostPort()
+	s.repl.mu.Lock()
+	if host != "" {
+		linkStatus, lastIO := "down", -1
+		if s.repl.masterConnection != nil {
+			linkStatus, lastIO = "up", int(time.Since(s.repl.masterLastIO).Seconds())
+		}
+
+		lines = append(lines,
+			fmt.Sprintf("master_host:%s", host),
+			fmt.Sprintf("master_port:%s", port),
+			fmt.Sprintf("master_link_status:%s", linkStatus),
+			fmt.Sprintf("master_last_io_seconds_ago:%d", lastIO),
+			fmt.Sprintf("slave_repl_offset:%d", s.repl.offset),
+			fmt.Sprintf("slave_priority:%d", s.replicaPriority),
+		)
+	}
+	lines = append(lines,
+		fmt.Sprintf("master_failover_state:%s", failover),
+		fmt.Sprintf("master_replid:%s", s.repl.id),
+		fmt.Sprintf("master_replid2:%s", s.repl.id2),
+		fmt.Sprintf("master_repl_offset:%d", s.repl.offset),
+		fmt.Sprintf("second_repl_offset:%d", s.repl.secondOffset),
+		"repl_backlog_active:1",
+		fmt.Sprintf("repl_backlog_size:%d", len(s.repl.backlog.buf)),
+		fmt.Sprintf("repl_backlog_first_byte_offset:%d", s.repl.offset-s.repl.backlog.histlen+1),
+		fmt.Sprintf("repl_backlog_histlen:%d", s.repl.backlog.histlen),
+	)
+	s.repl.mu.Unlock()
+
+	s.execMu.Lock()
+	lines = append(lines, fmt.Sprintf("connected_slaves:%d", len(s.replicas)))
+	for i, r := range s.replicas {
+		lines = append(lines, fmt.Sprintf("slave%d:%s", i, r.info()))
+	}
+	s.execMu.Unlock()
+
+	return lines
+}
+
+// disconnectReplicas closes the connections of every replica, each is removed
+// once its connection loop notices. It must be called with execMu held.
+func (s *Server) disconnectReplicas() {
+	for _, r := range s.replicas {
+		r.connection.Close()
+	}
+}
+
+func (s *Server) removeReplica(r *replica) {
+	s.execMu.Lock()
+	defer s.execMu.Unlock()
+
+	for i, existing := range s.replicas {
+		if existing == r {
+			s.replicas = append(s.replicas[:i], s.replicas[i+1:]...)
+			return
+		}
+	}
+}
+
+// sendRDB transfers the snapshot as a bulk string. Diskless transfers to replicas
+// that understand it are delimited by a random EOF mark instead of a length,
+// so that the RDB can be streamed as it is encoded.
+func (s *Server) sendRDB(session *Session, connection net.Conn, snapshot Snapshot) error {
+	diskless := s.replDisklessSync.Load()
+	if diskless && session.replicaCapaEOF {
+		mark := randomHex(40)
+		writer := bufio.NewWriter(connection)
+		fmt.Fprintf(writer, "$EOF:%s\r\n", mark)
+		err := WriteRDB(writer, snapshot)
+		if err != nil {
+			return err
+		}
+		writer.WriteString(mark)
+		return writer.Flush()
+	}
+
+	if diskless {
+		var buf bytes.Buffer
+		err := WriteRDB(&buf, snapshot)
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintf(connection, "$%d\r\n%s", buf.Len(), buf.Bytes())
+		return err
+	}
+
+	// Wait for any save in progress, so that the file is not replaced while it is sent.
+	dirty, err := s.waitBeginSave(nil)
+	if err != nil {
+		return err
+	}
+
+	err = s.sendRDBFile(connection, snapshot)
+	s.endSave(dirty, err)
+
+	return err
+}
+
+func (s *Server) sendRDBFile(connection net.Conn, snapshot Snapshot) error {
+	err := writeRDBFile(s.rdbPath(), snapshot)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(s.rdbPath())
+	if err != nil {
+		return fmt.Errorf("failed to open RDB file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat RDB file: %w", err)
+	}
+
+	_, err = fmt.Fprintf(connection, "$%d\r\n", info.Size())
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(connection, file)
+	return err
+}
+
+// receiveRDB reads the RDB sent by the master after FULLRESYNC, in either transfer format.
+func (s *Server) receiveRDB(resp *Resp) (Snapshot, error) {
+	// The master sends newlines to keep the link alive while it prepares the RDB.
+	line := ""
+	for line == "" {
+		read, err := resp.reader.ReadString('\n')
+		if err != nil {
+			return Snapshot{}, fmt.Errorf("invalid RDB file transfer response: %w", err)
+		}
+		line = strings.TrimRight(read, "\r\n")
+	}
+
+	if !strings.HasPrefix(line, "$") {
+		return Snapshot{}, fmt.Errorf("invalid RDB file transfer response: %q", line)
+	}
+
+	if mark, found := strings.CutPrefix(line, "$EOF:"); found {
+		snapshot, err := ReadRDB(resp.reader)
+		if err != nil {
+			return Snapshot{}, err
+		}
+
+		trailer := make([]byte, len(mark))
+		_, err = io.ReadFull(resp.reader, trailer)
+		if err != nil {
+			return Snapshot{}, fmt.Errorf("failed to read RDB EOF mark: %w", err)
+		}
+		if string(trailer) != mark {
+			return Snapshot{}, fmt.Errorf("RDB EOF mark mismatch")
+		}
+
+		return snapshot, nil
+	}
+
+	size, err := strconv.ParseInt(line[1:], 10, 64)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("invalid RDB size %q: %w", line, err)
+	}
+
+	payload := io.LimitReader(resp.reader, size)
+	snapshot, err := ReadRDB(payload)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	// Skip anything following the checksum, so that the stream continues right after the RDB.
+	_, err = io.Copy(io.Discard, payload)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("failed to read RDB file: %w", err)
+	}
+
+	return snapshot, nil
+}
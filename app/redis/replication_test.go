@@ -0,0 +1,187 @@
+package redis_test
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+// replicate makes the server of replica follow the server of master, and waits for the link to be up.
+func replicate(t *testing.T, replica *testConn, master *testConn) {
+	host, port, _ := net.SplitHostPort(master.address)
+	assert.Equal(t, okReply, replica.do("REPLICAOF", host, port))
+	assert.Eventually(t, func() bool {
+		return strings.Contains(replica.do("INFO", "replication").Bulk, "master_link_status:up")
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+// eventuallyGet waits for the key to have the value on the server of c.
+func eventuallyGet(t *testing.T, c *testConn, key string, value string) {
+	assert.Eventually(t, func() bool {
+		reply := c.do("GET", key)
+		return reply.Type == redis.Bulk && reply.Bulk == value
+	}, 5*time.Second, 10*time.Millisecond, key)
+}
+
+func TestReplicaOf(t *testing.T) {
+	master := startServer(t)
+	replica := startServer(t)
+	assert.Equal(t, okReply, master.do("SET", "synced", "1"))
+
+	replicate(t, replica, master)
+	eventuallyGet(t, replica, "synced", "1")
+	assert.Contains(t, replica.do("INFO", "replication").Bulk, "role:slave")
+
+	host, port, _ := net.SplitHostPort(master.address)
+	assert.Equal(t, redis.Value{Type: redis.SimpleString, SimpleString: "OK Already connected to specified master"}, replica.do("REPLICAOF", host, port))
+
+	assert.Equal(t, okReply, master.do("SET", "streamed", "2"))
+	eventuallyGet(t, replica, "streamed", "2")
+
+	assert.Equal(t, okReply, replica.do("REPLICAOF", "NO", "ONE"))
+	assert.Contains(t, replica.do("INFO", "replication").Bulk, "role:master")
+	assert.Equal(t, redis.Value{Type: redis.Bulk, Bulk: "2"}, replica.do("GET", "streamed"))
+	assert.Equal(t, okReply, replica.do("SET", "promoted", "3"))
+
+	assert.Equal(t, okReply, master.do("SET", "after", "4"))
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, redis.Value{Type: redis.NullBulk}, replica.do("GET", "after"))
+}
+
+func TestReplicaOfArguments(t *testing.T) {
+	server := startServer(t)
+
+	assert.Equal(t, redis.Value{Type: redis.Error, Error: "ERR Invalid master port"}, server.do("REPLICAOF", "127.0.0.1", "port"))
+	assert.Equal(t, redis.Value{Type: redis.Error, Error: "ERR wrong number of arguments for 'replicaof' command"}, server.do("REPLICAOF", "NO"))
+	assert.Equal(t, okReply, server.do("SLAVEOF", "no", "one"))
+	assert.Contains(t, server.do("INFO", "replication").Bulk, "role:master")
+}
+
+func TestReadOnlyReplica(t *testing.T) {
+	tests := []struct {
+		name     string
+		readOnly bool
+		want     redis.Value
+	}{
+		{
+			name:     "read-only",
+			readOnly: true,
+			want:     redis.Value{Type: redis.Error, Error: "READONLY You can't write against a read only replica."},
+		},
+		{
+			name:     "writable",
+			readOnly: false,
+			want:     okReply,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			master := startServer(t)
+			replica := startServer(t, redis.WithReplicaReadOnly(tt.readOnly))
+			replicate(t, replica, master)
+
+			assert.Equal(t, tt.want, replica.do("SET", "local", "1"))
+			assert.Equal(t, redis.Value{Type: redis.Number, Number: 0}, replica.do("EXISTS", "key"))
+
+			// Writes from the master are applied either way.
+			assert.Equal(t, okReply, master.do("SET", "key", "value"))
+			eventuallyGet(t, replica, "key", "value")
+		})
+	}
+}
+
+func TestReplConf(t *testing.T) {
+	server := startServer(t)
+
+	assert.Equal(t, redis.Value{Type: redis.Error, Error: "ERR wrong number of arguments for 'replconf' command"}, server.do("REPLCONF"))
+	assert.Equal(t, okReply, server.do("REPLCONF", "listening-port", "6380"))
+	assert.Equal(t, redis.Value{Type: redis.Array, Array: []redis.Value{
+		{Type: redis.Bulk, Bulk: "REPLCONF"},
+		{Type: redis.Bulk, Bulk: "ACK"},
+		{Type: redis.Bulk, Bulk: "0"},
+	}}, server.do("replconf", "getack", "*"))
+}
+
+// fakeMaster answers the handshake of a replica with a full resync, writing what precedes
+// the RDB, then the RDB of snapshot, and keeps the link open until the test ends.
+func fakeMaster(t *testing.T, preamble string, snapshot redis.Snapshot) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	var rdb bytes.Buffer
+	assert.NoError(t, redis.WriteRDB(&rdb, snapshot))
+
+	go func() {
+		connection, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer connection.Close()
+
+		resp := redis.NewResp(connection)
+		for {
+			value, err := resp.Read()
+			if err != nil {
+				return
+			}
+
+			switch redis.NewCommand(value).Type {
+			case redis.Ping:
+				connection.Write([]byte("+PONG\r\n"))
+			case redis.PSync:
+				fmt.Fprintf(connection, "+FULLRESYNC %s 0\r\n%s$%d\r\n", strings.Repeat("a", 40), preamble, rdb.Len())
+				connection.Write(rdb.Bytes())
+			default:
+				connection.Write([]byte("+OK\r\n"))
+			}
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestReplicaKeepalives(t *testing.T) {
+	snapshot := redis.Snapshot{Databases: []map[string]redis.Entry{{"key": {Value: "value"}}}}
+	address := fakeMaster(t, "\n\n\n", snapshot)
+
+	replica := startServer(t)
+	host, port, _ := net.SplitHostPort(address)
+	assert.Equal(t, okReply, replica.do("REPLICAOF", host, port))
+	eventuallyGet(t, replica, "key", "value")
+}
+
+func TestPartialResyncKeepsDatabase(t *testing.T) {
+	master := startServer(t)
+	replica := startServer(t)
+	replicate(t, replica, master)
+
+	assert.Equal(t, okReply, master.do("SELECT", "1"))
+	assert.Equal(t, okReply, master.do("SET", "before", "1"))
+	assert.Equal(t, okReply, replica.do("SELECT", "1"))
+	eventuallyGet(t, replica, "before", "1")
+
+	// The replica reconnects, and continues from the backlog of the master.
+	linkID := func() string {
+		id, _, _ := strings.Cut(master.do("CLIENT", "LIST", "TYPE", "replica").Bulk, " ")
+		return id
+	}
+	killed := linkID()
+	assert.Equal(t, redis.Value{Type: redis.Number, Number: 1}, master.do("CLIENT", "KILL", "TYPE", "replica"))
+	assert.Eventually(t, func() bool {
+		id := linkID()
+		return id != "" && id != killed
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, okReply, master.do("SET", "after", "2"))
+	eventuallyGet(t, replica, "after", "2")
+	assert.Equal(t, okReply, replica.do("SELECT", "0"))
+	assert.Equal(t, redis.Value{Type: redis.NullBulk}, replica.do("GET", "after"))
+}
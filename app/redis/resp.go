@@ -27,6 +27,7 @@ const (
 	SimpleString ValueType = "string"
 	Raw          ValueType = "raw"
 	Number       ValueType = "number"
+	Error        ValueType = "error"
 )
 
 type Value struct {
@@ -36,6 +37,7 @@ type Value struct {
 	Bulk         string
 	Array        []Value
 	Raw          string
+	Error        string
 }
 
 func (v Value) Format() string {
@@ -56,6 +58,8 @@ func (v Value) Format() string {
 		return FormatSimpleString(v.SimpleString)
 	case Raw:
 		return v.Raw
+	case Error:
+		return FormatError(v.Error)
 	}
 
 	panic("Unknown value type")
@@ -68,12 +72,33 @@ func (v Value) Write(w io.Writer) error {
 
 type Resp struct {
 	reader *bufio.Reader
+	// raw collects the bytes consumed while ReadRaw reads a value.
+	raw []byte
 }
 
 func NewResp(r io.Reader) *Resp {
 	return &Resp{reader: bufio.NewReader(r)}
 }
 
+var ErrInvalidResp = errors.New("invalid RESP")
+
+const (
+	// MaxBulkLength bounds the bulk strings read, like the default proto-max-bulk-len of Redis.
+	MaxBulkLength = 512 << 20
+	// MaxArrayLength bounds the number of elements of the arrays read.
+	MaxArrayLength = 1<<31 - 1
+
+	// respReadChunk is how much is allocated at once for a long bulk string, or how many
+	// elements for a long array, so that memory is only taken as the data arrives.
+	respReadChunk = 1 << 16
+)
+
+// Buffered returns how many bytes were received but not read yet, and how many more the buffer holds.
+func (r *Resp) Buffered() (int, int) {
+	buffered := r.reader.Buffered()
+	return buffered, r.reader.Size() - buffered
+}
+
 func (r *Resp) Read() (Value, error) {
 	buf, err := r.reader.Peek(1)
 	if err != nil {
@@ -91,11 +116,32 @@ func (r *Resp) Read() (Value, error) {
 		return r.readBulk()
 	case rSimpleString:
 		return r.readSimpleString()
-	default:
-		fmt.Println("Unknown type")
+	case rInteger:
+		return r.readInteger()
+	case rError:
+		return r.readError()
 	}
 
-	return Value{}, nil
+	return Value{}, fmt.Errorf("%w: unknown type %q", ErrInvalidResp, buf[0])
+}
+
+// ReadRaw reads a value like Read, also returning the exact bytes it was encoded with.
+func (r *Resp) ReadRaw() (Value, []byte, error) {
+	r.raw = []byte{}
+	defer func() { r.raw = nil }()
+
+	value, err := r.Read()
+	return value, r.raw, err
+}
+
+// unexpectedEOF turns an EOF in the middle of a value into io.ErrUnexpectedEOF,
+// so that callers can tell a closed connection from a truncated value.
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
 }
 
 func (r *Resp) readLine() ([]byte, error) {
@@ -113,6 +159,10 @@ func (r *Resp) readLine() ([]byte, error) {
 		}
 	}
 
+	if r.raw != nil {
+		r.raw = append(r.raw, line...)
+	}
+
 	return line[:len(line)-2], nil
 }
 
@@ -121,21 +171,36 @@ func (r *Resp) readBulk() (Value, error) {
 
 	typeLine, err := r.readLine()
 	if err != nil {
-		return Value{}, fmt.Errorf("failed to read line while reading bulk: %w", err)
+		return Value{}, fmt.Errorf("failed to read line while reading bulk: %w", unexpectedEOF(err))
 	}
 
-	len, err := r.parseInteger(typeLine[1:])
+	length, err := r.parseInteger(typeLine[1:])
 	if err != nil {
-		panic(err)
+		return Value{}, fmt.Errorf("%w: invalid bulk length: %w", ErrInvalidResp, err)
+	}
+	if length < 0 {
+		return Value{Type: NullBulk}, nil
+	}
+	if length > MaxBulkLength {
+		return Value{}, fmt.Errorf("%w: invalid bulk length %d", ErrInvalidResp, length)
 	}
 
-	contentLine, err := r.readLine()
+	// The content is read by its length, it can contain CRLF itself.
+	content := make([]byte, min(length+2, respReadChunk))
+	_, err = io.ReadFull(r.reader, content)
+	for err == nil && int64(len(content)) < length+2 {
+		chunk := min(int(length+2)-len(content), respReadChunk)
+		content = append(content, make([]byte, chunk)...)
+		_, err = io.ReadFull(r.reader, content[len(content)-chunk:])
+	}
 	if err != nil {
-		return Value{}, fmt.Errorf("failed to read line while reading bulk: %w", err)
+		return Value{}, fmt.Errorf("failed to read bulk content: %w", unexpectedEOF(err))
+	}
+	if r.raw != nil {
+		r.raw = append(r.raw, content...)
 	}
 
-	bulk := contentLine[0:len]
-	v.Bulk = string(bulk)
+	v.Bulk = string(content[:length])
 	return v, nil
 }
 
@@ -144,38 +209,60 @@ func (r *Resp) readSimpleString() (Value, error) {
 
 	contentLine, err := r.readLine()
 	if err != nil {
-		return Value{}, fmt.Errorf("failed to read line while reading string: %w", err)
+		return Value{}, fmt.Errorf("failed to read line while reading string: %w", unexpectedEOF(err))
 	}
 
 	v.SimpleString = string(contentLine[1:])
 	return v, nil
 }
 
+func (r *Resp) readInteger() (Value, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return Value{}, fmt.Errorf("failed to read line while reading integer: %w", unexpectedEOF(err))
+	}
+
+	n, err := r.parseInteger(line[1:])
+	if err != nil {
+		return Value{}, fmt.Errorf("%w: invalid integer: %w", ErrInvalidResp, err)
+	}
+
+	return Value{Type: Number, Number: int(n)}, nil
+}
+
+func (r *Resp) readError() (Value, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return Value{}, fmt.Errorf("failed to read line while reading error: %w", unexpectedEOF(err))
+	}
+
+	return Value{Type: Error, Error: string(line[1:])}, nil
+}
+
 func (r *Resp) readArray() (Value, error) {
 	v := Value{Type: Array}
 
 	typeLine, err := r.readLine()
 	if err != nil {
-		return Value{}, fmt.Errorf("failed to read line while reading array: %w", err)
+		return Value{}, fmt.Errorf("failed to read line while reading array: %w", unexpectedEOF(err))
 	}
 
-	len, err := r.parseInteger(typeLine[1:])
+	length, err := r.parseInteger(typeLine[1:])
 	if err != nil {
-		panic(err)
+		return Value{}, fmt.Errorf("%w: invalid array length: %w", ErrInvalidResp, err)
+	}
+	if length < 0 || length > MaxArrayLength {
+		return Value{}, fmt.Errorf("%w: invalid multibulk length %d", ErrInvalidResp, length)
 	}
 
-	arr := make([]Value, len)
-	for i := int64(0); i < len; i++ {
+	arr := make([]Value, 0, min(length, respReadChunk))
+	for i := int64(0); i < length; i++ {
 		val, err := r.Read()
 		if err != nil {
-			if errors.Is(err, io.EOF) {
-				return v, nil
-			}
-
-			panic(err)
+			return Value{}, unexpectedEOF(err)
 		}
 
-		arr[i] = val
+		arr = append(arr, val)
 	}
 
 	v.Array = arr
@@ -199,6 +286,10 @@ func FormatSimpleString(input string) string {
 	return fmt.Sprintf("+%s\r\n", input)
 }
 
+func FormatError(input string) string {
+	return fmt.Sprintf("-%s\r\n", input)
+}
+
 func FormatNullBulkString() string {
 	return "$-1\r\n"
 }
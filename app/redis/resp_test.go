@@ -3,6 +3,7 @@ package redis_test
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"testing"
 
 	"github.com/codecrafters-io/redis-starter-go/app/redis"
@@ -84,6 +85,14 @@ func TestResp(t *testing.T) {
 			input:    redis.FormatSimpleString("hi there"),
 			expected: redis.Value{Type: redis.SimpleString, SimpleString: "hi there"},
 		},
+		"integer": {
+			input:    redis.FormatNumber(-42),
+			expected: redis.Value{Type: redis.Number, Number: -42},
+		},
+		"error": {
+			input:    redis.FormatError("ERR no such key"),
+			expected: redis.Value{Type: redis.Error, Error: "ERR no such key"},
+		},
 		"REPLCONF ACK 0": {
 			input: "*3\r\n$8\r\nreplconf\r\n$6\r\ngetack\r\n$1\r\n*\r\n",
 			expected: redis.Value{Type: redis.Array, Array: []redis.Value{
@@ -105,3 +114,37 @@ func TestResp(t *testing.T) {
 		})
 	}
 }
+
+func TestRespInvalidLengths(t *testing.T) {
+	for _, input := range []string{
+		"$9223372036854775807\r\n",
+		"$536870913\r\n",
+		"*9223372036854775807\r\n",
+		"*-2\r\n",
+	} {
+		resp := redis.NewResp(bytes.NewReader([]byte(input)))
+		_, err := resp.Read()
+		assert.ErrorIs(t, err, redis.ErrInvalidResp, input)
+	}
+
+	// A length that is allowed but not followed by the data fails once the data runs out.
+	resp := redis.NewResp(bytes.NewReader([]byte("*2147483647\r\n$536870912\r\nabc")))
+	_, err := resp.Read()
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestRespReadRaw(t *testing.T) {
+	// The second length is not in its canonical form, re-encoding the value would not reproduce it.
+	first := "*2\r\n$4\r\nECHO\r\n$+02\r\nhi\r\n"
+	second := redis.FormatSimpleString("PING")
+	resp := redis.NewResp(bytes.NewReader([]byte(first + second)))
+
+	value, raw, err := resp.ReadRaw()
+	assert.NoError(t, err)
+	assert.Equal(t, "hi", value.Array[1].Bulk)
+	assert.Equal(t, first, string(raw))
+
+	_, raw, err = resp.ReadRaw()
+	assert.NoError(t, err)
+	assert.Equal(t, second, string(raw))
+}
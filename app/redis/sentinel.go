@@ -0,0 +1,793 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	DefaultSentinelDownAfter       = 30 * time.Second
+	DefaultSentinelFailoverTimeout = 3 * time.Minute
+
+	sentinelProbePeriod  = time.Second
+	sentinelHelloPeriod  = 2 * time.Second
+	sentinelQueryTimeout = time.Second
+)
+
+// SentinelMaster is a master monitored by a sentinel, as configured by `sentinel monitor`.
+type SentinelMaster struct {
+	Name   string
+	Host   string
+	Port   string
+	Quorum int
+}
+
+// ParseSentinelMonitor parses the "<name> <host> <port> <quorum>" format of the `sentinel monitor` option.
+func ParseSentinelMonitor(spec string) (SentinelMaster, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 4 {
+		return SentinelMaster{}, fmt.Errorf("invalid sentinel monitor %q: expected <name> <host> <port> <quorum>", spec)
+	}
+
+	if _, err := strconv.Atoi(fields[2]); err != nil {
+		return SentinelMaster{}, fmt.Errorf("invalid sentinel monitor port %q", fields[2])
+	}
+
+	quorum, err := strconv.Atoi(fields[3])
+	if err != nil || quorum < 1 {
+		return SentinelMaster{}, fmt.Errorf("invalid sentinel monitor quorum %q", fields[3])
+	}
+
+	return SentinelMaster{Name: fields[0], Host: fields[1], Port: fields[2], Quorum: quorum}, nil
+}
+
+// Sentinel monitors masters and their replicas, and promotes a replica when a
+// master is agreed to be down by a quorum of sentinels.
+type Sentinel struct {
+	Host string
+	Port string
+
+	id              string
+	downAfter       time.Duration
+	failoverTimeout time.Duration
+	// peers are the addresses of the other sentinels monitoring the same masters.
+	peers []string
+
+	logger *log.Logger
+
+	mu           sync.Mutex
+	currentEpoch int64
+	masters      map[string]*sentinelMaster
+
+	// links are the connections to instances and peers. They are only used by the cron goroutine.
+	links *links
+
+	// goroutines tracks the goroutines ListenAndServe waits for, see goTracked.
+	goroutines sync.WaitGroup
+}
+
+type sentinelMaster struct {
+	SentinelMaster
+	// ips are the addresses Host resolved to when it was set, which replicas may report instead of it.
+	ips         []net.IP
+	configEpoch int64
+	lastOK      time.Time
+	odown       bool
+	replicas    map[string]*sentinelReplica
+
+	// vote is the sentinel this sentinel voted for as the failover leader in voteEpoch.
+	vote      string
+	voteEpoch int64
+	// failoverStart is when the last failover was attempted, here or by a voted-for leader.
+	failoverStart time.Time
+}
+
+func (m *sentinelMaster) address() string {
+	return net.JoinHostPort(m.Host, m.Port)
+}
+
+func (m *sentinelMaster) sdown(downAfter time.Duration) bool {
+	return time.Since(m.lastOK) > downAfter
+}
+
+type sentinelReplica struct {
+	host     string
+	port     string
+	lastOK   time.Time
+	role     role
+	offset   int
+	priority int
+}
+
+func (r *sentinelReplica) address() string {
+	return net.JoinHostPort(r.host, r.port)
+}
+
+func NewSentinel(host string, port string, opts ...func(*Sentinel)) *Sentinel {
+	sentinel := &Sentinel{
+		Host:            host,
+		Port:            port,
+		id:              randomHex(40),
+		downAfter:       DefaultSentinelDownAfter,
+		failoverTimeout: DefaultSentinelFailoverTimeout,
+		masters:         map[string]*sentinelMaster{},
+		links:           newLinks(sentinelQueryTimeout),
+	}
+
+	for _, opt := range opts {
+		opt(sentinel)
+	}
+	sentinel.logger = log.New(os.Stdout, fmt.Sprintf("[sentinel on %s:%s] ", sentinel.Host, sentinel.Port), 0)
+
+	return sentinel
+}
+
+func WithSentinelMonitor(master SentinelMaster) func(*Sentinel) {
+	return func(s *Sentinel) {
+		s.masters[master.Name] = &sentinelMaster{
+			SentinelMaster: master,
+			ips:            resolveHost(master.Host),
+			lastOK:         time.Now(),
+			replicas:       map[string]*sentinelReplica{},
+		}
+	}
+}
+
+// WithSentinelDownAfter sets how long an instance may not answer before it is subjectively down.
+func WithSentinelDownAfter(downAfter time.Duration) func(*Sentinel) {
+	return func(s *Sentinel) {
+		s.downAfter = downAfter
+	}
+}
+
+// WithSentinelFailoverTimeout bounds how long a promotion may take, and spaces out failover attempts.
+func WithSentinelFailoverTimeout(timeout time.Duration) func(*Sentinel) {
+	return func(s *Sentinel) {
+		s.failoverTimeout = timeout
+	}
+}
+
+func WithSentinelPeers(peers []string) func(*Sentinel) {
+	return func(s *Sentinel) {
+		s.peers = peers
+	}
+}
+
+func (s *Sentinel) Address() string {
+	return fmt.Sprintf("%s:%s", s.Host, s.Port)
+}
+
+func (s *Sentinel) ListenAndServe(ctx context.Context) error {
+	s.logger.Printf("Starting the sentinel %s\n", s.id)
+
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	listenConfig := net.ListenConfig{}
+	listener, err := listenConfig.Listen(ctx, protocol, s.Address())
+	if err != nil {
+		return fmt.Errorf("failed to listen on address: %s, %w", s.Address(), err)
+	}
+	defer listener.Close()
+
+	s.goTracked(func() { s.serveLoop(ctx, listener) })
+	s.goTracked(func() { s.cron(ctx) })
+
+	<-ctx.Done()
+
+	s.logger.Println("Shutting the sentinel down")
+	// Closing the listener stops the accept loop, the connections are closed as ctx is done.
+	listener.Close()
+	s.goroutines.Wait()
+
+	return nil
+}
+
+// goTracked runs f in a goroutine ListenAndServe waits for before returning.
+func (s *Sentinel) goTracked(f func()) {
+	s.goroutines.Add(1)
+	go func() {
+		defer s.goroutines.Done()
+		f()
+	}()
+}
+
+func (s *Sentinel) serveLoop(ctx context.Context, listener net.Listener) {
+	for {
+		connection, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			s.logger.Println("error accepting the connection:", err)
+			continue
+		}
+
+		s.goTracked(func() { s.handleLoop(ctx, connection) })
+	}
+}
+
+func (s *Sentinel) handleLoop(ctx context.Context, connection net.Conn) {
+	defer connection.Close()
+	defer closeOnDone(ctx, connection)()
+
+	resp := NewResp(connection)
+	for {
+		value, err := resp.Read()
+		if err != nil {
+			return
+		}
+		if value.Type != Array || len(value.Array) == 0 {
+			return
+		}
+
+		err = s.handle(NewCommand(value)).Write(connection)
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (s *Sentinel) handle(cmd Command) Value {
+	switch cmd.Type {
+	case Ping:
+		return Value{Type: SimpleString, SimpleString: "PONG"}
+	case Info:
+		return Value{Type: Bulk, Bulk: s.info()}
+	case SentinelCommand:
+		if len(cmd.Args) == 0 {
+			return wrongArgumentsError(cmd)
+		}
+		return s.handleSentinel(strings.ToLower(cmd.Args[0]), cmd.Args[1:])
+	}
+
+	return errorValue("ERR unknown command '%s'", cmd.Type)
+}
+
+func (s *Sentinel) handleSentinel(subcommand string, args []string) Value {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch subcommand {
+	case "myid":
+		return Value{Type: Bulk, Bulk: s.id}
+
+	case "get-master-addr-by-name":
+		if len(args) != 1 {
+			return errorValue("ERR wrong number of arguments for 'sentinel get-master-addr-by-name'")
+		}
+
+		m, found := s.masters[args[0]]
+		if !found {
+			return Value{Type: NullBulk}
+		}
+		return Value{Type: Array, Array: []Value{{Type: Bulk, Bulk: m.Host}, {Type: Bulk, Bulk: m.Port}}}
+
+	case "masters":
+		names := make([]string, 0, len(s.masters))
+		for name := range s.masters {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		values := []Value{}
+		for _, name := range names {
+			values = append(values, s.masterFields(s.masters[name]))
+		}
+		return Value{Type: Array, Array: values}
+
+	case "master":
+		if len(args) != 1 {
+			return errorValue("ERR wrong number of arguments for 'sentinel master'")
+		}
+
+		m, found := s.masters[args[0]]
+		if !found {
+			return errorValue("ERR No such master with that name")
+		}
+		return s.masterFields(m)
+
+	case "replicas", "slaves":
+		if len(args) != 1 {
+			return errorValue("ERR wrong number of arguments for 'sentinel replicas'")
+		}
+
+		m, found := s.masters[args[0]]
+		if !found {
+			return errorValue("ERR No such master with that name")
+		}
+
+		values := []Value{}
+		for _, r := range m.replicas {
+			flags := string(r.role)
+			if time.Since(r.lastOK) > s.downAfter {
+				flags += ",s_down"
+			}
+			values = append(values, bulkFields(
+				"name", r.address(),
+				"ip", r.host,
+				"port", r.port,
+				"flags", flags,
+				"slave-repl-offset", strconv.Itoa(r.offset),
+				"slave-priority", strconv.Itoa(r.priority),
+			))
+		}
+		return Value{Type: Array, Array: values}
+
+	case "is-master-down-by-addr":
+		if len(args) != 4 {
+			return errorValue("ERR wrong number of arguments for 'sentinel is-master-down-by-addr'")
+		}
+
+		epoch, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return errorValue("ERR invalid epoch")
+		}
+
+		return s.isMasterDown(args[0], args[1], epoch, args[3])
+
+	case "hello":
+		if len(args) != 4 {
+			return errorValue("ERR wrong number of arguments for 'sentinel hello'")
+		}
+
+		epoch, err := strconv.ParseInt(args[3], 10, 64)
+		if err != nil {
+			return errorValue("ERR invalid epoch")
+		}
+
+		s.hello(args[0], args[1], args[2], epoch)
+		return Value{Type: SimpleString, SimpleString: "OK"}
+	}
+
+	return errorValue("ERR unknown sentinel subcommand '%s'", subcommand)
+}
+
+func (s *Sentinel) masterFields(m *sentinelMaster) Value {
+	flags := "master"
+	if m.sdown(s.downAfter) {
+		flags += ",s_down"
+	}
+	if m.odown {
+		flags += ",o_down"
+	}
+
+	return bulkFields(
+		"name", m.Name,
+		"ip", m.Host,
+		"port", m.Port,
+		"flags", flags,
+		"num-slaves", strconv.Itoa(len(m.replicas)),
+		"num-other-sentinels", strconv.Itoa(len(s.peers)),
+		"quorum", strconv.Itoa(m.Quorum),
+		"config-epoch", strconv.FormatInt(m.configEpoch, 10),
+		"down-after-milliseconds", strconv.FormatInt(s.downAfter.Milliseconds(), 10),
+		"failover-timeout", strconv.FormatInt(s.failoverTimeout.Milliseconds(), 10),
+	)
+}
+
+func bulkFields(fields ...string) Value {
+	values := make([]Value, len(fields))
+	for i, field := range fields {
+		values[i] = Value{Type: Bulk, Bulk: field}
+	}
+
+	return Value{Type: Array, Array: values}
+}
+
+func (s *Sentinel) info() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lines := []string{"# Sentinel", fmt.Sprintf("sentinel_masters:%d", len(s.masters))}
+	i := 0
+	for _, m := range s.masters {
+		status := "ok"
+		if m.odown {
+			status = "odown"
+		} else if m.sdown(s.downAfter) {
+			status = "sdown"
+		}
+
+		lines = append(lines, fmt.Sprintf("master%d:name=%s,status=%s,address=%s,slaves=%d,sentinels=%d",
+			i, m.Name, status, m.address(), len(m.replicas), len(s.peers)+1))
+		i++
+	}
+
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
+
+// isMasterDown answers another sentinel asking whether the master is down. When
+// runID is not "*", the sentinel also asks for a vote to lead the failover in
+// epoch; the first candidate of an epoch gets the vote. It must be called with mu held.
+func (s *Sentinel) isMasterDown(host string, port string, epoch int64, runID string) Value {
+	var m *sentinelMaster
+	for _, candidate := range s.masters {
+		if candidate.Host == host && candidate.Port == port {
+			m = candidate
+		}
+	}
+
+	down := 0
+	if m != nil && m.sdown(s.downAfter) {
+		down = 1
+	}
+
+	leader, leaderEpoch := "*", int64(0)
+	if m != nil && runID != "*" {
+		s.currentEpoch = max(s.currentEpoch, epoch)
+		if m.voteEpoch < epoch {
+			m.vote, m.voteEpoch = runID, epoch
+			// Give the leader the time to fail over before trying it here.
+			m.failoverStart = time.Now()
+			s.logger.Printf("Voted for %s to fail over %s in epoch %d\n", runID, m.Name, epoch)
+		}
+		leader, leaderEpoch = m.vote, m.voteEpoch
+	}
+
+	return Value{Type: Array, Array: []Value{
+		{Type: Number, Number: down},
+		{Type: Bulk, Bulk: leader},
+		{Type: Number, Number: int(leaderEpoch)},
+	}}
+}
+
+// hello adopts the configuration of a master announced by another sentinel,
+// if it is newer than the known one. It must be called with mu held.
+func (s *Sentinel) hello(name string, host string, port string, epoch int64) {
+	m, found := s.masters[name]
+	if !found || epoch <= m.configEpoch {
+		return
+	}
+
+	s.logger.Printf("Master %s moved to %s:%s in epoch %d\n", name, host, port, epoch)
+	s.switchMaster(m, host, port, epoch)
+	s.currentEpoch = max(s.currentEpoch, epoch)
+}
+
+// switchMaster makes the replica at host:port the master, and the former master one of its replicas.
+// It must be called with mu held.
+func (s *Sentinel) switchMaster(m *sentinelMaster, host string, port string, epoch int64) {
+	delete(m.replicas, net.JoinHostPort(host, port))
+	m.replicas[m.address()] = &sentinelReplica{host: m.Host, port: m.Port, role: slave, priority: DefaultReplicaPriority}
+
+	m.Host, m.Port = host, port
+	m.ips = resolveHost(host)
+	m.configEpoch = epoch
+	m.lastOK = time.Now()
+	m.odown = false
+}
+
+// cron probes the instances, detects failures and runs failovers.
+func (s *Sentinel) cron(ctx context.Context) {
+	ticker := time.NewTicker(sentinelProbePeriod)
+	defer ticker.Stop()
+
+	helloed := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		s.mu.Lock()
+		masters := make([]*sentinelMaster, 0, len(s.masters))
+		for _, m := range s.masters {
+			masters = append(masters, m)
+		}
+		s.mu.Unlock()
+
+		for _, m := range masters {
+			s.probe(m)
+			s.checkMaster(m)
+		}
+
+		if time.Since(helloed) >= sentinelHelloPeriod {
+			s.sendHellos(masters)
+			helloed = time.Now()
+		}
+	}
+}
+
+// probe pings the master and reads the INFO of the master and its replicas,
+// discovering replicas and putting back in line instances that believe to be
+// a master while they are not.
+func (s *Sentinel) probe(m *sentinelMaster) {
+	s.mu.Lock()
+	masterAddress := m.address()
+	replicas := make([]*sentinelReplica, 0, len(m.replicas))
+	for _, r := range m.replicas {
+		replicas = append(replicas, r)
+	}
+	s.mu.Unlock()
+
+	pong, err := s.links.query(masterAddress, "PING")
+	info, infoErr := s.queryInfo(masterAddress)
+
+	s.mu.Lock()
+	if err == nil && pong.Type == SimpleString && masterAddress == m.address() {
+		m.lastOK = time.Now()
+	}
+	if infoErr == nil && info["role"] == string(master) && masterAddress == m.address() {
+		for key, value := range info {
+			if !strings.HasPrefix(key, "slave") || strings.HasPrefix(key, "slave_") {
+				continue
+			}
+
+			fields := parseInfoFields(value)
+			address := net.JoinHostPort(fields["ip"], fields["port"])
+			if _, found := m.replicas[address]; !found {
+				s.logger.Printf("Discovered replica %s of %s\n", address, m.Name)
+				m.replicas[address] = &sentinelReplica{host: fields["ip"], port: fields["port"], role: slave, priority: DefaultReplicaPriority}
+			}
+		}
+	}
+	masterUp := !m.sdown(s.downAfter)
+	s.mu.Unlock()
+
+	for _, r := range replicas {
+		info, err := s.queryInfo(r.address())
+		if err != nil {
+			continue
+		}
+
+		s.mu.Lock()
+		r.lastOK = time.Now()
+		r.role = role(info["role"])
+		r.offset, _ = strconv.Atoi(info["slave_repl_offset"])
+		r.priority = DefaultReplicaPriority
+		if priority, err := strconv.Atoi(info["slave_priority"]); err == nil {
+			r.priority = priority
+		}
+		host, port, ips := m.Host, m.Port, m.ips
+		s.mu.Unlock()
+
+		// A former master coming back, or a replica of an older configuration, is
+		// reconfigured, but only when the master it should follow is reachable.
+		wrongMaster := r.role == master || info["master_port"] != port || !sameHost(info["master_host"], host, ips)
+		if masterUp && wrongMaster {
+			s.logger.Printf("Reconfiguring %s as a replica of %s:%s\n", r.address(), host, port)
+			s.links.query(r.address(), "REPLICAOF", host, port)
+		}
+	}
+}
+
+// sameHost reports whether the host an instance reported is host, or one of the addresses it resolved to.
+func sameHost(reported string, host string, ips []net.IP) bool {
+	if reported == host {
+		return true
+	}
+
+	ip := net.ParseIP(reported)
+	for _, address := range ips {
+		if address.Equal(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkMaster turns a subjectively down master into an objectively down one
+// once a quorum of sentinels agrees, and then tries to lead its failover.
+func (s *Sentinel) checkMaster(m *sentinelMaster) {
+	s.mu.Lock()
+	sdown := m.sdown(s.downAfter)
+	if !sdown {
+		if m.odown {
+			s.logger.Printf("Master %s is reachable again\n", m.Name)
+		}
+		m.odown = false
+	}
+	host, port, quorum := m.Host, m.Port, m.Quorum
+	s.mu.Unlock()
+
+	if !sdown {
+		return
+	}
+
+	agreed := s.askMasterDown(host, port)
+
+	s.mu.Lock()
+	if agreed < quorum {
+		m.odown = false
+		s.mu.Unlock()
+		return
+	}
+	if !m.odown {
+		s.logger.Printf("Master %s is objectively down, %d sentinels agree\n", m.Name, agreed)
+	}
+	m.odown = true
+
+	if time.Since(m.failoverStart) < 2*s.failoverTimeout {
+		s.mu.Unlock()
+		return
+	}
+
+	s.currentEpoch++
+	epoch := s.currentEpoch
+	m.vote, m.voteEpoch = s.id, epoch
+	m.failoverStart = time.Now()
+	s.mu.Unlock()
+
+	votes := s.requestVotes(host, port, epoch)
+	needed := s.votesNeeded(quorum)
+	if votes < needed {
+		s.logger.Printf("Lost the election to fail over %s in epoch %d with %d of %d votes\n", m.Name, epoch, votes, needed)
+		return
+	}
+
+	s.logger.Printf("Elected to fail over %s in epoch %d with %d votes\n", m.Name, epoch, votes)
+	err := s.failover(m, epoch)
+	if err != nil {
+		s.logger.Printf("Failover of %s failed: %v\n", m.Name, err)
+	}
+}
+
+// askMasterDown counts the sentinels, this one included, that see the master at host:port down.
+func (s *Sentinel) askMasterDown(host string, port string) int {
+	agreed := 1
+	for _, peer := range s.peers {
+		reply, err := s.links.query(peer, "SENTINEL", "is-master-down-by-addr", host, port, "0", "*")
+		if err == nil && reply.Type == Array && len(reply.Array) == 3 && reply.Array[0].Number == 1 {
+			agreed++
+		}
+	}
+
+	return agreed
+}
+
+// requestVotes asks the other sentinels to elect this one to fail over the master
+// at host:port in epoch, and returns the votes it got, its own included.
+func (s *Sentinel) requestVotes(host string, port string, epoch int64) int {
+	votes := 1
+	for _, peer := range s.peers {
+		reply, err := s.links.query(peer, "SENTINEL", "is-master-down-by-addr", host, port, strconv.FormatInt(epoch, 10), s.id)
+		if err == nil && reply.Type == Array && len(reply.Array) == 3 && reply.Array[1].Bulk == s.id && int64(reply.Array[2].Number) == epoch {
+			votes++
+		}
+	}
+
+	return votes
+}
+
+// votesNeeded is how many votes elect a leader: a majority of the sentinels, and at least the quorum.
+func (s *Sentinel) votesNeeded(quorum int) int {
+	return max(quorum, (len(s.peers)+1)/2+1)
+}
+
+// promotionCandidates returns the replicas that can be promoted, the best first. Replicas that
+// answered recently are eligible unless their priority is 0, and are preferred by priority,
+// then by offset.
+func promotionCandidates(replicas map[string]*sentinelReplica, now time.Time) []sentinelReplica {
+	candidates := []sentinelReplica{}
+	for _, r := range replicas {
+		if r.priority > 0 && now.Sub(r.lastOK) < 5*sentinelProbePeriod {
+			candidates = append(candidates, *r)
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].priority != candidates[j].priority {
+			return candidates[i].priority < candidates[j].priority
+		}
+		if candidates[i].offset != candidates[j].offset {
+			return candidates[i].offset > candidates[j].offset
+		}
+		return candidates[i].address() < candidates[j].address()
+	})
+
+	return candidates
+}
+
+// failover promotes the best replica and points the other replicas at it.
+func (s *Sentinel) failover(m *sentinelMaster, epoch int64) error {
+	s.mu.Lock()
+	candidates := promotionCandidates(m.replicas, time.Now())
+	others := []string{}
+	for _, r := range m.replicas {
+		others = append(others, r.address())
+	}
+	s.mu.Unlock()
+
+	if len(candidates) == 0 {
+		return errors.New("no replica is eligible for promotion")
+	}
+	chosen := candidates[0]
+
+	s.logger.Printf("Promoting %s\n", chosen.address())
+	_, err := s.links.query(chosen.address(), "REPLICAOF", "NO", "ONE")
+	if err != nil {
+		return fmt.Errorf("failed to promote %s: %w", chosen.address(), err)
+	}
+
+	deadline := time.Now().Add(s.failoverTimeout)
+	for {
+		info, err := s.queryInfo(chosen.address())
+		if err == nil && info["role"] == string(master) {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s was not promoted in time", chosen.address())
+		}
+		time.Sleep(sentinelProbePeriod)
+	}
+
+	s.mu.Lock()
+	s.switchMaster(m, chosen.host, chosen.port, epoch)
+	s.mu.Unlock()
+	s.logger.Printf("Switched master %s to %s\n", m.Name, chosen.address())
+
+	for _, address := range others {
+		if address == chosen.address() {
+			continue
+		}
+
+		_, err := s.links.query(address, "REPLICAOF", chosen.host, chosen.port)
+		if err != nil {
+			s.logger.Printf("Failed to reconfigure %s, it is retried once it is reachable: %v\n", address, err)
+		}
+	}
+
+	s.sendHellos([]*sentinelMaster{m})
+	return nil
+}
+
+// sendHellos announces the configuration of the masters to the other sentinels.
+func (s *Sentinel) sendHellos(masters []*sentinelMaster) {
+	for _, m := range masters {
+		s.mu.Lock()
+		args := []string{"SENTINEL", "hello", m.Name, m.Host, m.Port, strconv.FormatInt(m.configEpoch, 10)}
+		s.mu.Unlock()
+
+		for _, peer := range s.peers {
+			s.links.query(peer, args...)
+		}
+	}
+}
+
+func (s *Sentinel) queryInfo(address string) (map[string]string, error) {
+	reply, err := s.links.query(address, "INFO", "replication")
+	if err != nil {
+		return nil, err
+	}
+	if reply.Type != Bulk {
+		return nil, fmt.Errorf("unexpected INFO reply from %s", address)
+	}
+
+	info := map[string]string{}
+	for _, line := range strings.Split(reply.Bulk, "\n") {
+		key, value, found := strings.Cut(strings.TrimSpace(line), ":")
+		if found {
+			info[key] = value
+		}
+	}
+
+	return info, nil
+}
+
+// parseInfoFields parses the "key=value,..." format of INFO values such as slave0.
+func parseInfoFields(value string) map[string]string {
+	fields := map[string]string{}
+	for _, field := range strings.Split(value, ",") {
+		key, value, found := strings.Cut(field, "=")
+		if found {
+			fields[key] = value
+		}
+	}
+
+	return fields
+}
@@ -0,0 +1,344 @@
+package redis_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+var testMaster = redis.SentinelMaster{Name: "mymaster", Host: "127.0.0.1", Port: "6379", Quorum: 2}
+
+type fakePeer struct {
+	down  bool
+	votes bool
+}
+
+// listen answers is-master-down-by-addr like a sentinel that sees the master
+// down or not, and that votes for whoever asks or for another sentinel.
+func (p fakePeer) listen(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			connection, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer connection.Close()
+
+				resp := redis.NewResp(connection)
+				for {
+					value, err := resp.Read()
+					if err != nil {
+						return
+					}
+					p.reply(redis.NewCommand(value).Args).Write(connection)
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func (p fakePeer) reply(args []string) redis.Value {
+	down := 0
+	if p.down {
+		down = 1
+	}
+
+	leader, epoch := "*", 0
+	if args[4] != "*" {
+		leader = "other"
+		if p.votes {
+			leader = args[4]
+		}
+		epoch, _ = strconv.Atoi(args[3])
+	}
+
+	return redis.Value{Type: redis.Array, Array: []redis.Value{
+		{Type: redis.Number, Number: down},
+		{Type: redis.Bulk, Bulk: leader},
+		{Type: redis.Number, Number: epoch},
+	}}
+}
+
+func listenPeers(t *testing.T, peers []fakePeer) []string {
+	addresses := []string{}
+	for _, peer := range peers {
+		addresses = append(addresses, peer.listen(t))
+	}
+	return addresses
+}
+
+func TestSentinelVote(t *testing.T) {
+	type step struct {
+		epoch      string
+		runID      string
+		wantLeader string
+		wantEpoch  int
+	}
+
+	tests := []struct {
+		name     string
+		port     string
+		wantDown int
+		steps    []step
+	}{
+		{
+			name:     "query without a vote",
+			port:     testMaster.Port,
+			wantDown: 1,
+			steps: []step{
+				{epoch: "0", runID: "*", wantLeader: "*", wantEpoch: 0},
+			},
+		},
+		{
+			name:     "first candidate of an epoch gets the vote",
+			port:     testMaster.Port,
+			wantDown: 1,
+			steps: []step{
+				{epoch: "1", runID: "a", wantLeader: "a", wantEpoch: 1},
+				{epoch: "1", runID: "b", wantLeader: "a", wantEpoch: 1},
+			},
+		},
+		{
+			name:     "a higher epoch votes again",
+			port:     testMaster.Port,
+			wantDown: 1,
+			steps: []step{
+				{epoch: "1", runID: "a", wantLeader: "a", wantEpoch: 1},
+				{epoch: "3", runID: "b", wantLeader: "b", wantEpoch: 3},
+				{epoch: "2", runID: "a", wantLeader: "b", wantEpoch: 3},
+			},
+		},
+		{
+			name:     "unknown master",
+			port:     "6380",
+			wantDown: 0,
+			steps: []step{
+				{epoch: "1", runID: "a", wantLeader: "*", wantEpoch: 0},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sentinel := redis.NewSentinel("127.0.0.1", "26379", redis.WithSentinelMonitor(testMaster), redis.WithSentinelDownAfter(0))
+
+			for _, step := range tt.steps {
+				reply := sentinel.Handle(redis.NewCommandFromArgs("SENTINEL", "is-master-down-by-addr", testMaster.Host, tt.port, step.epoch, step.runID))
+				assert.Equal(t, redis.Value{Type: redis.Array, Array: []redis.Value{
+					{Type: redis.Number, Number: tt.wantDown},
+					{Type: redis.Bulk, Bulk: step.wantLeader},
+					{Type: redis.Number, Number: step.wantEpoch},
+				}}, reply)
+			}
+		})
+	}
+}
+
+func TestSentinelQuorum(t *testing.T) {
+	tests := []struct {
+		name      string
+		downAfter time.Duration
+		quorum    int
+		peers     []fakePeer
+		wantODown bool
+	}{
+		{
+			name:      "quorum reached",
+			quorum:    2,
+			peers:     []fakePeer{{down: true}, {down: false}},
+			wantODown: true,
+		},
+		{
+			name:      "quorum not reached",
+			quorum:    3,
+			peers:     []fakePeer{{down: true}, {down: false}},
+			wantODown: false,
+		},
+		{
+			name:      "master reachable",
+			downAfter: time.Hour,
+			quorum:    1,
+			peers:     []fakePeer{{down: true}},
+			wantODown: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			master := testMaster
+			master.Quorum = tt.quorum
+			sentinel := redis.NewSentinel("127.0.0.1", "26379",
+				redis.WithSentinelMonitor(master),
+				redis.WithSentinelDownAfter(tt.downAfter),
+				redis.WithSentinelPeers(listenPeers(t, tt.peers)),
+			)
+
+			sentinel.CheckMaster(master.Name)
+
+			fields := sentinel.Handle(redis.NewCommandFromArgs("SENTINEL", "master", master.Name)).Array
+			assert.Equal(t, "flags", fields[6].Bulk)
+			assert.Equal(t, tt.wantODown, strings.Contains(fields[7].Bulk, "o_down"))
+		})
+	}
+}
+
+func TestSentinelElection(t *testing.T) {
+	tests := []struct {
+		name       string
+		quorum     int
+		peers      []fakePeer
+		wantVotes  int
+		wantNeeded int
+	}{
+		{
+			name:       "alone",
+			quorum:     1,
+			wantVotes:  1,
+			wantNeeded: 1,
+		},
+		{
+			name:       "every peer votes",
+			quorum:     2,
+			peers:      []fakePeer{{votes: true}, {votes: true}},
+			wantVotes:  3,
+			wantNeeded: 2,
+		},
+		{
+			name:       "peers vote for another sentinel",
+			quorum:     2,
+			peers:      []fakePeer{{}, {}},
+			wantVotes:  1,
+			wantNeeded: 2,
+		},
+		{
+			name:       "majority above the quorum",
+			quorum:     2,
+			peers:      []fakePeer{{votes: true}, {}, {}, {}},
+			wantVotes:  2,
+			wantNeeded: 3,
+		},
+		{
+			name:       "quorum above the majority",
+			quorum:     4,
+			peers:      []fakePeer{{votes: true}, {votes: true}},
+			wantVotes:  3,
+			wantNeeded: 4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			master := testMaster
+			master.Quorum = tt.quorum
+			sentinel := redis.NewSentinel("127.0.0.1", "26379",
+				redis.WithSentinelMonitor(master),
+				redis.WithSentinelPeers(listenPeers(t, tt.peers)),
+			)
+
+			votes, needed := sentinel.RequestVotes(master.Name, 1)
+			assert.Equal(t, tt.wantVotes, votes)
+			assert.Equal(t, tt.wantNeeded, needed)
+		})
+	}
+}
+
+func TestSentinelPromotionOrder(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		replicas []redis.SentinelReplica
+		want     []string
+	}{
+		{
+			name: "lower priority first",
+			replicas: []redis.SentinelReplica{
+				{Host: "10.0.0.1", Port: "6379", Priority: 100, Offset: 10, LastOK: now},
+				{Host: "10.0.0.2", Port: "6379", Priority: 50, Offset: 5, LastOK: now},
+			},
+			want: []string{"10.0.0.2:6379", "10.0.0.1:6379"},
+		},
+		{
+			name: "higher offset first",
+			replicas: []redis.SentinelReplica{
+				{Host: "10.0.0.1", Port: "6379", Priority: 100, Offset: 5, LastOK: now},
+				{Host: "10.0.0.2", Port: "6379", Priority: 100, Offset: 10, LastOK: now},
+			},
+			want: []string{"10.0.0.2:6379", "10.0.0.1:6379"},
+		},
+		{
+			name: "address breaks ties",
+			replicas: []redis.SentinelReplica{
+				{Host: "10.0.0.2", Port: "6379", Priority: 100, Offset: 10, LastOK: now},
+				{Host: "10.0.0.1", Port: "6379", Priority: 100, Offset: 10, LastOK: now},
+			},
+			want: []string{"10.0.0.1:6379", "10.0.0.2:6379"},
+		},
+		{
+			name: "priority 0 and unreachable replicas are not promoted",
+			replicas: []redis.SentinelReplica{
+				{Host: "10.0.0.1", Port: "6379", Priority: 0, Offset: 10, LastOK: now},
+				{Host: "10.0.0.2", Port: "6379", Priority: 100, Offset: 10, LastOK: now.Add(-time.Minute)},
+				{Host: "10.0.0.3", Port: "6379", Priority: 100, Offset: 1, LastOK: now.Add(-time.Second)},
+			},
+			want: []string{"10.0.0.3:6379"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, redis.PromotionOrder(tt.replicas, now))
+		})
+	}
+}
+
+func TestSentinelShutdown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	listener.Close()
+
+	sentinel := redis.NewSentinel("127.0.0.1", port)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopped := make(chan error, 1)
+	go func() { stopped <- sentinel.ListenAndServe(ctx) }()
+
+	var client *testConn
+	assert.Eventually(t, func() bool {
+		connection, err := net.Dial("tcp", sentinel.Address())
+		if err != nil {
+			return false
+		}
+		client = newTestConn(t, sentinel.Address(), connection)
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, redis.Value{Type: redis.SimpleString, SimpleString: "PONG"}, client.do("PING"))
+
+	// ListenAndServe returns once the connection it served is closed.
+	cancel()
+	select {
+	case err := <-stopped:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the sentinel did not stop")
+	}
+	client.connection.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = client.connection.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+}
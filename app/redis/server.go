@@ -2,7 +2,6 @@ package redis
 
 import (
 	"context"
-	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
@@ -11,15 +10,18 @@ import (
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
-
-	"golang.org/x/sync/errgroup"
 )
 
 const (
 	protocol = "tcp"
+
+	activeExpireInterval = 100 * time.Millisecond
+	activeExpireSample   = 20
 )
 
 type role string
@@ -33,37 +35,115 @@ type Server struct {
 	Host string
 	Port string
 
+	// MasterHost and MasterPort are guarded by roleMu, as REPLICAOF changes them at runtime.
 	MasterHost string
 	MasterPort string
-
-	client   *Client
-	replicas []replica
+	roleMu     sync.RWMutex
+	// stopReplication cancels the replication loop of a replica.
+	stopReplication context.CancelFunc
+	// ctx is the context the server is serving with, cancel ends it.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// goroutines tracks the goroutines ListenAndServe waits for, see goTracked.
+	goroutines sync.WaitGroup
+	shutdown   shutdownState
+
+	client *Client
+	// execMu is held while a command is applied and propagated.
+	execMu   sync.Mutex
+	replicas []*replica
+	// replicationDB is the database last selected in the replication stream, -1 if none was.
+	replicationDB int
+	// migrateLinks are the connections cached by MIGRATE, by target address. They are guarded by execMu.
+	migrateLinks map[string]*migrateLink
 
 	logger *log.Logger
 
-	offset int
-}
-
-type replica struct {
-	connection net.Conn
-	offset     int
+	repl replicationState
+
+	dir        string
+	dbFilename string
+	// savePolicies are guarded by rdb.mu, as CONFIG SET changes them.
+	savePolicies []SavePolicy
+	rdb          rdbState
+	aof          aofState
+
+	// replDisklessSync, like replicaReadOnly and replTimeout, is atomic as CONFIG SET changes it while it is read.
+	replDisklessSync atomic.Bool
+	pause            clientPause
+	sessions         sessionRegistry
+
+	// listener accepts the client connections, with a queue of tcpBacklog connections. Both are guarded by listenMu.
+	listenMu   sync.Mutex
+	listener   net.Listener
+	tcpBacklog int
+	failover   failoverState
+
+	// replicaReadOnly makes a replica reject writes from its clients.
+	replicaReadOnly atomic.Bool
+	replicaPriority int
+	// replTimeout holds a time.Duration.
+	replTimeout    atomic.Int64
+	replPingPeriod time.Duration
+
+	cluster clusterState
+
+	// config holds the parameters CONFIG GET and CONFIG SET read and change.
+	config *Config
+
+	// runID identifies this run of the server, startedAt is when it was created.
+	runID     string
+	startedAt time.Time
+	stats     serverStats
 }
 
-var ackChan = make(chan bool)
-
-func NewServer(client *Client, host string, masterHost string, port string, masterPort string) *Server {
+func NewServer(client *Client, host string, masterHost string, port string, masterPort string, opts ...func(*Server)) *Server {
 	server := &Server{
 		Host:       host,
 		Port:       port,
 		MasterHost: masterHost,
 		MasterPort: masterPort,
 
-		client:   client,
-		replicas: []replica{},
-		offset:   0,
+		client:        client,
+		replicas:      []*replica{},
+		replicationDB: -1,
+		migrateLinks:  map[string]*migrateLink{},
+		repl:          newReplicationState(),
+
+		dir:        ".",
+		dbFilename: defaultDBFilename,
+		rdb:        rdbState{lastSave: time.Now()},
+		aof:        aofState{AOFConfig: DefaultAOFConfig(), db: -1},
+
+		replicaPriority: DefaultReplicaPriority,
+		failover:        failoverState{state: failoverNone},
+		replPingPeriod:  DefaultReplPingPeriod,
+		cluster:         clusterState{ClusterConfig: DefaultClusterConfig()},
+		config:          NewConfig(),
+		sessions: sessionRegistry{
+			outputLimits: DefaultOutputBufferLimits(),
+			keepAlive:    DefaultTCPKeepAlive,
+			maxClients:   DefaultMaxClients,
+		},
+		tcpBacklog: DefaultTCPBacklog,
+		shutdown:   shutdownState{timeout: DefaultShutdownTimeout},
+		runID:      randomHex(40),
+		startedAt:  time.Now(),
+	}
+	server.replDisklessSync.Store(true)
+	server.replicaReadOnly.Store(true)
+	server.replTimeout.Store(int64(DefaultReplTimeout))
+
+	for _, opt := range opts {
+		opt(server)
 	}
 	logger := log.New(os.Stdout, fmt.Sprintf("[%s on %s:%s] ", server.role(), server.Host, server.Port), 0)
 	server.logger = logger
+	server.client.SetMaster(server.role() == master)
+	if server.cluster.Enabled {
+		port, _ := strconv.Atoi(server.Port)
+		server.cluster.init(port)
+	}
 
 	return server
 }
@@ -73,52 +153,117 @@ func (s *Server) Address() string {
 }
 
 func (s *Server) MasterAddress() string {
-	if s.MasterHost == "" || s.MasterPort == "" {
+	host, port := s.masterHostPort()
+	if host == "" || port == "" {
 		return ""
 	}
 
-	return fmt.Sprintf("%s:%s", s.MasterHost, s.MasterPort)
+	return fmt.Sprintf("%s:%s", host, port)
 }
 
+func (s *Server) masterHostPort() (string, string) {
+	s.roleMu.RLock()
+	defer s.roleMu.RUnlock()
+
+	return s.MasterHost, s.MasterPort
+}
+
+// ListenAndServe serves until SHUTDOWN, SIGINT or SIGTERM shut the server down, or
+// until ctx is done. It returns once the connections are closed and every
+// goroutine of the server exited.
 func (s *Server) ListenAndServe(ctx context.Context) error {
 	s.logger.Print("Starting the server")
 
-	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
-	defer stop()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	s.ctx, s.cancel = ctx, cancel
+
+	if s.aof.Enabled {
+		err := s.loadAOF()
+		if err != nil {
+			return err
+		}
+	} else {
+		err := s.loadRDB()
+		if err != nil {
+			return err
+		}
+	}
 
 	listener, err := s.listen(ctx, s.Address())
 	if err != nil {
 		return fmt.Errorf("failed to listen on address: %s, %w", s.Address(), err)
 	}
-	go s.serveLoop(ctx, listener)
-
-	if s.role() == slave {
-		connection, err := s.connect(ctx, s.MasterAddress())
-		if err != nil {
-			return fmt.Errorf("failed to establish master handshake: %w", err)
-		}
-
-		resp := NewResp(connection)
-
-		s.logger.Println("Starting master handshake")
+	defer listener.Close()
+	if err := s.setListener(listener); err != nil {
+		return fmt.Errorf("failed to set the backlog of %s: %w", s.Address(), err)
+	}
 
-		err = s.masterHandshake(resp, connection)
+	var busListener net.Listener
+	if s.cluster.Enabled {
+		busAddress := net.JoinHostPort(s.Host, strconv.Itoa(s.cluster.myself.busPort))
+		busListener, err = s.listen(ctx, busAddress)
 		if err != nil {
-			return fmt.Errorf("failed to establish master handshake: %w", err)
+			return fmt.Errorf("failed to listen on cluster bus address: %s, %w", busAddress, err)
 		}
+		defer busListener.Close()
+	}
 
-		s.logger.Println("Finished master handshake")
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	s.goTracked(func() { s.signalLoop(ctx, signals) })
+	s.goTracked(func() { s.saveLoop(ctx) })
+	s.goTracked(func() { s.aofFsyncLoop(ctx) })
+	s.goTracked(func() { s.activeExpireLoop(ctx) })
+	s.goTracked(func() { s.replicationCron(ctx) })
+	s.goTracked(func() { s.migrateLinksLoop(ctx) })
+	s.goTracked(func() { s.statsLoop(ctx) })
+	s.goTracked(func() { s.clientsCron(ctx) })
+	s.goTracked(func() { s.serveLoop(ctx, listener) })
+	if s.cluster.Enabled {
+		s.goTracked(func() { s.serveClusterBus(ctx, busListener) })
+		s.goTracked(func() { s.clusterCron(ctx) })
+	}
 
-		go s.handleLoop(ctx, resp, connection)
+	if s.role() == slave {
+		s.startReplication()
 	}
 
 	<-ctx.Done()
 
-	fmt.Println("Shutting the server down")
+	s.logger.Println("Shutting the server down")
+	// Closing the listeners stops the accept loops, the connections are closed as ctx is done.
+	listener.Close()
+	if busListener != nil {
+		busListener.Close()
+	}
+	s.goroutines.Wait()
+	s.logger.Println("Server stopped")
 
 	return nil
 }
 
+// signalLoop shuts the server down on SIGINT and SIGTERM, the way SHUTDOWN does.
+func (s *Server) signalLoop(ctx context.Context, signals <-chan os.Signal) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case signal := <-signals:
+			s.logger.Printf("Received %s, scheduling shutdown...\n", signal)
+			if err := s.prepareShutdown(shutdownFlags{}); err != nil {
+				s.logger.Println("Errors trying to shut down the server:", err)
+				continue
+			}
+
+			s.stop()
+			return
+		}
+	}
+}
+
 func (s *Server) listen(ctx context.Context, address string) (net.Listener, error) {
 	listenConfig := net.ListenConfig{}
 	listener, err := listenConfig.Listen(ctx, protocol, address)
@@ -130,6 +275,56 @@ func (s *Server) listen(ctx context.Context, address string) (net.Listener, erro
 
 }
 
+// WithTCPBacklog sets how many connections may wait to be accepted.
+func WithTCPBacklog(backlog int) func(*Server) {
+	return func(s *Server) {
+		s.tcpBacklog = backlog
+	}
+}
+
+func (s *Server) setListener(listener net.Listener) error {
+	s.listenMu.Lock()
+	defer s.listenMu.Unlock()
+
+	s.listener = listener
+	return listenBacklog(listener, s.tcpBacklog)
+}
+
+func (s *Server) setTCPBacklog(backlog int) error {
+	s.listenMu.Lock()
+	defer s.listenMu.Unlock()
+
+	if s.listener != nil {
+		if err := listenBacklog(s.listener, backlog); err != nil {
+			return err
+		}
+	}
+	s.tcpBacklog = backlog
+	return nil
+}
+
+// listenBacklog changes the backlog of a listening socket, by calling listen again on it.
+// The system caps it to its own limit, net.core.somaxconn on Linux.
+func listenBacklog(listener net.Listener, backlog int) error {
+	tcp, ok := listener.(*net.TCPListener)
+	if !ok {
+		return nil
+	}
+	raw, err := tcp.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var listenErr error
+	err = raw.Control(func(fd uintptr) {
+		listenErr = syscall.Listen(int(fd), backlog)
+	})
+	if err != nil {
+		return err
+	}
+	return listenErr
+}
+
 func (s *Server) connect(ctx context.Context, address string) (net.Conn, error) {
 	s.logger.Printf("Connecting to address at: %s\n", address)
 
@@ -163,15 +358,42 @@ func (s *Server) serveLoop(ctx context.Context, listener net.Listener) {
 			}
 
 			s.logger.Printf("New connection to the server: %s\n", connection.RemoteAddr())
+			if s.shuttingDown() {
+				connection.Write([]byte("-ERR Redis is shutting down\r\n"))
+				connection.Close()
+				continue
+			}
+			s.stats.connectionsReceived.Add(1)
+			if !s.sessions.accept(connection) {
+				s.stats.rejectedConnections.Add(1)
+				connection.Write([]byte("-ERR max number of clients reached\r\n"))
+				connection.Close()
+				continue
+			}
 
 			resp := NewResp(connection)
-			go s.handleLoop(ctx, resp, connection)
+			s.goTracked(func() { s.handleLoop(ctx, NewSession(), resp, connection) })
 		}
 	}
 }
 
-func (s *Server) handleLoop(ctx context.Context, resp *Resp, connection net.Conn) {
-	defer connection.Close()
+func (s *Server) handleLoop(ctx context.Context, session *Session, resp *Resp, connection net.Conn) {
+	output := newOutputBuffer(connection, s.sessions.outputLimit, func(size int64) {
+		s.stats.outputBufferLimitDisconnections.Add(1)
+		s.logger.Printf("Closing connection %s for overcoming of output buffer limits, %d bytes queued\n", connection.RemoteAddr(), size)
+	})
+	session.output = output
+	s.sessions.add(session, output)
+	output.setClientType(session.currentInfo().clientType())
+	defer s.sessions.remove(session)
+	defer output.wait()
+	defer output.drain()
+	defer closeOnDone(ctx, output)()
+	defer func() {
+		if session.replica != nil {
+			s.removeReplica(session.replica)
+		}
+	}()
 
 	s.logger.Println("Initializing the handle loop")
 	for {
@@ -179,126 +401,156 @@ func (s *Server) handleLoop(ctx context.Context, resp *Resp, connection net.Conn
 		case <-ctx.Done():
 			return
 		default:
-			s.handle(resp, connection)
+			err := s.handle(session, resp, output)
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					s.logger.Printf("Closing connection %s: %v\n", connection.RemoteAddr(), err)
+				}
+				return
+			}
 		}
 	}
 }
 
-func (s *Server) handle(resp *Resp, writer net.Conn) {
-	value, err := resp.Read()
+func (s *Server) handle(session *Session, resp *Resp, writer net.Conn) error {
+	value, raw, err := resp.ReadRaw()
 	if err != nil {
-		if errors.Is(err, io.EOF) {
-			return
+		if errors.Is(err, ErrInvalidResp) {
+			errorValue("ERR Protocol error: %v", err).Write(writer)
 		}
+		return err
+	}
+	if value.Type == Array && len(value.Array) == 0 {
+		return nil
+	}
 
-		log.Fatalf("failed to handle connection: %v", err)
+	if value.Type != Array && value.Type != Bulk && value.Type != SimpleString {
+		return fmt.Errorf("%w: expected a command, got %s", ErrInvalidResp, value.Type)
 	}
 
 	cmd := NewCommand(value)
-	cmdLen := len([]byte(value.Format()))
+	s.stats.netInputBytes.Add(int64(len(raw)))
+	session.rejected = false
+	replies := &replyRecorder{Conn: writer, stats: &s.stats}
+	session.commandStarted(cmd, resp)
+	defer session.commandDone()
+
+	if !s.waitPause(session, cmd) {
+		return errServerShutdown
+	}
+
+	start := time.Now()
+	err = s.dispatch(session, cmd, raw, replies)
+	if errors.Is(err, ErrUnknownCommand) {
+		// Unknown commands are neither answered nor counted.
+		return nil
+	}
+	s.stats.recordCall(cmd, time.Since(start), replies.errorReply(), session.rejected)
+	if err == nil && session.closeAfterReply {
+		return errClientKilled
+	}
 
-	s.logger.Printf("Handling command: %q | type: %s | len: %v | offset: %v\n", cmd.value.Format(), cmd.Type, cmdLen, s.offset)
+	return err
+}
+
+// dispatch serves a command read from the connection, as raw.
+func (s *Server) dispatch(session *Session, cmd Command, raw []byte, writer net.Conn) error {
+	cmdLen := len([]byte(cmd.value.Format()))
+
+	_, offset := s.replicationInfo()
+	s.logger.Printf("Handling command: %q | type: %s | len: %v | offset: %v\n", cmd.value.Format(), cmd.Type, cmdLen, offset)
+
+	if session.master {
+		s.masterIO()
+		// Everything the master sends counts towards the replication offset once processed.
+		defer s.forwardStream(raw)
+	}
+
+	// The master is not answered, except when it asks for an acknowledgement.
+	if session.master && cmd.Type != ReplConf {
+		_, err := s.execute(session, cmd)
+		if errors.Is(err, ErrUnknownCommand) {
+			s.logger.Printf("Unknown command from master: %q", cmd.value.Format())
+		}
+		return err
+	}
 
 	switch cmd.Type {
 	case Wait:
-		ackReplicas, err := strconv.Atoi(cmd.Args[0])
-		if err != nil {
-			s.logger.Fatalf("Failed to parse args for: %q\n", cmd.value.Format())
+		if len(cmd.Args) != 2 {
+			return wrongArgumentsError(cmd).Write(writer)
+		}
+		if s.role() == slave {
+			return errorValue("ERR WAIT cannot be used with replica instances. Please also note that since Redis 4.0 if a replica is configured to be writable (which is not the default) writes to replicas are just local and are not propagated.").Write(writer)
 		}
 
-		acksTimeoutMs, err := strconv.Atoi(cmd.Args[1])
+		numReplicas, err := strconv.Atoi(cmd.Args[0])
 		if err != nil {
-			s.logger.Fatalf("Failed to parse args for: %q\n", cmd.value.Format())
+			return errorValue("ERR value is not an integer or out of range").Write(writer)
+		}
+		timeoutMs, err := strconv.Atoi(cmd.Args[1])
+		if err != nil {
+			return errorValue("ERR timeout is not an integer or out of range").Write(writer)
+		}
+		if timeoutMs < 0 {
+			return errorValue("ERR timeout is negative").Write(writer)
 		}
 
-		if len(s.replicas) == 0 {
-			value := Value{Type: Number, Number: len(s.replicas)}
-			err := value.Write(writer)
-			if err != nil {
-				fmt.Println("Failed to write", err)
-			}
-		} else {
-			var ackMutex sync.Mutex
-			acks := 0
-
-			var eg errgroup.Group
-			for _, replica := range s.replicas {
-				replica := replica
-
-				if replica.offset <= 0 {
-					acks += 1
-				} else {
-					eg.Go(func() error {
-						s.logger.Printf("Sending ACK to replica: %s\n", replica.connection.RemoteAddr())
-
-						value := Value{Type: Array, Array: []Value{
-							{Type: Bulk, Bulk: "REPLCONF"},
-							{Type: Bulk, Bulk: "GETACK"},
-							{Type: Bulk, Bulk: "*"},
-						}}
-
-						err := value.Write(replica.connection)
-						if err != nil {
-							s.logger.Printf("Failed to write: %q to replica \n", value.Format())
-							return err
-						}
-
-						return nil
-					})
-				}
-
-			}
-
-			timer := time.After(time.Duration(acksTimeoutMs * int(time.Millisecond)))
-			s.logger.Printf("WAIT: %v ms\n", acksTimeoutMs*int(time.Millisecond))
-
-		loop:
-			for acks < ackReplicas {
-				select {
-				case <-ackChan:
-					ackMutex.Lock()
-					acks = acks + 1
-					s.logger.Printf("Got ACK in WAIT: %v\n", acks)
-					ackMutex.Unlock()
-				case <-timer:
-					break loop
-				}
-			}
+		s.stats.blockedClients.Add(1)
+		session.setBlocked(true)
+		acked := s.waitForReplicas(session.writeOffset, numReplicas, time.Duration(timeoutMs)*time.Millisecond)
+		session.setBlocked(false)
+		s.stats.blockedClients.Add(-1)
+		s.logger.Printf("WAIT: %d replicas acknowledged offset %d\n", acked, session.writeOffset)
 
-			s.logger.Printf("Received ACKs: %v\n", acks)
-			value := Value{Type: Number, Number: acks}
-			err := value.Write(writer)
-			if err != nil {
-				fmt.Println("Failed to write", err)
-			}
+		return Value{Type: Number, Number: acked}.Write(writer)
 
+	case ReplConf:
+		if len(cmd.Args) == 0 {
+			return wrongArgumentsError(cmd).Write(writer)
 		}
 
-	case ReplConf:
-		if cmd.Args[0] == "listening-port" {
-			s.replicas = append(s.replicas, replica{connection: writer, offset: 0})
+		for i := 0; i+1 < len(cmd.Args); i += 2 {
+			switch strings.ToLower(cmd.Args[i]) {
+			case "listening-port":
+				session.replicaListeningPort = cmd.Args[i+1]
+			case "capa":
+				if strings.EqualFold(cmd.Args[i+1], "eof") {
+					session.replicaCapaEOF = true
+				}
+			}
 		}
 
-		switch cmd.Args[0] {
+		switch strings.ToUpper(cmd.Args[0]) {
 		case "ACK":
-			s.logger.Printf("Received ACK: %v", cmd.Args[1])
-			ackChan <- true
+			if len(cmd.Args) < 2 || session.replica == nil {
+				return nil
+			}
+
+			ackOffset, err := strconv.Atoi(cmd.Args[1])
+			if err != nil {
+				s.logger.Printf("Invalid ACK offset from %s: %q\n", writer.RemoteAddr(), cmd.Args[1])
+				return nil
+			}
+			s.acknowledge(session.replica, ackOffset)
 		case "GETACK":
-			s.logger.Printf("GETACK. Current offset: %v\n", s.offset)
+			s.logger.Printf("GETACK. Current offset: %v\n", offset)
 
 			value := Value{Type: Array, Array: []Value{
 				{Type: Bulk, Bulk: "REPLCONF"},
 				{Type: Bulk, Bulk: "ACK"},
-				{Type: Bulk, Bulk: fmt.Sprintf("%v", s.offset)},
+				{Type: Bulk, Bulk: fmt.Sprintf("%v", offset)},
 			}}
 			err := value.Write(writer)
 			if err != nil {
-				fmt.Println("Failed to write", err)
+				s.logger.Println("Failed to send the ACK:", err)
 			}
 
-			s.offset = s.offset + cmdLen
-
 		default:
+			if session.master {
+				return nil
+			}
+
 			value := Value{Type: SimpleString, SimpleString: "OK"}
 			err := value.Write(writer)
 			if err != nil {
@@ -307,78 +559,293 @@ func (s *Server) handle(resp *Resp, writer net.Conn) {
 
 		}
 
-	case Info:
-		info := fmt.Sprintf("role:%s\nmaster_replid:%s\nmaster_repl_offset:%s", s.role(), "8371b4fb1155b71f4a04d3e1bc3e18c4a990aeeb", "0")
-		value := Value{Type: Bulk, Bulk: info}
+	case Save:
+		value := Value{Type: SimpleString, SimpleString: "OK"}
+		err := s.save()
+		if err != nil {
+			s.logger.Println("Failed to save:", err)
+			value = errorValue("ERR %v", err)
+		}
+
+		err = value.Write(writer)
+		if err != nil {
+			fmt.Println("Failed to write", err)
+		}
+
+	case BGSave:
+		value := Value{Type: SimpleString, SimpleString: "Background saving started"}
+		err := s.bgsave()
+		if err != nil {
+			value = errorValue("ERR %v", err)
+		}
+
+		err = value.Write(writer)
+		if err != nil {
+			fmt.Println("Failed to write", err)
+		}
+
+	case LastSave:
+		value := Value{Type: Number, Number: int(s.lastSave().Unix())}
 		err := value.Write(writer)
 		if err != nil {
 			fmt.Println("Failed to write", err)
 		}
 
-	case PSync:
-		data := fmt.Sprintf("FULLRESYNC %s %s", "8371b4fb1155b71f4a04d3e1bc3e18c4a990aeeb", "0")
-		resyncValue := Value{
-			Type:         SimpleString,
-			SimpleString: data,
+	case BGRewriteAOF:
+		value := Value{Type: SimpleString, SimpleString: "Background append only file rewriting started"}
+		if !s.aofEnabled() {
+			value = errorValue("ERR AOF is disabled, enable it with appendonly yes")
+		} else if err := s.rewriteAOF(true); err != nil {
+			value = errorValue("ERR %v", err)
 		}
-		err := resyncValue.Write(writer)
+
+		err := value.Write(writer)
 		if err != nil {
-			s.logger.Println("Failed to write", err)
+			fmt.Println("Failed to write", err)
 		}
 
-		b64RDB := "UkVESVMwMDEx+glyZWRpcy12ZXIFNy4yLjD6CnJlZGlzLWJpdHPAQPoFY3RpbWXCbQi8ZfoIdXNlZC1tZW3CsMQQAPoIYW9mLWJhc2XAAP/wbjv+wP9aog=="
-		rdbData, err := base64.StdEncoding.DecodeString(b64RDB)
+	case Info:
+		value := Value{Type: Bulk, Bulk: s.info(cmd.Args)}
+		err := value.Write(writer)
 		if err != nil {
-			return
+			fmt.Println("Failed to write", err)
+		}
+
+	case ReplicaOf, SlaveOf:
+		if len(cmd.Args) != 2 {
+			return wrongArgumentsError(cmd).Write(writer)
+		}
+
+		if strings.EqualFold(cmd.Args[0], "no") && strings.EqualFold(cmd.Args[1], "one") {
+			s.replicaOf("", "")
+			return Value{Type: SimpleString, SimpleString: "OK"}.Write(writer)
+		}
+
+		if _, err := strconv.Atoi(cmd.Args[1]); err != nil {
+			return errorValue("ERR Invalid master port").Write(writer)
+		}
+		if host, port := s.masterHostPort(); host == cmd.Args[0] && port == cmd.Args[1] {
+			return Value{Type: SimpleString, SimpleString: "OK Already connected to specified master"}.Write(writer)
 		}
-		rdbValue := Value{Type: Raw, Raw: fmt.Sprintf("$%v\r\n%s", len(rdbData), rdbData)}
-		err = rdbValue.Write(writer)
+
+		s.replicaOf(cmd.Args[0], cmd.Args[1])
+		return Value{Type: SimpleString, SimpleString: "OK"}.Write(writer)
+
+	case Failover:
+		return s.failoverCommand(cmd).Write(writer)
+
+	case Cfg:
+		return s.configCommand(cmd).Write(writer)
+
+	case ClientCommand:
+		return s.clientCommand(session, cmd).Write(writer)
+
+	case Shutdown:
+		reply, err := s.shutdownCommand(cmd)
+		if err != nil {
+			return err
+		}
+		return reply.Write(writer)
+
+	case Cluster:
+		return s.clusterCommand(cmd).Write(writer)
+
+	case Asking:
+		if !s.cluster.Enabled {
+			return errorValue("ERR This instance has cluster support disabled").Write(writer)
+		}
+		session.asking = true
+		return Value{Type: SimpleString, SimpleString: "OK"}.Write(writer)
+
+	case PSync:
+		if len(cmd.Args) == 3 && strings.EqualFold(cmd.Args[2], "failover") {
+			if s.role() != slave || cmd.Args[0] != s.replicationID() {
+				return errorValue("ERR PSYNC FAILOVER replid must match my replid.").Write(writer)
+			}
+
+			// The master hands over: this replica becomes the master and the former one continues as its replica.
+			s.logger.Println("Promoted by the master for a failover")
+			s.replicaOf("", "")
+		}
+
+		if len(cmd.Args) >= 2 {
+			psyncOffset, err := strconv.Atoi(cmd.Args[1])
+			if err == nil {
+				continued, err := s.partialResync(session, writer, cmd.Args[0], psyncOffset)
+				if err != nil {
+					return err
+				}
+				if continued {
+					break
+				}
+			}
+		}
+
+		err := s.fullResync(session, writer)
 		if err != nil {
-			s.logger.Println("Failed to write", err)
+			return err
+		}
+
+	case Memory:
+		if reply, rejected := s.admit(session, cmd); rejected {
+			return reply.Write(writer)
 		}
+		return s.memoryCommand(session, cmd).Write(writer)
+
+	case Migrate:
+		if reply, rejected := s.admit(session, cmd); rejected {
+			return reply.Write(writer)
+		}
+		return s.migrate(session, cmd).Write(writer)
 
 	default:
-		outValue, err := s.client.Handle(cmd)
+		if reply, rejected := s.admit(session, cmd); rejected {
+			return reply.Write(writer)
+		}
+
+		outValue, err := s.execute(session, cmd)
 		if err != nil {
 			if errors.Is(err, ErrUnknownCommand) {
 				s.logger.Printf("Unknown command: %q", cmd.value.Format())
-				return
+				return err
 			}
 
 			s.logger.Fatalf("failed to handle client command: %v", err)
 		}
 
-		if s.role() == "slave" {
-			s.offset = cmdLen + s.offset
+		s.logger.Printf("Responding with: %q\n", outValue.Format())
 
-			if cmd.Type == Get {
-				_, err = writer.Write([]byte(outValue.Format()))
-				if err != nil {
-					s.logger.Fatalf("failed to respond to client command: %v", err)
-				}
+		_, err = writer.Write([]byte(outValue.Format()))
+		if err != nil {
+			// The connection is closed, for instance for exceeding its output buffer limits.
+			return fmt.Errorf("failed to respond to client command: %w", err)
+		}
+	}
 
-				return
-			}
+	return nil
+}
+
+// admit checks whether a client command may run.
+// It returns the error to reply with instead of running the command, if any.
+func (s *Server) admit(session *Session, cmd Command) (Value, bool) {
+	if s.cluster.Enabled {
+		if reply, redirected := s.routeCluster(session, cmd); redirected {
+			session.rejected = true
+			return reply, true
+		}
+	}
+	if s.role() == slave && s.replicaReadOnly.Load() && cmd.IsWrite() {
+		session.rejected = true
+		return errorValue("READONLY You can't write against a read only replica."), true
+	}
+	if err := s.aofWriteError(); err != nil && cmd.IsWrite() {
+		session.rejected = true
+		return errorValue("MISCONF Errors writing to the AOF file: %s", err), true
+	}
 
-			s.logger.Println("Skipping the response")
+	return Value{}, false
+}
 
+// execute applies a command to the dataset. Commands run one at a time, so
+// that they reach the AOF and the replicas in the order they were applied.
+// Keys are evicted first if the dataset does not fit in maxmemory, and client
+// commands that may grow it are rejected if it still does not.
+func (s *Server) execute(session *Session, cmd Command) (Value, error) {
+	s.execMu.Lock()
+	defer s.execMu.Unlock()
+
+	var outValue Value
+	var err error
+	// Evicting keys changes the dataset, which must not happen while clients are paused.
+	fits := session.master || s.pause.paused() || s.client.Evict(session)
+	if !fits && cmd.spec().flags&cmdDenyOOM != 0 {
+		outValue = errorValue("OOM command not allowed when used memory > 'maxmemory'.")
+		session.rejected = true
+	} else {
+		outValue, err = s.client.Handle(session, cmd)
+	}
+	replicateErr := s.replicate(session)
+	if replicateErr != nil {
+		s.logger.Println("Failed to replicate", replicateErr)
+	}
+
+	return outValue, err
+}
+
+// replicationLoop keeps the replica connected to its master, reconnecting
+// whenever the link drops, with a growing delay while the master is unreachable.
+func (s *Server) replicationLoop(ctx context.Context) {
+	delay := replReconnectMinDelay
+	for {
+		err := s.syncWithMaster(ctx)
+		if !errors.Is(err, errMasterLinkLost) {
+			s.failoverHandshakeFailed()
+		}
+		if ctx.Err() != nil {
 			return
 		}
 
-		s.logger.Printf("Responding with: %q\n", outValue.Format())
-
-		_, err = writer.Write([]byte(outValue.Format()))
-		if err != nil {
-			s.logger.Fatalf("failed to respond to client command: %v", err)
+		if errors.Is(err, errMasterLinkLost) {
+			delay = replReconnectMinDelay
 		}
+		s.logger.Printf("Lost the connection to master, reconnecting in %s: %v\n", delay, err)
 
-		err = s.replicate(cmd)
-		if err != nil {
-			s.logger.Println("Failed to replicate", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
 		}
+
+		delay = min(delay*2, replReconnectMaxDelay)
 	}
 }
 
+var errMasterLinkLost = errors.New("lost the link to master")
+
+// syncWithMaster connects to the master and processes the replication stream until the link drops.
+func (s *Server) syncWithMaster(ctx context.Context) error {
+	connection, err := s.connect(ctx, s.MasterAddress())
+	if err != nil {
+		return fmt.Errorf("failed to connect to master: %w", err)
+	}
+	defer closeOnDone(ctx, connection)()
+
+	resp := NewResp(connection)
+
+	s.logger.Println("Starting master handshake")
+
+	// A master that stops responding during the handshake must not stall the replica forever.
+	connection.SetDeadline(time.Now().Add(time.Duration(s.replTimeout.Load())))
+	err = s.masterHandshake(resp, connection)
+	if err != nil {
+		connection.Close()
+		return fmt.Errorf("failed to establish master handshake: %w", err)
+	}
+	connection.SetDeadline(time.Time{})
+
+	s.logger.Println("Finished master handshake")
+	if s.failoverState() == failoverInProgress {
+		s.logger.Println("Failover: completed")
+		s.endFailover()
+	}
+
+	s.setMasterConnection(connection)
+	defer s.dropMasterConnection(connection)
+
+	s.repl.mu.Lock()
+	session := NewSession()
+	session.master = true
+	session.db = s.repl.masterDB
+	s.repl.mu.Unlock()
+	s.handleLoop(ctx, session, resp, connection)
+
+	s.repl.mu.Lock()
+	s.repl.masterDB = session.db
+	s.repl.mu.Unlock()
+
+	return errMasterLinkLost
+}
+
 func (s *Server) masterHandshake(resp *Resp, writer io.Writer) error {
 	s.logger.Println("Starting handshake")
 
@@ -427,6 +894,8 @@ func (s *Server) masterHandshake(resp *Resp, writer io.Writer) error {
 		outValue := Value{Type: Array, Array: []Value{
 			{Type: Bulk, Bulk: "REPLCONF"},
 			{Type: Bulk, Bulk: "capa"},
+			{Type: Bulk, Bulk: "eof"},
+			{Type: Bulk, Bulk: "capa"},
 			{Type: Bulk, Bulk: "psync2"},
 		}}
 		s.logger.Printf("Sending to master: %q\n", outValue.Format())
@@ -445,11 +914,18 @@ func (s *Server) masterHandshake(resp *Resp, writer io.Writer) error {
 	}
 
 	{
-		outValue := Value{Type: Array, Array: []Value{
-			{Type: Bulk, Bulk: "PSYNC"},
-			{Type: Bulk, Bulk: "?"},
-			{Type: Bulk, Bulk: "-1"},
-		}}
+		s.repl.mu.Lock()
+		replicationID, psyncOffset := "?", "-1"
+		if s.repl.cachedMaster {
+			replicationID, psyncOffset = s.repl.id, strconv.Itoa(s.repl.offset+1)
+		}
+		s.repl.mu.Unlock()
+
+		args := []string{"PSYNC", replicationID, psyncOffset}
+		if s.failoverState() == failoverInProgress {
+			args = append(args, "FAILOVER")
+		}
+		outValue := NewCommandFromArgs(args...).value
 		s.logger.Printf("Sending to master: %q\n", outValue.Format())
 
 		err := outValue.Write(writer)
@@ -457,71 +933,198 @@ func (s *Server) masterHandshake(resp *Resp, writer io.Writer) error {
 			return fmt.Errorf("failed to write to master: %w", err)
 		}
 
-		{
-			out, err := resp.reader.ReadString('\n')
-			if err != nil {
-				return fmt.Errorf("failed to read during handshake: %w", err)
-			}
-
-			s.logger.Printf("Master responded with: %q\n", out)
+		out, err := resp.reader.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("failed to read during handshake: %w", err)
 		}
 
-		{
-			out, err := resp.reader.ReadString('\n')
+		s.logger.Printf("Master responded with: %q\n", out)
+
+		fields := strings.Fields(out)
+		switch {
+		case len(fields) == 3 && fields[0] == "+FULLRESYNC":
+			offset, err := strconv.Atoi(fields[2])
 			if err != nil {
-				return fmt.Errorf("invalid RDB file transfer response: %w", err)
+				return fmt.Errorf("invalid FULLRESYNC offset: %q", out)
 			}
 
-			if out[0] != '$' {
-				return fmt.Errorf("invalid RDB file transfer response")
+			snapshot, err := s.receiveRDB(resp)
+			if err != nil {
+				return err
 			}
 
-			rdbSize, _ := strconv.Atoi(out[1 : len(out)-2])
-			buffer := make([]byte, rdbSize)
-			receivedSize, err := resp.reader.Read(buffer)
+			err = s.client.Load(snapshot)
 			if err != nil {
-				return fmt.Errorf("invalid RDB file transfer response: %w", err)
+				return fmt.Errorf("failed to load the RDB received from master: %w", err)
 			}
 
-			if rdbSize != receivedSize {
-				return fmt.Errorf("rdb size mismatch - got: %d, want: %d", receivedSize, rdbSize)
+			s.repl.mu.Lock()
+			s.repl.id = fields[1]
+			s.repl.id2 = noReplicationID
+			s.repl.secondOffset = -1
+			s.repl.offset = offset
+			s.repl.backlog.reset()
+			s.repl.cachedMaster = true
+			s.repl.masterDB = 0
+			s.repl.mu.Unlock()
+
+			// The sub-replicas hold the old history, they need a full resync as well.
+			s.execMu.Lock()
+			s.disconnectReplicas()
+			s.execMu.Unlock()
+
+			s.logger.Println("Loaded the RDB received from master")
+
+		case len(fields) >= 1 && fields[0] == "+CONTINUE":
+			s.repl.mu.Lock()
+			changed := len(fields) == 2 && fields[1] != s.repl.id
+			if changed {
+				// The master was promoted in the meantime, its former history is the secondary one now.
+				s.repl.id2 = s.repl.id
+				s.repl.secondOffset = s.repl.offset + 1
+				s.repl.id = fields[1]
 			}
+			s.repl.mu.Unlock()
 
-			s.logger.Printf("Master responded with: %q\n", string(buffer))
-		}
+			if changed {
+				// The sub-replicas reconnect to learn the new ID, and continue from the secondary one.
+				s.execMu.Lock()
+				s.disconnectReplicas()
+				s.execMu.Unlock()
+			}
 
+			s.logger.Println("Continuing replication from the master backlog")
+
+		default:
+			return fmt.Errorf("unexpected PSYNC response: %q", out)
+		}
 	}
 
 	return nil
 }
 
 func (s *Server) role() role {
-	if s.MasterHost == "" || s.MasterPort == "" {
+	host, port := s.masterHostPort()
+	if host == "" || port == "" {
 		return master
 	}
 
 	return slave
 }
 
-func (s *Server) replicate(cmd Command) error {
+// replicate feeds the effects of the commands the session executed to the AOF and, on a master, to the replicas.
+// It records the offset of the stream the session wrote up to, for WAIT.
+func (s *Server) replicate(session *Session) error {
+	propagated := session.takePropagated()
+	errs := []error{}
+	for _, p := range propagated {
+		err := s.propagate(p.db, p.cmd)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(propagated) > 0 {
+		_, session.writeOffset = s.replicationInfo()
+	}
+
+	return errors.Join(errs...)
+}
+
+// propagate appends the command to the AOF and sends it to every replica.
+// Commands operating on a single database pass its index, so that a SELECT
+// is injected whenever it changes; commands that do not depend on the
+// selected database pass -1. The replicas get the command even if the AOF
+// could not be written, since it was applied to the dataset already.
+func (s *Server) propagate(db int, cmd Command) error {
+	aofErr := s.feedAOF(db, cmd)
+
 	if s.role() == slave {
-		return nil
+		return aofErr
 	}
 
-	switch cmd.Type {
-	case Set:
-		fmt.Printf("Replicating: %q\n", cmd.value.Format())
-		for i := 0; i < len(s.replicas); i++ {
-			err := cmd.Write(s.replicas[i].connection)
-			if err != nil {
-				return err
+	if db >= 0 && db != s.replicationDB {
+		err := s.feedReplicas(NewCommandFromArgs(string(Select), strconv.Itoa(db)))
+		if err != nil {
+			return errors.Join(aofErr, err)
+		}
+		s.replicationDB = db
+	}
+
+	return errors.Join(aofErr, s.feedReplicas(cmd))
+}
+
+// activeExpireLoop deletes expired keys that are not accessed anymore. Only
+// the master expires keys; replicas receive the deletions as DEL commands.
+func (s *Server) activeExpireLoop(ctx context.Context) {
+	ticker := time.NewTicker(activeExpireInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if s.role() == master {
+				s.activeExpireCycle()
 			}
+		}
+	}
+}
 
-			s.replicas[i].offset += len([]byte(cmd.value.Format()))
+// activeExpireCycle samples keys with an expiry in every database, and keeps
+// sampling a database while more than a quarter of the sampled keys had expired.
+func (s *Server) activeExpireCycle() {
+	s.execMu.Lock()
+	defer s.execMu.Unlock()
+
+	// Expiring keys changes the dataset, which must not happen while clients are paused.
+	if s.pause.paused() {
+		return
+	}
+
+	for db := 0; db < s.client.Databases(); db++ {
+		for {
+			deleted := s.client.ActiveExpire(db, activeExpireSample)
+			for _, key := range deleted {
+				err := s.propagate(db, NewCommandFromArgs(string(Del), key))
+				if err != nil {
+					s.logger.Println("Failed to replicate", err)
+				}
+			}
+
+			if len(deleted) <= activeExpireSample/4 {
+				break
+			}
 		}
+	}
+}
 
-		return nil
-	default:
-		return nil
+func (s *Server) feedReplicas(cmd Command) error {
+	s.writeStream([]byte(cmd.value.Format()))
+
+	return nil
+}
+
+// forwardStream records bytes of the stream received from the master as
+// processed, and passes them on unchanged to the sub-replicas, so that the
+// whole chain shares the replication ID and offsets of the master.
+func (s *Server) forwardStream(data []byte) {
+	s.execMu.Lock()
+	defer s.execMu.Unlock()
+
+	s.writeStream(data)
+}
+
+// writeStream appends bytes to the replication stream and sends them to every replica.
+// It must be called with execMu held.
+func (s *Server) writeStream(data []byte) {
+	s.feedStream(data)
+	for _, replica := range s.replicas {
+		err := replica.write(data)
+		if err != nil {
+			// The replica is dropped once its connection loop notices the failure.
+			s.logger.Printf("Failed to replicate to %s: %v\n", replica.connection.RemoteAddr(), err)
+		}
 	}
 }
@@ -0,0 +1,102 @@
+package redis_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+// testConn is a connection to a server started by startServer.
+type testConn struct {
+	t          *testing.T
+	address    string
+	connection net.Conn
+	resp       *redis.Resp
+}
+
+// startServer serves on a free local port, with its files in a temporary directory, until
+// the test ends. It returns the connection it waited for the server to accept.
+func startServer(t *testing.T, opts ...func(*redis.Server)) *testConn {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	listener.Close()
+
+	databases := make([]redis.Store, 16)
+	for i := range databases {
+		databases[i] = redis.NewInMemoryStore()
+	}
+	client := redis.NewClient(databases)
+	opts = append([]func(*redis.Server){redis.WithRDB(t.TempDir(), "dump.rdb")}, opts...)
+	server := redis.NewServer(client, "127.0.0.1", "", port, "", opts...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() { stopped <- server.ListenAndServe(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		assert.NoError(t, <-stopped)
+	})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		connection, err := net.Dial("tcp", server.Address())
+		if err == nil {
+			return newTestConn(t, server.Address(), connection)
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server did not start: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func newTestConn(t *testing.T, address string, connection net.Conn) *testConn {
+	t.Cleanup(func() { connection.Close() })
+	return &testConn{t: t, address: address, connection: connection, resp: redis.NewResp(connection)}
+}
+
+// dial opens another connection to the server of c.
+func (c *testConn) dial() *testConn {
+	connection, err := net.Dial("tcp", c.address)
+	if !assert.NoError(c.t, err) {
+		c.t.FailNow()
+	}
+	return newTestConn(c.t, c.address, connection)
+}
+
+// do sends a command and returns its reply.
+func (c *testConn) do(args ...string) redis.Value {
+	c.send(args...)
+	return c.reply()
+}
+
+func (c *testConn) send(args ...string) {
+	assert.NoError(c.t, redis.NewCommandFromArgs(args...).Write(c.connection))
+}
+
+func (c *testConn) reply() redis.Value {
+	reply, ok := c.replyWithin(5 * time.Second)
+	assert.True(c.t, ok, "no reply")
+	return reply
+}
+
+// replyWithin reads a reply, and reports whether one arrived within wait.
+func (c *testConn) replyWithin(wait time.Duration) (redis.Value, bool) {
+	c.connection.SetReadDeadline(time.Now().Add(wait))
+	reply, err := c.resp.Read()
+	return reply, err == nil
+}
+
+// closed reports whether the server closed the connection, rather than answer a PING.
+func (c *testConn) closed() bool {
+	redis.NewCommandFromArgs("PING").Write(c.connection)
+	_, ok := c.replyWithin(5 * time.Second)
+	return !ok
+}
@@ -0,0 +1,135 @@
+package redis
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// Session holds the per-connection state commands run against.
+type Session struct {
+	// id, connection and createdAt are set once the session is registered, see sessionRegistry.
+	id         int64
+	connection net.Conn
+	createdAt  time.Time
+	// output queues the replies to the session, it is nil for sessions not served on a connection.
+	output *outputBuffer
+
+	db int
+	// master is set on the connection a replica receives the replication stream on.
+	master bool
+	// replaying is set on the session the AOF is loaded with.
+	replaying bool
+	// replica is set on connections of replicas, once they were synchronized.
+	replica *replica
+
+	// Set by a replica through REPLCONF before it asks for PSYNC.
+	replicaListeningPort string
+	replicaCapaEOF       bool
+
+	// asking is set by ASKING, it lets the next command access a slot being imported.
+	asking bool
+	// rejected is set when the current command was refused before running, for the command statistics.
+	rejected bool
+
+	// propagated collects the commands replicating the effects of the commands executed,
+	// until the server takes them.
+	propagated []propagation
+	// writeOffset is the replication offset right after the last write of the session.
+	writeOffset int
+	// closeAfterReply is set when the session killed its own connection, which closes once the reply is sent.
+	closeAfterReply bool
+
+	// info is what CLIENT LIST shows of the session, guarded by infoMu as other connections read it.
+	infoMu sync.Mutex
+	info   sessionInfo
+}
+
+// sessionInfo is a snapshot of the session, taken around each command.
+type sessionInfo struct {
+	name       string
+	libName    string
+	libVersion string
+	noEvict    bool
+	// blocked is set while the session waits in a command, such as WAIT.
+	blocked bool
+
+	db              int
+	master          bool
+	replica         bool
+	lastCommand     string
+	lastInteraction time.Time
+	queryBuffer     int
+	queryBufferFree int
+}
+
+// propagation is a command to replicate, with the database it applies to, or -1 if it does not depend on one.
+type propagation struct {
+	db  int
+	cmd Command
+}
+
+func (s *Session) alsoPropagate(db int, cmd Command) {
+	s.propagated = append(s.propagated, propagation{db: db, cmd: cmd})
+}
+
+func (s *Session) takePropagated() []propagation {
+	propagated := s.propagated
+	s.propagated = nil
+	return propagated
+}
+
+func NewSession() *Session {
+	return &Session{db: 0}
+}
+
+// commandStarted records the command the session runs, with what is left of its query buffer.
+func (s *Session) commandStarted(cmd Command, resp *Resp) {
+	buffered, free := resp.Buffered()
+
+	s.infoMu.Lock()
+	defer s.infoMu.Unlock()
+
+	s.info.lastCommand = commandName(cmd)
+	s.info.lastInteraction = time.Now()
+	s.info.queryBuffer = buffered
+	s.info.queryBufferFree = free
+	s.snapshot()
+}
+
+func (s *Session) commandDone() {
+	s.infoMu.Lock()
+	defer s.infoMu.Unlock()
+
+	s.info.lastInteraction = time.Now()
+	s.snapshot()
+}
+
+// snapshot copies the state the connection's own goroutine changes, infoMu held.
+func (s *Session) snapshot() {
+	s.info.db = s.db
+	s.info.master = s.master
+	s.info.replica = s.replica != nil
+}
+
+func (s *Session) setBlocked(blocked bool) {
+	s.setInfo(func(info *sessionInfo) { info.blocked = blocked })
+}
+
+func (s *Session) setInfo(update func(info *sessionInfo)) {
+	s.infoMu.Lock()
+	defer s.infoMu.Unlock()
+
+	update(&s.info)
+}
+
+func (s *Session) currentInfo() sessionInfo {
+	s.infoMu.Lock()
+	defer s.infoMu.Unlock()
+
+	return s.info
+}
+
+func (s *Session) DB() int {
+	return s.db
+}
@@ -0,0 +1,810 @@
+package redis
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type pausePurpose int
+
+const (
+	pauseByClient pausePurpose = iota
+	pauseByFailover
+	pauseByShutdown
+	pausePurposes
+)
+
+// pauseState is what a pause holds back, and until when. A zero end lasts until unpaused.
+type pauseState struct {
+	active bool
+	all    bool
+	end    time.Time
+}
+
+func (p pauseState) holds(write bool, now time.Time) bool {
+	return p.active && (p.end.IsZero() || now.Before(p.end)) && (p.all || write)
+}
+
+// clientPause holds back client commands while the dataset must not change:
+// their writes, or every command for CLIENT PAUSE ALL. CLIENT PAUSE and
+// failovers pause independently, the strictest pause applies.
+type clientPause struct {
+	mu       sync.Mutex
+	purposes [pausePurposes]pauseState
+	// changed is closed when a pause changes or is lifted, it is nil while nobody waits.
+	changed chan struct{}
+}
+
+// pause holds back writes, or every command if all is set, until end or until unpaused.
+// Pausing again for the same purpose replaces what is held back but never shortens the pause.
+func (p *clientPause) pause(purpose pausePurpose, all bool, end time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	state := &p.purposes[purpose]
+	if state.holds(true, time.Now()) && !end.IsZero() && (state.end.IsZero() || end.Before(state.end)) {
+		end = state.end
+	}
+	*state = pauseState{active: true, all: all, end: end}
+	p.notify()
+}
+
+func (p *clientPause) unpause(purpose pausePurpose) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.purposes[purpose] = pauseState{}
+	p.notify()
+}
+
+// notify wakes up the commands waiting for the pauses to change, mu held.
+func (p *clientPause) notify() {
+	if p.changed != nil {
+		close(p.changed)
+		p.changed = nil
+	}
+}
+
+// paused reports whether any pause is in effect, during which the dataset must not change.
+func (p *clientPause) paused() bool {
+	return p.holds(true)
+}
+
+// holds reports whether a command, a write or not, would be held back.
+func (p *clientPause) holds(write bool) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	for _, state := range p.purposes {
+		if state.holds(write, now) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// wait blocks while a command, a write or not, is held back. It returns false if done is closed first.
+func (p *clientPause) wait(write bool, done <-chan struct{}) bool {
+	for {
+		p.mu.Lock()
+		now := time.Now()
+		held := false
+		var next time.Time
+		for _, state := range p.purposes {
+			if !state.holds(write, now) {
+				continue
+			}
+			held = true
+			if !state.end.IsZero() && (next.IsZero() || state.end.Before(next)) {
+				next = state.end
+			}
+		}
+		if !held {
+			p.mu.Unlock()
+			return true
+		}
+		if p.changed == nil {
+			p.changed = make(chan struct{})
+		}
+		changed := p.changed
+		p.mu.Unlock()
+
+		var expired <-chan time.Time
+		var timer *time.Timer
+		if !next.IsZero() {
+			timer = time.NewTimer(next.Sub(now))
+			expired = timer.C
+		}
+
+		select {
+		case <-changed:
+		case <-expired:
+		case <-done:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		select {
+		case <-done:
+			return false
+		default:
+		}
+	}
+}
+
+// waitPause holds a client command back while clients are paused. The
+// replication links are never paused, nor are the CLIENT commands, so that
+// CLIENT UNPAUSE gets through, or SHUTDOWN, so that SHUTDOWN ABORT does. It
+// returns false if the server shuts down in the meantime.
+func (s *Server) waitPause(session *Session, cmd Command) bool {
+	if session.master || session.replica != nil {
+		return true
+	}
+	switch cmd.Type {
+	case ReplConf, PSync, ClientCommand, Shutdown:
+		return true
+	}
+
+	write := cmd.IsWrite()
+	if !s.pause.holds(write) {
+		return true
+	}
+
+	s.stats.blockedClients.Add(1)
+	session.setBlocked(true)
+	defer s.stats.blockedClients.Add(-1)
+	defer session.setBlocked(false)
+	return s.pause.wait(write, s.ctx.Done())
+}
+
+// sessionRegistry tracks the connections being served, for the CLIENT commands.
+type sessionRegistry struct {
+	mu       sync.Mutex
+	lastID   int64
+	sessions map[int64]*Session
+	// clients counts the accepted connections, from accept until remove, the ones still
+	// starting up included. The connection to the master is not one of them.
+	clients int
+	// outputLimits are the limits of the output buffers of the sessions.
+	outputLimits OutputBufferLimits
+	// idleTimeout closes the connections of normal clients idle for longer, keepAlive is the TCP
+	// keep-alive period of new connections, and maxClients bounds the connections served.
+	// Zero durations are disabled.
+	idleTimeout time.Duration
+	keepAlive   time.Duration
+	maxClients  int
+}
+
+const (
+	DefaultTCPKeepAlive = 300 * time.Second
+	DefaultMaxClients   = 10000
+	DefaultTCPBacklog   = 511
+
+	// clientsCronInterval is how often idle clients are looked for.
+	clientsCronInterval = time.Second
+)
+
+// WithClientTimeout closes the connections of clients idle for longer than timeout, zero disables it.
+// Replicas, the master and blocked clients are never closed.
+func WithClientTimeout(timeout time.Duration) func(*Server) {
+	return func(s *Server) {
+		s.sessions.idleTimeout = timeout
+	}
+}
+
+// WithTCPKeepAlive sets the period of TCP keep-alive probes on accepted connections, zero disables them.
+func WithTCPKeepAlive(period time.Duration) func(*Server) {
+	return func(s *Server) {
+		s.sessions.keepAlive = period
+	}
+}
+
+// WithMaxClients bounds the number of connections served, beyond which new ones are refused.
+func WithMaxClients(maxClients int) func(*Server) {
+	return func(s *Server) {
+		s.sessions.maxClients = maxClients
+	}
+}
+
+// add assigns the session an ID, and makes it visible to CLIENT LIST and CLIENT KILL.
+func (r *sessionRegistry) add(session *Session, connection net.Conn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lastID++
+	session.id = r.lastID
+	session.connection = connection
+	session.createdAt = time.Now()
+	session.setInfo(func(info *sessionInfo) {
+		info.lastCommand = "NULL"
+		info.lastInteraction = session.createdAt
+		session.snapshot()
+	})
+
+	if r.sessions == nil {
+		r.sessions = map[int64]*Session{}
+	}
+	r.sessions[session.id] = session
+}
+
+func (r *sessionRegistry) outputLimit(clientType string) OutputBufferLimit {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if limit := r.outputLimits.class(clientType); limit != nil {
+		return *limit
+	}
+	return OutputBufferLimit{}
+}
+
+func (r *sessionRegistry) setOutputLimits(limits OutputBufferLimits) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.outputLimits = limits
+}
+
+// accept prepares a new connection, and reports whether there is room for it, in
+// which case the room is taken until the session is removed.
+func (r *sessionRegistry) accept(connection net.Conn) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if tcp, ok := connection.(*net.TCPConn); ok {
+		tcp.SetKeepAlive(r.keepAlive > 0)
+		if r.keepAlive > 0 {
+			tcp.SetKeepAlivePeriod(r.keepAlive)
+		}
+	}
+
+	if r.clients >= r.maxClients {
+		return false
+	}
+	r.clients++
+	return true
+}
+
+func (r *sessionRegistry) limit() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.maxClients
+}
+
+func (r *sessionRegistry) remove(session *Session) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.sessions, session.id)
+	if !session.master {
+		r.clients--
+	}
+}
+
+func (r *sessionRegistry) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.sessions)
+}
+
+// list returns the sessions by ID.
+func (r *sessionRegistry) list() []*Session {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sessions := make([]*Session, 0, len(r.sessions))
+	for _, session := range r.sessions {
+		sessions = append(sessions, session)
+	}
+	slices.SortFunc(sessions, func(a, b *Session) int {
+		return cmp.Compare(a.id, b.id)
+	})
+
+	return sessions
+}
+
+// clientsCron closes the connections of idle clients, and of clients above their output buffer soft limit for too long.
+func (s *Server) clientsCron(ctx context.Context) {
+	ticker := time.NewTicker(clientsCronInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.closeIdleClients(now)
+			for _, session := range s.sessions.list() {
+				session.output.checkLimit(now)
+			}
+		}
+	}
+}
+
+// closeIdleClients closes the connections of the normal clients that did not send
+// a command for longer than the timeout. Clients blocked in a command are not idle.
+func (s *Server) closeIdleClients(now time.Time) {
+	s.sessions.mu.Lock()
+	timeout := s.sessions.idleTimeout
+	s.sessions.mu.Unlock()
+	if timeout == 0 {
+		return
+	}
+
+	for _, session := range s.sessions.list() {
+		info := session.currentInfo()
+		if info.clientType() != clientNormal || info.blocked || now.Sub(info.lastInteraction) <= timeout {
+			continue
+		}
+
+		s.logger.Printf("Closing idle client %s\n", session.connection.RemoteAddr())
+		session.connection.Close()
+	}
+}
+
+var errClientKilled = errors.New("killed by CLIENT KILL")
+
+// Client types, as CLIENT LIST and CLIENT KILL filter them.
+const (
+	clientNormal  = "normal"
+	clientMaster  = "master"
+	clientReplica = "replica"
+	clientPubSub  = "pubsub"
+)
+
+func parseClientType(name string) (string, bool) {
+	switch strings.ToLower(name) {
+	case clientNormal:
+		return clientNormal, true
+	case clientMaster:
+		return clientMaster, true
+	case clientReplica, "slave":
+		return clientReplica, true
+	case clientPubSub:
+		return clientPubSub, true
+	}
+
+	return "", false
+}
+
+func (i sessionInfo) clientType() string {
+	switch {
+	case i.master:
+		return clientMaster
+	case i.replica:
+		return clientReplica
+	default:
+		return clientNormal
+	}
+}
+
+func (i sessionInfo) flags() string {
+	flags := ""
+	if i.master {
+		flags += "M"
+	}
+	if i.replica {
+		flags += "S"
+	}
+	if i.blocked {
+		flags += "b"
+	}
+	if i.noEvict {
+		flags += "e"
+	}
+	if flags == "" {
+		flags = "N"
+	}
+
+	return flags
+}
+
+// describe formats the session the way CLIENT LIST and CLIENT INFO show it.
+func (s *Session) describe(now time.Time) string {
+	info := s.currentInfo()
+	outputWrites, outputMemory := 0, 0
+	if s.output != nil {
+		outputWrites, outputMemory = s.output.usage()
+	}
+	events := "r"
+	if outputMemory > 0 {
+		events = "rw"
+	}
+
+	return fmt.Sprintf("id=%d addr=%s laddr=%s name=%s age=%d idle=%d flags=%s db=%d sub=0 psub=0 multi=-1 "+
+		"qbuf=%d qbuf-free=%d obl=0 oll=%d omem=%d tot-mem=%d events=%s cmd=%s user=default lib-name=%s lib-ver=%s\n",
+		s.id, s.connection.RemoteAddr(), s.connection.LocalAddr(), info.name,
+		int64(now.Sub(s.createdAt).Seconds()), int64(now.Sub(info.lastInteraction).Seconds()), info.flags(), info.db,
+		info.queryBuffer, info.queryBufferFree, outputWrites, outputMemory,
+		info.queryBuffer+info.queryBufferFree+outputMemory, events, info.lastCommand, info.libName, info.libVersion)
+}
+
+// subcommandTypes are the commands whose first argument names a subcommand.
+var subcommandTypes = map[CommandType]bool{
+	Cfg:           true,
+	Cluster:       true,
+	Object:        true,
+	Memory:        true,
+	ClientCommand: true,
+}
+
+// commandName names a command as CLIENT LIST shows it, such as client|list for subcommands.
+func commandName(cmd Command) string {
+	if subcommandTypes[cmd.Type] && len(cmd.Args) > 0 {
+		return string(cmd.Type) + "|" + strings.ToLower(cmd.Args[0])
+	}
+
+	return string(cmd.Type)
+}
+
+// validClientName reports whether a name can show in CLIENT LIST, which separates fields by spaces.
+func validClientName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] < '!' || name[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// clientFilter selects the clients CLIENT KILL closes.
+type clientFilter struct {
+	id         int64
+	addr       string
+	localAddr  string
+	user       string
+	clientType string
+	maxAge     time.Duration
+	skipMe     bool
+}
+
+func (f clientFilter) matches(session *Session, now time.Time) bool {
+	info := session.currentInfo()
+	switch {
+	case f.id != 0 && session.id != f.id:
+		return false
+	case f.addr != "" && session.connection.RemoteAddr().String() != f.addr:
+		return false
+	case f.localAddr != "" && session.connection.LocalAddr().String() != f.localAddr:
+		return false
+	// Every client is authenticated as the default user.
+	case f.user != "" && f.user != "default":
+		return false
+	case f.clientType != "" && info.clientType() != f.clientType:
+		return false
+	case f.maxAge != 0 && now.Sub(session.createdAt) < f.maxAge:
+		return false
+	}
+
+	return true
+}
+
+// parseClientFilter parses the filters of CLIENT KILL <filter> <value> ...
+func parseClientFilter(args []string) (clientFilter, Value, bool) {
+	filter := clientFilter{skipMe: true}
+	for i := 0; i+1 < len(args); i += 2 {
+		value := args[i+1]
+		switch strings.ToLower(args[i]) {
+		case "id":
+			id, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || id <= 0 {
+				return filter, errorValue("ERR client-id should be greater than 0"), false
+			}
+			filter.id = id
+		case "addr":
+			filter.addr = value
+		case "laddr":
+			filter.localAddr = value
+		case "user":
+			filter.user = value
+		case "type":
+			clientType, ok := parseClientType(value)
+			if !ok {
+				return filter, errorValue("ERR Unknown client type '%s'", value), false
+			}
+			filter.clientType = clientType
+		case "skipme":
+			switch strings.ToLower(value) {
+			case "yes":
+				filter.skipMe = true
+			case "no":
+				filter.skipMe = false
+			default:
+				return filter, errorValue("ERR syntax error"), false
+			}
+		case "maxage":
+			seconds, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || seconds <= 0 {
+				return filter, errorValue("ERR value is not an integer or out of range"), false
+			}
+			filter.maxAge = time.Duration(seconds) * time.Second
+		default:
+			return filter, errorValue("ERR syntax error"), false
+		}
+	}
+
+	return filter, Value{}, true
+}
+
+var clientHelp = []string{
+	"CLIENT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"GETNAME",
+	"    Return the name of the current connection.",
+	"ID",
+	"    Return the ID of the current connection.",
+	"INFO",
+	"    Return information about the current client connection.",
+	"KILL <ip:port>",
+	"    Kill connection made from <ip:port>.",
+	"KILL <option> <value> [<option> <value> [...]]",
+	"    Kill connections. Options are:",
+	"    * ADDR (<ip:port>|<unixsocket>:0)",
+	"      Kill connections made from the specified address",
+	"    * LADDR (<ip:port>|<unixsocket>:0)",
+	"      Kill connections made to specified local address",
+	"    * TYPE (NORMAL|MASTER|REPLICA|PUBSUB)",
+	"      Kill connections by type.",
+	"    * USER <username>",
+	"      Kill connections authenticated by <username>.",
+	"    * SKIPME (YES|NO)",
+	"      Skip killing current connection (default: yes).",
+	"    * ID <client-id>",
+	"      Kill connections by client id.",
+	"    * MAXAGE <maxage>",
+	"      Kill connections older than the specified age.",
+	"LIST [options ...]",
+	"    Return information about client connections. Options:",
+	"    * TYPE (NORMAL|MASTER|REPLICA|PUBSUB)",
+	"      Return clients of specified type.",
+	"    * ID <client-id> [<client-id> ...]",
+	"      Return clients of specified IDs only.",
+	"NO-EVICT (ON|OFF)",
+	"    Protect current client connection from eviction.",
+	"PAUSE <timeout> [WRITE|ALL]",
+	"    Suspend all, or just write, clients for <timeout> milliseconds.",
+	"UNPAUSE",
+	"    Stop the current client pause, resuming traffic.",
+	"SETINFO <option> <value>",
+	"    Set client meta attr. Options are:",
+	"    * LIB-NAME: the client lib name.",
+	"    * LIB-VER: the client lib version.",
+	"SETNAME <name>",
+	"    Assign the name <name> to the current connection.",
+	"HELP",
+	"    Print this help.",
+}
+
+// clientCommand implements CLIENT ID, GETNAME, SETNAME, SETINFO, INFO, LIST, KILL,
+// PAUSE, UNPAUSE, NO-EVICT and HELP.
+func (s *Server) clientCommand(session *Session, cmd Command) Value {
+	if len(cmd.Args) == 0 {
+		return wrongArgumentsError(cmd)
+	}
+
+	subcommand := strings.ToLower(cmd.Args[0])
+	arityError := errorValue("ERR wrong number of arguments for 'client|%s' command", subcommand)
+	now := time.Now()
+	switch subcommand {
+	case "id":
+		if len(cmd.Args) != 1 {
+			return arityError
+		}
+		return Value{Type: Number, Number: int(session.id)}
+
+	case "getname":
+		if len(cmd.Args) != 1 {
+			return arityError
+		}
+		if name := session.currentInfo().name; name != "" {
+			return Value{Type: Bulk, Bulk: name}
+		}
+		return Value{Type: NullBulk}
+
+	case "setname":
+		if len(cmd.Args) != 2 {
+			return arityError
+		}
+		name := cmd.Args[1]
+		if !validClientName(name) {
+			return errorValue("ERR Client names cannot contain spaces, newlines or special characters.")
+		}
+		session.setInfo(func(info *sessionInfo) { info.name = name })
+		return Value{Type: SimpleString, SimpleString: "OK"}
+
+	case "setinfo":
+		if len(cmd.Args) != 3 {
+			return arityError
+		}
+		attribute, value := strings.ToLower(cmd.Args[1]), cmd.Args[2]
+		if attribute != "lib-name" && attribute != "lib-ver" {
+			return errorValue("ERR Unrecognized option '%s'", cmd.Args[1])
+		}
+		if !validClientName(value) {
+			return errorValue("ERR %s cannot contain spaces, newlines or special characters.", attribute)
+		}
+		session.setInfo(func(info *sessionInfo) {
+			if attribute == "lib-name" {
+				info.libName = value
+			} else {
+				info.libVersion = value
+			}
+		})
+		return Value{Type: SimpleString, SimpleString: "OK"}
+
+	case "info":
+		if len(cmd.Args) != 1 {
+			return arityError
+		}
+		return Value{Type: Bulk, Bulk: session.describe(now)}
+
+	case "list":
+		return s.clientList(cmd.Args[1:], now)
+
+	case "kill":
+		if len(cmd.Args) < 2 {
+			return arityError
+		}
+		return s.clientKill(session, cmd.Args[1:], now)
+
+	case "pause":
+		if len(cmd.Args) != 2 && len(cmd.Args) != 3 {
+			return arityError
+		}
+		timeout, err := strconv.ParseInt(cmd.Args[1], 10, 64)
+		if err != nil {
+			return errorValue("ERR timeout is not an integer or out of range")
+		}
+		if timeout < 0 {
+			return errorValue("ERR timeout is negative")
+		}
+		if timeout > int64(math.MaxInt64/time.Millisecond) {
+			return errorValue("ERR timeout is out of range")
+		}
+
+		all := true
+		if len(cmd.Args) == 3 {
+			switch strings.ToLower(cmd.Args[2]) {
+			case "all":
+			case "write":
+				all = false
+			default:
+				return errorValue("ERR syntax error")
+			}
+		}
+		s.pause.pause(pauseByClient, all, now.Add(time.Duration(timeout)*time.Millisecond))
+		return Value{Type: SimpleString, SimpleString: "OK"}
+
+	case "unpause":
+		if len(cmd.Args) != 1 {
+			return arityError
+		}
+		s.pause.unpause(pauseByClient)
+		return Value{Type: SimpleString, SimpleString: "OK"}
+
+	case "no-evict":
+		if len(cmd.Args) != 2 {
+			return arityError
+		}
+		var noEvict bool
+		switch strings.ToLower(cmd.Args[1]) {
+		case "on":
+			noEvict = true
+		case "off":
+			noEvict = false
+		default:
+			return errorValue("ERR syntax error")
+		}
+		session.setInfo(func(info *sessionInfo) { info.noEvict = noEvict })
+		return Value{Type: SimpleString, SimpleString: "OK"}
+
+	case "help":
+		return bulkFields(clientHelp...)
+	}
+
+	return errorValue("ERR unknown subcommand '%s'. Try CLIENT HELP.", cmd.Args[0])
+}
+
+// clientList implements CLIENT LIST [TYPE type] [ID id ...].
+func (s *Server) clientList(args []string, now time.Time) Value {
+	var clientType string
+	var ids []int64
+	for i := 0; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "type":
+			if i+1 >= len(args) {
+				return errorValue("ERR syntax error")
+			}
+			var ok bool
+			clientType, ok = parseClientType(args[i+1])
+			if !ok {
+				return errorValue("ERR Unknown client type '%s'", args[i+1])
+			}
+			i++
+		case "id":
+			if i+1 >= len(args) {
+				return errorValue("ERR syntax error")
+			}
+			for i+1 < len(args) {
+				id, err := strconv.ParseInt(args[i+1], 10, 64)
+				if err != nil || id <= 0 {
+					return errorValue("ERR Invalid client ID")
+				}
+				ids = append(ids, id)
+				i++
+			}
+		default:
+			return errorValue("ERR syntax error")
+		}
+	}
+
+	var list strings.Builder
+	for _, session := range s.sessions.list() {
+		if clientType != "" && session.currentInfo().clientType() != clientType {
+			continue
+		}
+		if ids != nil && !slices.Contains(ids, session.id) {
+			continue
+		}
+		list.WriteString(session.describe(now))
+	}
+
+	return Value{Type: Bulk, Bulk: list.String()}
+}
+
+// clientKill implements CLIENT KILL addr, and CLIENT KILL filter value ... which
+// replies with the number of clients killed. The connections of other clients
+// are closed right away, the connection of the current one once it got the reply.
+func (s *Server) clientKill(session *Session, args []string, now time.Time) Value {
+	legacy := len(args) == 1
+	filter := clientFilter{addr: args[0]}
+	if !legacy {
+		if len(args)%2 != 0 {
+			return errorValue("ERR syntax error")
+		}
+
+		var reply Value
+		var ok bool
+		filter, reply, ok = parseClientFilter(args)
+		if !ok {
+			return reply
+		}
+	}
+
+	killed := 0
+	for _, target := range s.sessions.list() {
+		if !filter.matches(target, now) {
+			continue
+		}
+		if target == session {
+			if filter.skipMe {
+				continue
+			}
+			session.closeAfterReply = true
+		} else {
+			target.connection.Close()
+		}
+		killed++
+	}
+
+	if legacy {
+		if killed == 0 {
+			return errorValue("ERR No such client")
+		}
+		return Value{Type: SimpleString, SimpleString: "OK"}
+	}
+
+	return Value{Type: Number, Number: killed}
+}
@@ -0,0 +1,219 @@
+package redis_test
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+var okReply = redis.Value{Type: redis.SimpleString, SimpleString: "OK"}
+
+func TestClientRegistry(t *testing.T) {
+	first := startServer(t)
+	second := first.dial()
+
+	firstID := first.do("CLIENT", "ID").Number
+	secondID := second.do("CLIENT", "ID").Number
+	assert.NotEqual(t, firstID, secondID)
+
+	assert.Equal(t, okReply, second.do("CLIENT", "SETNAME", "worker"))
+	assert.Equal(t, redis.Value{Type: redis.Bulk, Bulk: "worker"}, second.do("CLIENT", "GETNAME"))
+	assert.Equal(t, redis.Value{Type: redis.NullBulk}, first.do("CLIENT", "GETNAME"))
+	assert.Equal(t, redis.Value{Type: redis.Error, Error: "ERR Client names cannot contain spaces, newlines or special characters."},
+		second.do("CLIENT", "SETNAME", "two words"))
+
+	list := first.do("CLIENT", "LIST").Bulk
+	assert.Equal(t, 2, strings.Count(list, "\n"))
+	assert.Contains(t, list, fmt.Sprintf("id=%d addr=%s", firstID, first.connection.LocalAddr()))
+	assert.Contains(t, list, "cmd=client|list")
+
+	list = first.do("CLIENT", "LIST", "ID", strconv.Itoa(secondID)).Bulk
+	assert.Equal(t, 1, strings.Count(list, "\n"))
+	assert.Contains(t, list, fmt.Sprintf("id=%d addr=%s", secondID, second.connection.LocalAddr()))
+	assert.Contains(t, list, "name=worker")
+	assert.Contains(t, list, "cmd=client|setname")
+
+	assert.Equal(t, "", first.do("CLIENT", "LIST", "TYPE", "master").Bulk)
+
+	second.connection.Close()
+	assert.Eventually(t, func() bool {
+		return strings.Count(first.do("CLIENT", "LIST").Bulk, "\n") == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestClientKill(t *testing.T) {
+	tests := []struct {
+		name string
+		// args are the filters, given the ID and address of the other client.
+		args       func(id int, addr string) []string
+		want       redis.Value
+		killed     bool
+		killedSelf bool
+	}{
+		{
+			name:   "by address",
+			args:   func(id int, addr string) []string { return []string{addr} },
+			want:   okReply,
+			killed: true,
+		},
+		{
+			name: "by unknown address",
+			args: func(id int, addr string) []string { return []string{"127.0.0.1:1"} },
+			want: redis.Value{Type: redis.Error, Error: "ERR No such client"},
+		},
+		{
+			name:   "by ID",
+			args:   func(id int, addr string) []string { return []string{"ID", strconv.Itoa(id)} },
+			want:   redis.Value{Type: redis.Number, Number: 1},
+			killed: true,
+		},
+		{
+			name:   "by ADDR",
+			args:   func(id int, addr string) []string { return []string{"ADDR", addr} },
+			want:   redis.Value{Type: redis.Number, Number: 1},
+			killed: true,
+		},
+		{
+			name:   "by user, skipping the caller",
+			args:   func(id int, addr string) []string { return []string{"USER", "default"} },
+			want:   redis.Value{Type: redis.Number, Number: 1},
+			killed: true,
+		},
+		{
+			name: "by unknown user",
+			args: func(id int, addr string) []string { return []string{"USER", "nobody"} },
+			want: redis.Value{Type: redis.Number, Number: 0},
+		},
+		{
+			name: "by type",
+			args: func(id int, addr string) []string { return []string{"TYPE", "replica"} },
+			want: redis.Value{Type: redis.Number, Number: 0},
+		},
+		{
+			name:       "without skipping the caller",
+			args:       func(id int, addr string) []string { return []string{"TYPE", "normal", "SKIPME", "no"} },
+			want:       redis.Value{Type: redis.Number, Number: 2},
+			killed:     true,
+			killedSelf: true,
+		},
+		{
+			name: "every filter must match",
+			args: func(id int, addr string) []string { return []string{"ID", strconv.Itoa(id), "MAXAGE", "3600"} },
+			want: redis.Value{Type: redis.Number, Number: 0},
+		},
+		{
+			name: "invalid ID",
+			args: func(id int, addr string) []string { return []string{"ID", "0"} },
+			want: redis.Value{Type: redis.Error, Error: "ERR client-id should be greater than 0"},
+		},
+		{
+			name: "unknown type",
+			args: func(id int, addr string) []string { return []string{"TYPE", "bogus"} },
+			want: redis.Value{Type: redis.Error, Error: "ERR Unknown client type 'bogus'"},
+		},
+		{
+			name: "missing value",
+			args: func(id int, addr string) []string { return []string{"ID", strconv.Itoa(id), "SKIPME"} },
+			want: redis.Value{Type: redis.Error, Error: "ERR syntax error"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			caller := startServer(t)
+			other := caller.dial()
+			id := other.do("CLIENT", "ID").Number
+
+			args := append([]string{"CLIENT", "KILL"}, tt.args(id, other.connection.LocalAddr().String())...)
+			assert.Equal(t, tt.want, caller.do(args...))
+			assert.Equal(t, tt.killed, other.closed())
+			assert.Equal(t, tt.killedSelf, caller.closed())
+		})
+	}
+}
+
+func TestClientPause(t *testing.T) {
+	t.Run("writes wait until unpaused", func(t *testing.T) {
+		admin := startServer(t)
+		client := admin.dial()
+
+		assert.Equal(t, okReply, admin.do("CLIENT", "PAUSE", "60000", "WRITE"))
+		assert.Equal(t, redis.Value{Type: redis.NullBulk}, client.do("GET", "key"))
+
+		client.send("SET", "key", "value")
+		_, replied := client.replyWithin(100 * time.Millisecond)
+		assert.False(t, replied)
+
+		assert.Equal(t, okReply, admin.do("CLIENT", "UNPAUSE"))
+		assert.Equal(t, okReply, client.reply())
+		assert.Equal(t, redis.Value{Type: redis.Bulk, Bulk: "value"}, client.do("GET", "key"))
+	})
+
+	t.Run("every command waits for the timeout", func(t *testing.T) {
+		admin := startServer(t)
+		client := admin.dial()
+
+		start := time.Now()
+		assert.Equal(t, okReply, admin.do("CLIENT", "PAUSE", "200"))
+		assert.Equal(t, redis.Value{Type: redis.SimpleString, SimpleString: "PONG"}, client.do("PING"))
+		assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+	})
+
+	t.Run("invalid timeouts", func(t *testing.T) {
+		admin := startServer(t)
+
+		for timeout, want := range map[string]string{
+			"-1":                  "ERR timeout is negative",
+			"soon":                "ERR timeout is not an integer or out of range",
+			"9223372036854775807": "ERR timeout is out of range",
+		} {
+			assert.Equal(t, redis.Value{Type: redis.Error, Error: want}, admin.do("CLIENT", "PAUSE", timeout), timeout)
+		}
+		assert.Equal(t, redis.Value{Type: redis.Error, Error: "ERR syntax error"}, admin.do("CLIENT", "PAUSE", "100", "READS"))
+
+		client := admin.dial()
+		assert.Equal(t, okReply, client.do("SET", "key", "value"))
+	})
+}
+
+func TestMaxClients(t *testing.T) {
+	rejected := redis.Value{Type: redis.Error, Error: "ERR max number of clients reached"}
+	pong := redis.Value{Type: redis.SimpleString, SimpleString: "PONG"}
+
+	t.Run("connections beyond the limit are refused", func(t *testing.T) {
+		first := startServer(t, redis.WithMaxClients(2))
+		assert.Equal(t, pong, first.dial().do("PING"))
+		assert.Equal(t, rejected, first.dial().reply())
+	})
+
+	t.Run("a burst of connections", func(t *testing.T) {
+		first := startServer(t, redis.WithMaxClients(2))
+
+		// The connections are all opened before any of them sends a command.
+		connections := []*testConn{}
+		for range 10 {
+			connections = append(connections, first.dial())
+		}
+
+		served := 0
+		for _, c := range connections {
+			if c.do("PING").SimpleString == "PONG" {
+				served++
+			}
+		}
+		assert.Equal(t, 1, served)
+	})
+
+	t.Run("the master link is not a client", func(t *testing.T) {
+		master := startServer(t)
+		replica := startServer(t, redis.WithMaxClients(2))
+		replicate(t, replica, master)
+
+		assert.Equal(t, pong, replica.dial().do("PING"))
+	})
+}
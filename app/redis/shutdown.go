@@ -0,0 +1,285 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultShutdownTimeout is how long a shutdown waits for the replicas to catch up.
+const DefaultShutdownTimeout = 10 * time.Second
+
+var (
+	errShutdownAborted    = errors.New("shutdown aborted")
+	errShutdownInProgress = errors.New("shutdown already in progress")
+	// errServerShutdown closes the connection that shut the server down, without a reply.
+	errServerShutdown = errors.New("server shut down")
+)
+
+// WithShutdownTimeout sets how long a shutdown waits for the replicas to catch up, zero does not wait.
+func WithShutdownTimeout(timeout time.Duration) func(*Server) {
+	return func(s *Server) {
+		s.shutdown.timeout = timeout
+	}
+}
+
+// shutdownFlags are the options of SHUTDOWN. Without SAVE or NOSAVE, the dataset
+// is saved if save points are configured.
+type shutdownFlags struct {
+	save   bool
+	noSave bool
+	// now does not wait for the replicas, force shuts down even if saving fails.
+	now   bool
+	force bool
+}
+
+type shutdownState struct {
+	mu      sync.Mutex
+	timeout time.Duration
+	// inProgress is set from the start of a shutdown, until it fails or is aborted.
+	inProgress bool
+	// abort is closed by SHUTDOWN ABORT.
+	abort chan struct{}
+}
+
+func (s *Server) shuttingDown() bool {
+	s.shutdown.mu.Lock()
+	defer s.shutdown.mu.Unlock()
+
+	return s.shutdown.inProgress
+}
+
+// shutdownCommand implements SHUTDOWN [NOSAVE|SAVE] [NOW] [FORCE] [ABORT]. The
+// connection is closed without a reply once the server shuts down.
+func (s *Server) shutdownCommand(cmd Command) (Value, error) {
+	var flags shutdownFlags
+	abort := false
+	for _, arg := range cmd.Args {
+		switch strings.ToLower(arg) {
+		case "nosave":
+			flags.noSave = true
+		case "save":
+			flags.save = true
+		case "now":
+			flags.now = true
+		case "force":
+			flags.force = true
+		case "abort":
+			abort = true
+		default:
+			return errorValue("ERR syntax error"), nil
+		}
+	}
+	if flags.save && flags.noSave || abort && len(cmd.Args) > 1 {
+		return errorValue("ERR syntax error"), nil
+	}
+
+	if abort {
+		if !s.abortShutdown() {
+			return errorValue("ERR No shutdown in progress."), nil
+		}
+		return Value{Type: SimpleString, SimpleString: "OK"}, nil
+	}
+
+	if err := s.prepareShutdown(flags); err != nil {
+		if errors.Is(err, errShutdownInProgress) {
+			return errorValue("ERR Shutdown already in progress."), nil
+		}
+		return errorValue("ERR Errors trying to SHUTDOWN. Check logs."), nil
+	}
+
+	s.stop()
+	return Value{}, errServerShutdown
+}
+
+// prepareShutdown gets the server ready to stop: new connections are refused,
+// writes are paused while the replicas catch up, for up to the shutdown timeout,
+// then the dataset is saved and the AOF is synced to disk. Unless forced, the
+// server keeps running if any of it fails.
+func (s *Server) prepareShutdown(flags shutdownFlags) error {
+	s.shutdown.mu.Lock()
+	if s.shutdown.inProgress {
+		s.shutdown.mu.Unlock()
+		return errShutdownInProgress
+	}
+	s.shutdown.inProgress = true
+	abort := make(chan struct{})
+	s.shutdown.abort = abort
+	timeout := s.shutdown.timeout
+	s.shutdown.mu.Unlock()
+
+	err := s.persistBeforeShutdown(flags, timeout, abort)
+	if err != nil {
+		s.pause.unpause(pauseByShutdown)
+
+		s.shutdown.mu.Lock()
+		s.shutdown.inProgress = false
+		s.shutdown.abort = nil
+		s.shutdown.mu.Unlock()
+		return err
+	}
+
+	s.logger.Println("Redis is now ready to exit, bye bye...")
+	return nil
+}
+
+func (s *Server) persistBeforeShutdown(flags shutdownFlags, timeout time.Duration, abort <-chan struct{}) error {
+	s.logger.Println("User requested shutdown...")
+	s.pause.pause(pauseByShutdown, false, time.Time{})
+
+	if !flags.now && timeout > 0 {
+		lagging, err := s.waitReplicasCaughtUp(timeout, abort)
+		if err != nil {
+			s.logger.Println("Shutdown aborted")
+			return err
+		}
+		if lagging > 0 {
+			s.logger.Printf("%d replicas did not catch up before the shutdown timeout\n", lagging)
+		}
+	}
+
+	s.rdb.mu.Lock()
+	save := len(s.savePolicies) > 0
+	s.rdb.mu.Unlock()
+	if (save || flags.save) && !flags.noSave {
+		s.logger.Println("Saving the final RDB snapshot before exiting.")
+		if err := s.saveForShutdown(abort); err != nil {
+			if errors.Is(err, errShutdownAborted) {
+				s.logger.Println("Shutdown aborted")
+				return err
+			}
+			if !flags.force {
+				s.logger.Println("Error trying to save the DB, can't exit:", err)
+				return err
+			}
+			s.logger.Println("Error trying to save the DB, exiting anyway:", err)
+		}
+	}
+
+	if err := s.syncAOF(); err != nil {
+		if !flags.force {
+			s.logger.Println("Error trying to sync the AOF, can't exit:", err)
+			return err
+		}
+		s.logger.Println("Error trying to sync the AOF, exiting anyway:", err)
+	}
+
+	return nil
+}
+
+// waitReplicasCaughtUp waits until every replica acknowledged the current offset, and
+// returns how many did not before the timeout.
+func (s *Server) waitReplicasCaughtUp(timeout time.Duration, abort <-chan struct{}) (int, error) {
+	s.execMu.Lock()
+	_, offset := s.replicationInfo()
+	replicas := len(s.replicas)
+	if replicas > 0 {
+		if err := s.feedReplicas(NewCommandFromArgs(string(ReplConf), "GETACK", "*")); err != nil {
+			s.logger.Println("Failed to ask replicas for acknowledgements:", err)
+		}
+	}
+	s.execMu.Unlock()
+
+	if replicas > 0 {
+		s.logger.Printf("Waiting for replicas to reach offset %d before shutting down\n", offset)
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		count, acked := s.countAcked(offset)
+		lagging := s.replicaCount() - count
+		if lagging <= 0 {
+			return 0, nil
+		}
+
+		select {
+		case <-acked:
+		case <-abort:
+			return lagging, errShutdownAborted
+		case <-timer.C:
+			return lagging, nil
+		}
+	}
+}
+
+func (s *Server) replicaCount() int {
+	s.execMu.Lock()
+	defer s.execMu.Unlock()
+
+	return len(s.replicas)
+}
+
+// saveForShutdown saves the dataset, after waiting for a background save in progress.
+// It gives up if the shutdown is aborted in the meantime.
+func (s *Server) saveForShutdown(abort <-chan struct{}) error {
+	dirty, err := s.waitBeginSave(abort)
+	if err != nil {
+		select {
+		case <-abort:
+			return errShutdownAborted
+		default:
+			return err
+		}
+	}
+
+	return s.finishSave(dirty)
+}
+
+// syncAOF writes what was appended to the AOF to disk.
+func (s *Server) syncAOF() error {
+	s.aof.mu.Lock()
+	defer s.aof.mu.Unlock()
+
+	if s.aof.file == nil {
+		return nil
+	}
+	if err := s.aof.file.Sync(); err != nil {
+		return fmt.Errorf("failed to fsync the AOF: %w", err)
+	}
+	s.aof.unsynced = false
+	return nil
+}
+
+func (s *Server) abortShutdown() bool {
+	s.shutdown.mu.Lock()
+	defer s.shutdown.mu.Unlock()
+
+	if s.shutdown.abort == nil {
+		return false
+	}
+
+	close(s.shutdown.abort)
+	s.shutdown.abort = nil
+	return true
+}
+
+// stop makes ListenAndServe close the connections and return.
+func (s *Server) stop() {
+	s.shutdown.mu.Lock()
+	s.shutdown.abort = nil
+	s.shutdown.mu.Unlock()
+
+	s.cancel()
+}
+
+// goTracked runs f in a goroutine ListenAndServe waits for before returning.
+func (s *Server) goTracked(f func()) {
+	s.goroutines.Add(1)
+	go func() {
+		defer s.goroutines.Done()
+		f()
+	}()
+}
+
+// closeOnDone closes the connection when ctx is done, unblocking its reads. The returned
+// function stops watching ctx.
+func closeOnDone(ctx context.Context, connection net.Conn) func() bool {
+	return context.AfterFunc(ctx, func() {
+		connection.Close()
+	})
+}
@@ -0,0 +1,106 @@
+package redis_test
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+// stopped reports whether the server of c stopped listening.
+func stopped(c *testConn) bool {
+	connection, err := net.Dial("tcp", c.address)
+	if err != nil {
+		return true
+	}
+	connection.Close()
+	return false
+}
+
+func TestShutdown(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands [][]string
+		args     []string
+		wantSave bool
+	}{
+		{
+			name:     "without save points",
+			args:     []string{"SHUTDOWN"},
+			wantSave: false,
+		},
+		{
+			name:     "save",
+			args:     []string{"SHUTDOWN", "SAVE"},
+			wantSave: true,
+		},
+		{
+			name:     "nosave",
+			args:     []string{"SHUTDOWN", "NOSAVE", "NOW"},
+			wantSave: false,
+		},
+		{
+			name:     "save after a background save",
+			commands: [][]string{{"BGSAVE"}},
+			args:     []string{"SHUTDOWN", "SAVE"},
+			wantSave: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			server := startServer(t, redis.WithRDB(dir, "dump.rdb"))
+			assert.Equal(t, okReply, server.do("SET", "key", "value"))
+			for _, args := range tt.commands {
+				server.do(args...)
+			}
+
+			server.send(tt.args...)
+			_, replied := server.replyWithin(5 * time.Second)
+			assert.False(t, replied)
+			assert.Eventually(t, func() bool { return stopped(server) }, 5*time.Second, 10*time.Millisecond)
+
+			_, err := os.Stat(filepath.Join(dir, "dump.rdb"))
+			assert.Equal(t, tt.wantSave, err == nil)
+		})
+	}
+}
+
+func TestShutdownArguments(t *testing.T) {
+	server := startServer(t)
+
+	for _, args := range [][]string{{"SAVE", "NOSAVE"}, {"ABORT", "NOW"}, {"LATER"}} {
+		assert.Equal(t, redis.Value{Type: redis.Error, Error: "ERR syntax error"}, server.do(append([]string{"SHUTDOWN"}, args...)...), args)
+	}
+	assert.Equal(t, redis.Value{Type: redis.Error, Error: "ERR No shutdown in progress."}, server.do("SHUTDOWN", "ABORT"))
+	assert.False(t, stopped(server))
+}
+
+func TestShutdownAbort(t *testing.T) {
+	admin := startServer(t, redis.WithShutdownTimeout(time.Minute))
+	other := admin.dial()
+
+	// A replica that never acknowledges the offset holds the shutdown back.
+	replica := admin.dial()
+	replica.send("REPLCONF", "listening-port", "6380")
+	replica.send("PSYNC", "?", "-1")
+	assert.Eventually(t, func() bool {
+		return strings.Contains(admin.do("INFO", "replication").Bulk, "connected_slaves:1")
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, okReply, admin.do("SET", "key", "value"))
+
+	admin.send("SHUTDOWN")
+	_, replied := admin.replyWithin(100 * time.Millisecond)
+	assert.False(t, replied)
+
+	assert.Equal(t, okReply, other.do("SHUTDOWN", "ABORT"))
+	assert.Equal(t, redis.Value{Type: redis.Error, Error: "ERR Errors trying to SHUTDOWN. Check logs."}, admin.reply())
+	assert.Equal(t, okReply, admin.do("SET", "key", "other"))
+	assert.False(t, stopped(admin))
+}
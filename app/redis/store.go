@@ -1,6 +1,9 @@
 package redis
 
 import (
+	"maps"
+	"math/rand/v2"
+	"slices"
 	"sync"
 	"time"
 )
@@ -9,25 +12,114 @@ type Store interface {
 	Set(key string, value string, expiryMs *int)
 	Get(key string) (string, bool)
 	Delete(key string)
+
+	GetEntry(key string) (Entry, bool)
+	// SetEntry stores the entry, replacing any existing value and expiry.
+	SetEntry(key string, entry Entry)
+	// AddEntry stores the entry only if the key does not exist yet and reports whether it did so.
+	AddEntry(key string, entry Entry) bool
+	Len() int
+	RandomKey() (string, bool)
+	// Flush removes every key. With async, the old keys are released in the background.
+	Flush(async bool)
+	// Entries returns a copy of every key that has not expired yet.
+	Entries() map[string]Entry
+	// Freeze keeps the keys as they are now until the returned function copied the ones
+	// that have not expired. The writes made in the meantime do not wait for the copy.
+	Freeze() func() map[string]Entry
+
+	// DeleteExpired deletes the key if it has expired and reports whether it did so.
+	DeleteExpired(key string) bool
+	// DeleteExpiredSample checks up to count keys with an expiry and deletes
+	// the ones that have expired, returning their names.
+	DeleteExpiredSample(count int) []string
+
+	// ExpiresLen returns the number of keys with an expiry.
+	ExpiresLen() int
+	// Stats returns the stats of the key, without it counting as an access.
+	Stats(key string) (KeyStats, bool)
+	// SetIdle records the last access to the key as idle ago, as RESTORE IDLETIME does.
+	SetIdle(key string, idle time.Duration)
+	// SetFrequency sets the access counter of the key, as RESTORE FREQ does.
+	SetFrequency(key string, frequency uint8)
+	// UsedMemory returns the approximate memory taken by the keys.
+	UsedMemory() int64
+	// Sample returns the stats of up to count random keys, only among the keys with an expiry if volatile.
+	Sample(count int, volatile bool) []KeyStats
+}
+
+// Entry is a stored value together with its expiry, as moved between stores.
+type Entry struct {
+	Value     string
+	ExpiresAt *time.Time
+}
+
+// KeyStats is the metadata of a key, as eviction and introspection commands see it.
+type KeyStats struct {
+	Key       string
+	Encoding  string
+	Size      int64
+	Idle      time.Duration
+	Frequency uint8
+	ExpiresAt *time.Time
 }
 
 type storeItem struct {
 	value     string
 	expiresAt *time.Time
+	// encoding is how Redis would represent the value internally.
+	encoding string
+
+	// size is the approximate memory taken by the key and the item.
+	size int64
+	// lru is the LRU clock of the last access.
+	lru uint32
+	// lfu is the logarithmic access counter, last decayed at the lfuTime minutes clock.
+	lfu     uint8
+	lfuTime uint16
 }
 
+const (
+	DefaultLFULogFactor = 10
+	DefaultLFUDecayTime = time.Minute
+
+	// lruClockMax wraps the LRU clock, which counts seconds, like the 24 bits Redis keeps it in.
+	lruClockMax = 1<<24 - 1
+	// lfuInitValue is the counter of new keys, so that they are not evicted before they had a chance to be accessed.
+	lfuInitValue = 5
+	// itemOverhead approximates the memory taken by a key besides its name and value: the map entry and the item.
+	itemOverhead = 64
+)
+
 type Nower func() time.Time
 
 type InMemoryStore struct {
-	data  map[string]storeItem
+	// layers hold the keys, the last one takes the writes. The ones below it are
+	// frozen while snapshots copy them, see Freeze.
+	layers []*storeLayer
+	// readers counts the snapshots still copying frozen layers.
+	readers int
+	// keys counts the keys across the layers.
+	keys int
+	// expires indexes the keys with an expiry, so that they can be sampled for active expiration.
+	expires map[string]struct{}
+	// used is the sum of the sizes of the items.
+	used  int64
 	mu    sync.RWMutex
 	nower Nower
+
+	lfuLogFactor int
+	lfuDecayTime time.Duration
 }
 
 func NewInMemoryStore(opts ...func(*InMemoryStore)) Store {
 	store := &InMemoryStore{
-		data:  map[string]storeItem{},
-		nower: time.Now,
+		layers:  []*storeLayer{newStoreLayer(false)},
+		expires: map[string]struct{}{},
+		nower:   time.Now,
+
+		lfuLogFactor: DefaultLFULogFactor,
+		lfuDecayTime: DefaultLFUDecayTime,
 	}
 
 	for _, opt := range opts {
@@ -43,6 +135,15 @@ func WithNower(nower Nower) func(*InMemoryStore) {
 	}
 }
 
+// WithLFU sets how slowly the access counters grow, and how often they are
+// decremented while the key is not accessed.
+func WithLFU(logFactor int, decayTime time.Duration) func(*InMemoryStore) {
+	return func(s *InMemoryStore) {
+		s.lfuLogFactor = logFactor
+		s.lfuDecayTime = decayTime
+	}
+}
+
 func (s *InMemoryStore) Set(key string, value string, expiryMs *int) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -56,31 +157,458 @@ func (s *InMemoryStore) Set(key string, value string, expiryMs *int) {
 	}
 
 	item.value = value
-	s.data[key] = item
+	s.put(key, item)
+}
+
+// put stores the item and keeps the expires index and the memory accounting up to date.
+// An overwritten key keeps its access counter. It must be called with mu held.
+func (s *InMemoryStore) put(key string, item storeItem) {
+	now := s.nower()
+	if old, found := s.item(key); found {
+		s.used -= old.size
+		item.lfu, item.lfuTime = old.lfu, old.lfuTime
+		s.touch(&item, now)
+	} else {
+		s.keys++
+		item.lru = lruClock(now)
+		item.lfu, item.lfuTime = lfuInitValue, lfuMinutes(now)
+	}
+
+	item.encoding = stringEncoding(item.value)
+	item.size = int64(len(key)+len(item.value)) + itemOverhead
+	s.used += item.size
+	s.top().items[key] = item
+	if item.expiresAt != nil {
+		s.expires[key] = struct{}{}
+	} else {
+		delete(s.expires, key)
+	}
 }
 
 func (s *InMemoryStore) Get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, found := s.lookupTouch(key)
+	return item.value, found
+}
+
+// lookupTouch looks the key up, recording the access. It must be called with mu held.
+func (s *InMemoryStore) lookupTouch(key string) (storeItem, bool) {
+	item, found := s.lookup(key)
+	if found {
+		s.touch(&item, s.nower())
+		s.top().items[key] = item
+	}
+
+	return item, found
+}
+
+// remove deletes the key, which must exist. It must be called with mu held.
+func (s *InMemoryStore) remove(key string) {
+	item, _ := s.item(key)
+	s.used -= item.size
+	s.keys--
+	delete(s.expires, key)
+
+	top := s.top()
+	delete(top.items, key)
+	if len(s.layers) > 1 {
+		top.removed[key] = struct{}{}
+	}
+}
+
+func (s *InMemoryStore) lookup(key string) (storeItem, bool) {
+	item, found := s.item(key)
+	if !found {
+		return storeItem{}, false
+	}
+
+	if s.expired(item) {
+		return storeItem{}, false
+	}
+
+	return item, found
+}
+
+func (s *InMemoryStore) expired(item storeItem) bool {
+	return item.expiresAt != nil && s.nower().After(*item.expiresAt)
+}
+
+func (s *InMemoryStore) GetEntry(key string) (Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, found := s.lookupTouch(key)
+	if !found {
+		return Entry{}, false
+	}
+
+	return Entry{Value: item.value, ExpiresAt: item.expiresAt}, true
+}
+
+func (s *InMemoryStore) AddEntry(key string, entry Entry) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, found := s.lookup(key); found {
+		return false
+	}
+
+	s.put(key, storeItem{value: entry.Value, expiresAt: entry.ExpiresAt})
+	return true
+}
+
+func (s *InMemoryStore) SetEntry(key string, entry Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.put(key, storeItem{value: entry.Value, expiresAt: entry.ExpiresAt})
+}
+
+func (s *InMemoryStore) Len() int {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	item, found := s.data[key]
-	if !found {
-		return "", false
+
+	return s.keys
+}
+
+func (s *InMemoryStore) RandomKey() (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Map iteration order is randomized, which is good enough for picking a key.
+	random, found := "", false
+	s.each(func(key string, item storeItem) bool {
+		if s.expired(item) {
+			return true
+		}
+
+		random, found = key, true
+		return false
+	})
+
+	return random, found
+}
+
+func (s *InMemoryStore) Flush(async bool) {
+	s.mu.Lock()
+	// The frozen layers are left to the snapshots copying them, the new top layer hides them.
+	old := s.top().items
+	s.layers[len(s.layers)-1] = newStoreLayer(len(s.layers) > 1)
+	s.expires = map[string]struct{}{}
+	s.used = 0
+	s.keys = 0
+	s.mu.Unlock()
+
+	if async {
+		go clear(old)
+		return
+	}
+
+	clear(old)
+}
+
+func (s *InMemoryStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, found := s.item(key); found {
+		s.remove(key)
 	}
+}
+
+func (s *InMemoryStore) DeleteExpired(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	if item.expiresAt == nil {
-		return item.value, found
+	item, found := s.item(key)
+	if !found || !s.expired(item) {
+		return false
 	}
 
+	s.remove(key)
+	return true
+}
+
+func (s *InMemoryStore) DeleteExpiredSample(count int) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := []string{}
+	checked := 0
+	// Map iteration order is randomized, so every call samples different keys.
+	for key := range s.expires {
+		if checked == count {
+			break
+		}
+		checked++
+
+		if item, _ := s.item(key); s.expired(item) {
+			s.remove(key)
+			deleted = append(deleted, key)
+		}
+	}
+
+	return deleted
+}
+
+func (s *InMemoryStore) Entries() map[string]Entry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.entries(s.layers, s.keys)
+}
+
+// entries copies the keys of the layers that have not expired yet.
+func (s *InMemoryStore) entries(layers []*storeLayer, size int) map[string]Entry {
+	entries := make(map[string]Entry, size)
+	eachItem(layers, func(key string, item storeItem) bool {
+		if !s.expired(item) {
+			entries[key] = Entry{Value: item.value, ExpiresAt: item.expiresAt}
+		}
+		return true
+	})
+
+	return entries
+}
+
+func (s *InMemoryStore) Freeze() func() map[string]Entry {
+	s.mu.Lock()
+	frozen := slices.Clone(s.layers)
+	size := s.keys
+	s.layers = append(s.layers, newStoreLayer(false))
+	s.readers++
+	s.mu.Unlock()
+
+	return func() map[string]Entry {
+		// The frozen layers are not written to until thawed, they are read without holding mu.
+		entries := s.entries(frozen, size)
+		s.thaw()
+		return entries
+	}
+}
+
+// thaw merges the layers back into one once no snapshot copies them anymore.
+func (s *InMemoryStore) thaw() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.readers--
+	if s.readers > 0 {
+		return
+	}
+
+	base := s.layers[0]
+	for _, layer := range s.layers[1:] {
+		if layer.flushed {
+			base.items = layer.items
+			continue
+		}
+
+		for key := range layer.removed {
+			delete(base.items, key)
+		}
+		maps.Copy(base.items, layer.items)
+	}
+	s.layers = s.layers[:1]
+}
+
+func (s *InMemoryStore) UsedMemory() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.used
+}
+
+func (s *InMemoryStore) Sample(count int, volatile bool) []KeyStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	now := s.nower()
-	if now.After(*item.expiresAt) {
-		return "", false
+	sampled := []KeyStats{}
+	add := func(key string) bool {
+		if len(sampled) == count {
+			return false
+		}
+
+		item, _ := s.item(key)
+		sampled = append(sampled, s.stats(key, item, now))
+		return true
 	}
 
-	return item.value, found
+	// Map iteration order is randomized, so every call samples different keys.
+	if volatile {
+		for key := range s.expires {
+			if !add(key) {
+				break
+			}
+		}
+	} else {
+		s.each(func(key string, _ storeItem) bool {
+			return add(key)
+		})
+	}
+
+	return sampled
 }
 
-func (s *InMemoryStore) Delete(key string) {
+func (s *InMemoryStore) ExpiresLen() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.expires)
+}
+
+func (s *InMemoryStore) Stats(key string) (KeyStats, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	item, found := s.lookup(key)
+	if !found {
+		return KeyStats{}, false
+	}
+
+	return s.stats(key, item, s.nower()), true
+}
+
+func (s *InMemoryStore) SetIdle(key string, idle time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, found := s.lookup(key)
+	if !found {
+		return
+	}
+
+	// The LRU clock wraps, so longer idle times cannot be told apart.
+	seconds := uint32(min(idle/time.Second, lruClockMax))
+	item.lru = (lruClock(s.nower()) - seconds) & lruClockMax
+	s.top().items[key] = item
+}
+
+func (s *InMemoryStore) SetFrequency(key string, frequency uint8) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	delete(s.data, key)
+
+	item, found := s.lookup(key)
+	if !found {
+		return
+	}
+
+	item.lfu, item.lfuTime = frequency, lfuMinutes(s.nower())
+	s.top().items[key] = item
+}
+
+// storeLayer holds the keys written since the layers below it were frozen.
+type storeLayer struct {
+	items map[string]storeItem
+	// removed are the keys of the layers below deleted since, flushed hides every key below.
+	removed map[string]struct{}
+	flushed bool
+}
+
+func newStoreLayer(flushed bool) *storeLayer {
+	return &storeLayer{items: map[string]storeItem{}, removed: map[string]struct{}{}, flushed: flushed}
+}
+
+// top returns the layer taking the writes. It must be called with mu held.
+func (s *InMemoryStore) top() *storeLayer {
+	return s.layers[len(s.layers)-1]
+}
+
+// item returns the item of the key, even if it expired. It must be called with mu held.
+func (s *InMemoryStore) item(key string) (storeItem, bool) {
+	for i := len(s.layers) - 1; i >= 0; i-- {
+		layer := s.layers[i]
+		if item, found := layer.items[key]; found {
+			return item, true
+		}
+		if _, removed := layer.removed[key]; removed || layer.flushed {
+			break
+		}
+	}
+
+	return storeItem{}, false
+}
+
+// each calls f with every key until it returns false. It must be called with mu held.
+func (s *InMemoryStore) each(f func(key string, item storeItem) bool) {
+	eachItem(s.layers, f)
+}
+
+// eachItem calls f with every key of the layers, in the order of map iteration,
+// until it returns false. The items of upper layers hide the ones below.
+func eachItem(layers []*storeLayer, f func(key string, item storeItem) bool) {
+	hidden := map[string]struct{}{}
+	for i := len(layers) - 1; i >= 0; i-- {
+		layer := layers[i]
+		for key, item := range layer.items {
+			if _, found := hidden[key]; found {
+				continue
+			}
+			if !f(key, item) {
+				return
+			}
+			if i > 0 {
+				hidden[key] = struct{}{}
+			}
+		}
+		if layer.flushed {
+			return
+		}
+		for key := range layer.removed {
+			hidden[key] = struct{}{}
+		}
+	}
+}
+
+// stats must be called with mu held.
+func (s *InMemoryStore) stats(key string, item storeItem, now time.Time) KeyStats {
+	return KeyStats{
+		Key:       key,
+		Encoding:  item.encoding,
+		Size:      item.size,
+		Idle:      time.Duration((lruClock(now)-item.lru)&lruClockMax) * time.Second,
+		Frequency: s.lfuDecayed(item, now),
+		ExpiresAt: item.expiresAt,
+	}
+}
+
+// touch records an access to the item at now.
+func (s *InMemoryStore) touch(item *storeItem, now time.Time) {
+	item.lru = lruClock(now)
+	item.lfu = s.lfuDecayed(*item, now)
+	item.lfuTime = lfuMinutes(now)
+
+	// The counter is a Morris counter: the more it grew, the less likely it grows further.
+	if item.lfu < 255 {
+		base := max(float64(item.lfu)-lfuInitValue, 0)
+		if rand.Float64() < 1/(base*float64(s.lfuLogFactor)+1) {
+			item.lfu++
+		}
+	}
+}
+
+// lfuDecayed returns the access counter of the item, decremented once per decay time elapsed since it was last decayed.
+func (s *InMemoryStore) lfuDecayed(item storeItem, now time.Time) uint8 {
+	decayMinutes := int(s.lfuDecayTime / time.Minute)
+	if decayMinutes <= 0 {
+		return item.lfu
+	}
+
+	elapsed := int(lfuMinutes(now) - item.lfuTime)
+	periods := elapsed / decayMinutes
+	if periods >= int(item.lfu) {
+		return 0
+	}
+
+	return item.lfu - uint8(periods)
+}
+
+func lruClock(now time.Time) uint32 {
+	return uint32(now.Unix() & lruClockMax)
+}
+
+// lfuMinutes is the minutes clock of the access counters. It wraps around, like the 16 bits Redis keeps it in.
+func lfuMinutes(now time.Time) uint16 {
+	return uint16(now.Unix() / 60)
 }
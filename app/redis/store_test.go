@@ -0,0 +1,59 @@
+package redis_test
+
+import (
+	"testing"
+
+	"github.com/codecrafters-io/redis-starter-go/app/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStoreFreeze(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes func(store redis.Store)
+		want   map[string]redis.Entry
+	}{
+		{
+			name: "writes after the freeze",
+			writes: func(store redis.Store) {
+				store.SetEntry("a", redis.Entry{Value: "2"})
+				store.SetEntry("c", redis.Entry{Value: "3"})
+				store.Delete("b")
+			},
+			want: map[string]redis.Entry{"a": {Value: "2"}, "c": {Value: "3"}},
+		},
+		{
+			name: "flush after the freeze",
+			writes: func(store redis.Store) {
+				store.Flush(false)
+				store.SetEntry("b", redis.Entry{Value: "3"})
+			},
+			want: map[string]redis.Entry{"b": {Value: "3"}},
+		},
+		{
+			name: "nested freezes",
+			writes: func(store redis.Store) {
+				store.Delete("a")
+				copyEntries := store.Freeze()
+				store.SetEntry("a", redis.Entry{Value: "4"})
+				assert.Equal(t, map[string]redis.Entry{"b": {Value: "1"}}, copyEntries())
+			},
+			want: map[string]redis.Entry{"a": {Value: "4"}, "b": {Value: "1"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := redis.NewInMemoryStore()
+			store.SetEntry("a", redis.Entry{Value: "1"})
+			store.SetEntry("b", redis.Entry{Value: "1"})
+
+			copyEntries := store.Freeze()
+			tt.writes(store)
+
+			assert.Equal(t, map[string]redis.Entry{"a": {Value: "1"}, "b": {Value: "1"}}, copyEntries())
+			assert.Equal(t, tt.want, store.Entries())
+			assert.Equal(t, len(tt.want), store.Len())
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package redis
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var memoryUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"gb", 1 << 30},
+	{"mb", 1 << 20},
+	{"kb", 1 << 10},
+	{"g", 1000 * 1000 * 1000},
+	{"m", 1000 * 1000},
+	{"k", 1000},
+	{"b", 1},
+}
+
+// ParseMemory parses a size in bytes, with the unit suffixes Redis accepts in its configuration (1k, 5gb, ...).
+func ParseMemory(input string) (int64, error) {
+	lower := strings.ToLower(strings.TrimSpace(input))
+	multiplier := int64(1)
+	for _, unit := range memoryUnits {
+		if strings.HasSuffix(lower, unit.suffix) {
+			lower = strings.TrimSuffix(lower, unit.suffix)
+			multiplier = unit.multiplier
+			break
+		}
+	}
+
+	value, err := strconv.ParseInt(lower, 10, 64)
+	if err != nil || value < 0 || value > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("invalid memory size %q", input)
+	}
+
+	return value * multiplier, nil
+}
+
+// humanMemory formats a size in bytes the way INFO shows it (512B, 1.50K, 2.00M, ...).
+func humanMemory(bytes int64) string {
+	switch {
+	case bytes < 1<<10:
+		return fmt.Sprintf("%dB", bytes)
+	case bytes < 1<<20:
+		return fmt.Sprintf("%.2fK", float64(bytes)/(1<<10))
+	case bytes < 1<<30:
+		return fmt.Sprintf("%.2fM", float64(bytes)/(1<<20))
+	default:
+		return fmt.Sprintf("%.2fG", float64(bytes)/(1<<30))
+	}
+}
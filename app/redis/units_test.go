@@ -0,0 +1,38 @@
+package redis_test
+
+import (
+	"testing"
+
+	"github.com/codecrafters-io/redis-starter-go/app/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMemory(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int64
+		wantErr bool
+	}{
+		{input: "100", want: 100},
+		{input: "1k", want: 1000},
+		{input: "5GB", want: 5 << 30},
+		{input: "8589934591gb", want: 8589934591 << 30},
+		{input: "8589934592gb", wantErr: true},
+		{input: "99999999999gb", wantErr: true},
+		{input: "-1mb", wantErr: true},
+		{input: "lots", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := redis.ParseMemory(tt.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -2,38 +2,79 @@ package main
 
 import (
 	"context"
-	"flag"
 	"log"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/codecrafters-io/redis-starter-go/app/redis"
 )
 
 const (
-	host        = "0.0.0.0"
-	defaultPort = "6379"
-)
+	host = "0.0.0.0"
 
-var (
-	port      = flag.String("port", defaultPort, "port of the server")
-	replicaof = flag.String("replicaof", "", "is replica of")
+	defaultSentinelPort = "26379"
 )
 
+// main takes an optional redis.conf file followed by --<name> <value> options, like redis-server.
 func main() {
-	flag.Parse()
+	config, err := redis.LoadConfig(os.Args[1:])
+	if err != nil {
+		log.Fatalln("Invalid configuration:", err)
+	}
+
+	if config.Bool("sentinel") {
+		runSentinel(config)
+		return
+	}
 
+	port := config.Get("port")
 	masterHost := ""
 	masterPort := ""
-	if *replicaof != "" {
-		addressParts := strings.Split(*replicaof, " ")
+	if replicaof := config.Get("replicaof"); replicaof != "" {
+		addressParts := strings.Split(replicaof, " ")
 		masterHost = addressParts[0]
 		masterPort = addressParts[1]
 	}
 
-	client := redis.NewClient(redis.NewInMemoryStore())
-	server := redis.NewServer(client, host, masterHost, *port, masterPort)
-	err := server.ListenAndServe(context.Background())
+	idgenNode := config.Int("node-id")
+	if idgenNode < 0 {
+		idgenNode = config.Int("port") % (redis.IDGenMaxNode + 1)
+	}
+
+	stores := make([]redis.Store, config.Int("databases"))
+	for i := range stores {
+		stores[i] = redis.NewInMemoryStore(redis.WithLFU(config.Int("lfu-log-factor"), time.Duration(config.Int("lfu-decay-time"))*time.Minute))
+	}
+
+	// The eviction parameters are set on the client by the server configuration.
+	client := redis.NewClient(stores, redis.WithIDGenerator(redis.NewIDGenerator(idgenNode)))
+	server := redis.NewServer(client, host, masterHost, port, masterPort, redis.WithConfig(config))
+	err = server.ListenAndServe(context.Background())
 	if err != nil {
 		log.Fatalln("Server error:", err)
 	}
 }
+
+func runSentinel(config *redis.Config) {
+	sentinelPort := config.Get("port")
+	if !config.IsSet("port") {
+		sentinelPort = defaultSentinelPort
+	}
+
+	master, err := redis.ParseSentinelMonitor(config.Get("sentinel-monitor"))
+	if err != nil {
+		log.Fatalln("Invalid sentinel-monitor:", err)
+	}
+
+	sentinel := redis.NewSentinel(host, sentinelPort,
+		redis.WithSentinelMonitor(master),
+		redis.WithSentinelDownAfter(time.Duration(config.Int("sentinel-down-after-milliseconds"))*time.Millisecond),
+		redis.WithSentinelFailoverTimeout(time.Duration(config.Int("sentinel-failover-timeout"))*time.Millisecond),
+		redis.WithSentinelPeers(strings.Fields(config.Get("sentinel-known-sentinels"))),
+	)
+	err = sentinel.ListenAndServe(context.Background())
+	if err != nil {
+		log.Fatalln("Sentinel error:", err)
+	}
+}
@@ -10,7 +10,10 @@ module github.com/codecrafters-io/redis-starter-go
 
 go 1.22
 
-require golang.org/x/sync v0.9.0
+require (
+	github.com/stretchr/testify v1.9.0
+	golang.org/x/sync v0.9.0
+)
 
 require (
 	github.com/cupcake/rdb v0.0.0-20161107195141-43ba34106c76 // indirect
@@ -18,6 +21,5 @@ require (
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/sourcegraph/conc v0.3.0 // indirect
 	github.com/stretchr/objx v0.5.2 // indirect
-	github.com/stretchr/testify v1.9.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )